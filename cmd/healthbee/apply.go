@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apply implements the apply command, which brings the sites registered with a HealthBee server in line with a
+// sites file. The planned changes are shown first, and are only applied once confirmed, and only if the server still
+// plans the same changes. Sites missing from the file are only deleted with --prune. For example
+// healthbee apply -f sites.yaml --server=http://localhost:8000
+func apply(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	file := fs.String("f", "", "Path of the sites file to apply")
+	server := fs.String("server", "http://localhost:8000", "Address of the HealthBee API server")
+	dryRun := fs.Bool("dry-run", false, "Only show the changes that would be made")
+	prune := fs.Bool("prune", false, "Delete the registered sites that are missing from the sites file")
+	yes := fs.Bool("yes", false, "Apply the changes without asking for confirmation")
+	_ = fs.Parse(args)
+	if *file == "" || fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Usage: healthbee apply -f <sites.yaml> [--server=<address>] [--dry-run] [--prune] [--yes]")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime)
+
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		errorLog.Fatal("apply: ", err.Error())
+	}
+	// catch mistakes in the file before involving the server
+	if _, err := pkg.ParseSitesFile(data); err != nil {
+		errorLog.Fatal(err.Error())
+	}
+
+	plan, err := postSitesFile(*server, data, true, *prune, "")
+	if err != nil {
+		errorLog.Fatal(err.Error())
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Printf("No changes, the registered sites are in line with %s\n", *file)
+		return
+	}
+	fmt.Printf("Planned changes to bring the registered sites in line with %s:\n", *file)
+	printChanges(os.Stdout, plan.Changes)
+	if *dryRun {
+		return
+	}
+	if !*yes {
+		fmt.Printf("Apply these %d changes? [y/N] ", len(plan.Changes))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Cancelled, no changes were applied")
+			return
+		}
+	}
+
+	// the changes are planned again by the server, and are only applied if they are still the ones confirmed
+	res, err := postSitesFile(*server, data, false, *prune, plan.Plan)
+	if res != nil {
+		fmt.Printf("Applied %d of %d changes\n", res.Applied, len(res.Changes))
+	}
+	if err != nil {
+		errorLog.Fatal(err.Error())
+	}
+}
+
+// postSitesFile posts a sites file to the server, which plans and possibly applies the changes it calls for, as long
+// as they match the given plan if any
+func postSitesFile(server string, data []byte, dryRun, prune bool, plan string) (*pkg.ApplyResult, error) {
+	client := &http.Client{Timeout: time.Minute}
+	q := url.Values{}
+	q.Set("dry_run", strconv.FormatBool(dryRun))
+	q.Set("prune", strconv.FormatBool(prune))
+	if plan != "" {
+		q.Set("plan", plan)
+	}
+	u := fmt.Sprintf("%s/sites:apply?%s", strings.TrimSuffix(server, "/"), q.Encode())
+	resp, err := client.Post(u, "application/yaml", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("apply: %w", err)
+	}
+	defer resp.Body.Close()
+	res := &pkg.ApplyResult{}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, fmt.Errorf("apply: unexpected response from %s: %s", server, resp.Status)
+	}
+	if res.Error != "" {
+		return res, errors.New(res.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("apply: unexpected response from %s: %s", server, resp.Status)
+	}
+	return res, nil
+}
+
+// changeSymbols prefix the planned changes, like a diff
+var changeSymbols = map[string]string{
+	pkg.ActionCreate: "+",
+	pkg.ActionUpdate: "~",
+	pkg.ActionPause:  "~",
+	pkg.ActionResume: "~",
+	pkg.ActionDelete: "-",
+}
+
+func printChanges(w io.Writer, changes []*pkg.SiteChange) {
+	for _, c := range changes {
+		fmt.Fprintf(w, "  %s %s\n", changeSymbols[c.Action], c)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/dnataraj/healthbee/pkg/models/postgres"
+	"github.com/dnataraj/healthbee/pkg/models/sqlite"
+	"io"
+	"log"
+	"net/url"
+	"os"
+)
+
+// export implements the export command, which streams the results of a site from the database to a file, or to
+// the standard output if none is given. For example
+// healthbee export --dsn=... --site=1 --format=parquet --from=2021-03-01T00:00:00Z --output=site-1.parquet
+func export(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dsn := fs.String("dsn", "", "DSN/Connection string for the PostgreSQL database, or the path of the SQLite database")
+	store := fs.String("store", "postgres", "Store for sites and results, either postgres or sqlite")
+	siteID := fs.Int("site", 0, "ID of the site whose results are exported")
+	format := fs.String("format", pkg.FormatCSV, "Export format, either csv, ndjson or parquet")
+	from := fs.String("from", "", "RFC 3339 timestamp to export results from, the oldest result if not set")
+	to := fs.String("to", "", "RFC 3339 timestamp to export results up to, the latest result if not set")
+	output := fs.String("output", "", "Path of the file results are exported to, the standard output if not set")
+	_ = fs.Parse(args)
+	if *siteID < 1 || fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Usage: healthbee export --site=<id> [--format=csv|ndjson|parquet] [--from=<time>] [--to=<time>] [--output=<path>]")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	// results may be exported to the standard output, so logs go to the standard error
+	infoLog := log.New(os.Stderr, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	filter, err := models.ParseResultFilter(url.Values{"from": {*from}, "to": {*to}, "sort": {"asc"}})
+	if err != nil {
+		errorLog.Fatal("export: ", err.Error())
+	}
+
+	var results models.ResultStore
+	switch *store {
+	case "postgres":
+		db, err := pkg.OpenDB(*dsn)
+		if err != nil {
+			errorLog.Fatal("export: unable to connect to database: ", err.Error())
+		}
+		defer db.Close()
+		results = &postgres.ResultModel{DB: db}
+	case "sqlite":
+		db, err := sqlite.Open(*dsn)
+		if err != nil {
+			errorLog.Fatal("export: unable to open database: ", err.Error())
+		}
+		defer db.Close()
+		results = &sqlite.ResultModel{DB: db}
+	default:
+		errorLog.Fatalf("export: unknown store %q, expecting postgres or sqlite", *store)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			errorLog.Fatal("export: ", err.Error())
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	e, err := pkg.NewExporter(*format, w)
+	if err != nil {
+		errorLog.Fatal(err.Error())
+	}
+	n := 0
+	err = results.Export(*siteID, filter, func(res *models.CheckResult) error {
+		n++
+		return e.Write(res)
+	})
+	if err == nil {
+		err = e.Close()
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		errorLog.Fatalf("export: stopped after exporting %d results: %s", n, err.Error())
+	}
+	infoLog.Printf("export: exported %d results of site [%d]", n, *siteID)
+}
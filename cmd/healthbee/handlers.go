@@ -2,19 +2,28 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/dnataraj/healthbee/pkg"
 	"github.com/dnataraj/healthbee/pkg/models"
 	"github.com/gorilla/mux"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 // monitor is a POST HTTP handler that accepts a JSON payload and creates a site entry,
 // and initiates the monitoring for this site
 // The handler expects the request body to have the following schema
-// { "url": <string>, "period": <int>, "pattern": <string> }
-// Duplicate site registrations are not allowed and results in a HTTP 409
+// { "url": <string>, "period": <int>, "pattern": <string>, "parents": [<int>], "labels": {<string>: <string>},
+// "retention": <string> }
+// Heartbeat sites are registered with { "type": "heartbeat", "interval": <string>, "grace": <string> } and are
+// given a token, with which they are expected to ping /heartbeat/{token} every interval.
+// Duplicate site registrations are not allowed and results in a HTTP 409, while depending on unknown sites, or on
+// the site itself, results in a HTTP 422
 func (app *application) monitor(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		app.list(w, r)
@@ -28,40 +37,571 @@ func (app *application) monitor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// generate an entry for site in the database
-	site.ID, err = app.sites.Insert(site.URL, site.Interval, site.Pattern)
-	if err != nil {
-		if errors.Is(err, models.ErrDuplicateSite) {
-			app.clientError(w, http.StatusConflict)
+	if site.Type == models.SiteHeartbeat {
+		site.Token, err = pkg.NewToken()
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		site.URL = "/heartbeat/" + site.Token
+	} else {
+		site.Type = models.SiteHTTP
+	}
+	// generate an entry for site in the database, along with its parents, labels and retention, so that a site is
+	// either registered completely or not at all
+	if err := app.sites.InsertBatch([]*models.Site{&site}); err != nil {
+		if errors.Is(err, models.ErrInvalidDependency) || errors.Is(err, models.ErrDependencyCycle) {
+			app.clientError(w, http.StatusUnprocessableEntity)
 		} else {
 			app.serverError(w, err)
 		}
 		return
 	}
+	if site.ID == 0 {
+		app.clientError(w, http.StatusConflict)
+		return
+	}
+	app.setDependencies(&site)
 	// if successful, initiate checks
-	mon := app.NewMonitor(&site)
-	app.infoLog.Printf("starting HealthBee for site: %d", site.ID)
-	mon.Start(app.wg)
+	if !site.Paused {
+		mon := app.NewMonitor(&site)
+		app.infoLog.Printf("starting HealthBee for site: %d", site.ID)
+		mon.Start(app.wg)
+	}
+	app.publishEvent(pkg.EventRegistered, &site)
 
 	w.Header().Add("Location", fmt.Sprintf("/monitor/%d", site.ID))
 	app.respond(w, site, http.StatusCreated)
 }
 
-// list is a GET HTTP handler that returns a list of registered sites up to a maximum of 20
+// list is a GET HTTP handler that returns a page of registered sites, newest first
+// Sites can be filtered by their labels with a selector, for example ?selector=team=payments,env!=staging
+// Pages hold 20 sites unless ?limit= asks for up to 500, and the next page is linked to in the Link header
 func (app *application) list(w http.ResponseWriter, r *http.Request) {
-	sites, err := app.sites.GetAll()
+	q := r.URL.Query()
+	sel, err := models.ParseSelector(q.Get("selector"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	page, err := models.ParsePage(q.Get("limit"), q.Get("cursor"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	sites, next, err := app.sites.GetAll(sel, page)
 	if err != nil {
 		app.serverError(w, err)
+		return
 	}
+	linkNext(w, r, next)
 	app.respond(w, sites, http.StatusOK)
 }
 
-// stop is a POST HTTP handler that stops a monitor for a given site
+// stop is a POST HTTP handler that stops a monitor for a given site, and responds with the site
+// The site is paused, so that it keeps its results and is not resumed when HealthBee is restarted
 func (app *application) stop(w http.ResponseWriter, r *http.Request) {
-	app.respond(w, "{}", http.StatusNotImplemented)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	site, err := app.sites.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	if !site.Paused {
+		if err := app.pauseSite(site); err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	app.respond(w, site, http.StatusOK)
+}
+
+// maxBatchSize caps the number of sites registered in a single batch
+const maxBatchSize = 500
+
+// Outcomes of registering the sites of a batch
+const (
+	batchCreated   = "created"
+	batchDuplicate = "duplicate"
+	batchInvalid   = "invalid"
+)
+
+// batchResult is the outcome of registering one of the sites of a batch, along with the site once it is created
+type batchResult struct {
+	Status string       `json:"status"`
+	Site   *models.Site `json:"site,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// registerSites is a POST HTTP handler that registers a batch of up to 500 sites, given as a JSON array of sites
+// with the same schema as POST /sites, and initiates their monitoring. The sites are stored together, along with
+// their parents, and the handler responds with the outcome of each site, in order: "created", "duplicate" if the URL
+// is already registered (or appears earlier in the batch), or "invalid" along with the reason. Batches that are not
+// an array, or are too large, result in a HTTP 400, and a HTTP 422 if a parent is removed while they are stored, or
+// if the parents of the sites form a cycle
+func (app *application) registerSites(w http.ResponseWriter, r *http.Request) {
+	var items []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		app.errorLog.Print("error processing request: ", err.Error())
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if len(items) > maxBatchSize {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*batchResult, len(items))
+	batch := make([]*models.Site, 0, len(items))
+	positions := make([]int, 0, len(items))
+	urls := make(map[string]bool)
+	known := make(map[int]bool)
+	invalid := func(i int, err error) {
+		results[i] = &batchResult{Status: batchInvalid, Error: err.Error()}
+	}
+	for i, item := range items {
+		site := &models.Site{}
+		if err := json.Unmarshal(item, site); err != nil {
+			invalid(i, err)
+			continue
+		}
+		if err := site.OK(); err != nil {
+			invalid(i, err)
+			continue
+		}
+		p, err := app.unknownParent(site.Parents, known)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if p != 0 {
+			invalid(i, fmt.Errorf("%w: [%d]", models.ErrInvalidDependency, p))
+			continue
+		}
+		if site.Type == models.SiteHeartbeat {
+			token, err := pkg.NewToken()
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			site.Token, site.URL = token, "/heartbeat/"+token
+		} else {
+			site.Type = models.SiteHTTP
+		}
+		if urls[site.URL] {
+			results[i] = &batchResult{Status: batchDuplicate, Error: models.ErrDuplicateSite.Error()}
+			continue
+		}
+		urls[site.URL] = true
+		batch = append(batch, site)
+		positions = append(positions, i)
+	}
+
+	// parents removed since they were checked, or parents forming a cycle, fail the whole batch, as it is stored in a
+	// single transaction
+	if err := app.sites.InsertBatch(batch); err != nil {
+		if errors.Is(err, models.ErrInvalidDependency) || errors.Is(err, models.ErrDependencyCycle) {
+			app.clientError(w, http.StatusUnprocessableEntity)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	created := 0
+	for j, site := range batch {
+		i := positions[j]
+		if site.ID == 0 {
+			results[i] = &batchResult{Status: batchDuplicate, Error: models.ErrDuplicateSite.Error()}
+			continue
+		}
+		created++
+		results[i] = &batchResult{Status: batchCreated, Site: site}
+		app.setDependencies(site)
+		if !site.Paused {
+			app.NewMonitor(site).Start(app.wg)
+		}
+		app.publishEvent(pkg.EventRegistered, site)
+	}
+	app.infoLog.Printf("server: registered %d of a batch of %d sites", created, len(items))
+	app.respond(w, results, http.StatusOK)
+}
+
+// unknownParent returns the first of the parents of a site that is not registered, if any. Known parents are
+// remembered, so that they are only looked up once
+func (app *application) unknownParent(parents []int, known map[int]bool) (int, error) {
+	for _, p := range parents {
+		if known[p] {
+			continue
+		}
+		if _, err := app.sites.Get(p); err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				return p, nil
+			}
+			return 0, err
+		}
+		known[p] = true
+	}
+	return 0, nil
+}
+
+// stopSites is a POST HTTP handler that stops monitoring every site matching a label selector, such as
+// ?selector=team=payments, and responds with the sites that were stopped. Sites are paused like with
+// POST /sites/{id}/stop. The selector is required, so that every site is not stopped by mistake
+func (app *application) stopSites(w http.ResponseWriter, r *http.Request) {
+	app.forSelected(w, r, func(site *models.Site) (bool, error) {
+		if site.Paused {
+			return false, nil
+		}
+		return true, app.pauseSite(site)
+	})
+}
+
+// deleteSites is a DELETE HTTP handler that removes every site matching a label selector, such as
+// ?selector=team=payments, along with their results, and responds with the sites that were removed.
+// The selector is required, so that every site is not removed by mistake
+func (app *application) deleteSites(w http.ResponseWriter, r *http.Request) {
+	app.forSelected(w, r, func(site *models.Site) (bool, error) {
+		return true, app.deleteSite(site)
+	})
+}
+
+// forSelected applies fn to every site matching the required label selector of a request, and responds with the
+// sites that fn reports as changed. Missing or invalid selectors result in a HTTP 400
+func (app *application) forSelected(w http.ResponseWriter, r *http.Request, fn func(site *models.Site) (bool, error)) {
+	q := r.URL.Query().Get("selector")
+	sel, err := models.ParseSelector(q)
+	if err != nil || q == "" {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	sites, err := app.allSites(sel)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	changed := make([]*models.Site, 0, len(sites))
+	for _, site := range sites {
+		ok, err := fn(site)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+		if ok && err == nil {
+			changed = append(changed, site)
+		}
+	}
+	app.respond(w, changed, http.StatusOK)
+}
+
+// maxSitesFileSize caps the size of the sites files posted to the API
+const maxSitesFileSize = 1 << 20
+
+// applySites is a POST HTTP handler that brings the registered sites in line with a sites file, posted as YAML,
+// and responds with the changes made to them along with the hash of the plan. With ?dry_run=true the changes are
+// only planned, and with ?prune=true the sites missing from the file are deleted. Given ?plan=<hash> of an earlier
+// dry run, the changes are only applied if they are still the same, and result in a HTTP 409 otherwise.
+// Invalid sites files result in a HTTP 400 with the reason, while a failed change stops the remaining ones and
+// results in a HTTP 409 if the URL is registered outside the selector of the file, or a HTTP 500 otherwise
+func (app *application) applySites(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var dryRun, prune bool
+	for name, v := range map[string]*bool{"dry_run": &dryRun, "prune": &prune} {
+		if s := q.Get(name); s != "" {
+			var err error
+			if *v, err = strconv.ParseBool(s); err != nil {
+				app.clientError(w, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSitesFileSize))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	f, err := pkg.ParseSitesFile(data)
+	if err != nil {
+		app.respond(w, pkg.ApplyResult{DryRun: dryRun, Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	changes, applied, err := app.planSites(f, prune, dryRun, q.Get("plan"))
+	if err != nil && changes == nil {
+		app.serverError(w, err)
+		return
+	}
+	res := pkg.ApplyResult{DryRun: dryRun, Changes: changes, Plan: pkg.PlanHash(changes), Applied: applied}
+	status := http.StatusOK
+	if err != nil {
+		app.errorLog.Print(err.Error())
+		res.Error, status = err.Error(), http.StatusInternalServerError
+		if errors.Is(err, models.ErrDuplicateSite) || errors.Is(err, pkg.ErrPlanChanged) {
+			status = http.StatusConflict
+		}
+	}
+	app.respond(w, res, status)
+}
+
+// heartbeat is a POST HTTP handler that records a ping from a heartbeat site, such as a cron job reporting
+// that it completed. Unknown tokens result in a HTTP 404
+func (app *application) heartbeat(w http.ResponseWriter, r *http.Request) {
+	at := time.Now()
+	site, err := app.sites.GetByToken(mux.Vars(r)["token"])
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.Mutex.Lock()
+	m, ok := app.monitors[site.ID]
+	app.Mutex.Unlock()
+	if !ok {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	m.Ping(at)
+	app.respond(w, "{}", http.StatusOK)
+}
+
+// setParents is a PUT HTTP handler that replaces the sites a site depends on
+// The handler expects the request body to have the following schema
+// { "parents": [<int>] }
+// Failures of a site while one of its parents is down are recorded as unreachable, and are not alerted on.
+// Dependencies that would form a cycle result in a HTTP 409, unknown parents in a HTTP 422
+func (app *application) setParents(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	deps := struct {
+		Parents []int `json:"parents"`
+	}{}
+	if err := decode(r, &deps); err != nil {
+		app.errorLog.Print("error processing request: ", err.Error())
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	site, err := app.sites.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if err := app.updateParents(id, deps.Parents); err != nil {
+		switch {
+		case errors.Is(err, models.ErrDependencyCycle):
+			app.clientError(w, http.StatusConflict)
+		case errors.Is(err, models.ErrInvalidDependency):
+			app.clientError(w, http.StatusUnprocessableEntity)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+	site.Parents = deps.Parents
+	app.publishEvent(pkg.EventUpdated, site)
+	app.respond(w, site, http.StatusOK)
+}
+
+// setLabels is a PUT HTTP handler that replaces the labels of a site
+// The handler expects the request body to be a JSON object of label keys and values, for example
+// { "team": "payments", "env": "prod" }
+func (app *application) setLabels(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	labels := make(map[string]string)
+	if err := decode(r, &labels); err != nil {
+		app.errorLog.Print("error processing request: ", err.Error())
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if err := models.ValidateLabels(labels); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if err := app.sites.SetLabels(id, labels); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.Mutex.Lock()
+	if m, ok := app.monitors[id]; ok {
+		m.SetLabels(labels)
+	}
+	app.Mutex.Unlock()
+
+	if app.events != nil {
+		if site, err := app.sites.Get(id); err != nil {
+			app.errorLog.Printf("events: unable to fetch site [%d] for its update event: %s", id, err.Error())
+		} else {
+			app.publishEvent(pkg.EventUpdated, site)
+		}
+	}
+	app.respond(w, labels, http.StatusOK)
+}
+
+// setRetention is a PUT HTTP handler that sets how long the raw results of a site are kept for, overriding the
+// default retention. The handler expects the request body to have the following schema
+// { "retention": <string> }
+// A retention of "0s" resets the site to the default retention, while retentions shorter than 72h result in a
+// HTTP 400
+func (app *application) setRetention(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	body := struct {
+		Retention models.Period `json:"retention"`
+	}{}
+	if err := decode(r, &body); err != nil {
+		app.errorLog.Print("error processing request: ", err.Error())
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if err := models.ValidateRetention(body.Retention); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if err := app.sites.SetRetention(id, body.Retention); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	if app.events != nil {
+		if site, err := app.sites.Get(id); err != nil {
+			app.errorLog.Printf("events: unable to fetch site [%d] for its update event: %s", id, err.Error())
+		} else {
+			app.publishEvent(pkg.EventUpdated, site)
+		}
+	}
+	app.respond(w, body, http.StatusOK)
+}
+
+// getRollups is a GET HTTP handler that returns the results of a site aggregated by the hour, for ranges of up to
+// a week, or by the day, for example ?from=2021-03-01T00:00:00Z&to=2021-03-02T00:00:00Z
+// The range defaults to the last 24 hours. Aggregates are read from the raw results while they are kept, and from
+// the rolled up aggregates after that. Invalid ranges result in a HTTP 400
+func (app *application) getRollups(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	to, from := time.Now().UTC(), time.Time{}
+	q := r.URL.Query()
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	from = to.Add(-24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	site, err := app.sites.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	resolution, rollups, err := app.rollupsFor(site, from, to)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.respond(w, struct {
+		SiteID     int              `json:"site_id"`
+		Resolution string           `json:"resolution"`
+		From       time.Time        `json:"from"`
+		To         time.Time        `json:"to"`
+		Rollups    []*models.Rollup `json:"rollups"`
+	}{id, resolution, from, to, rollups}, http.StatusOK)
+}
+
+// getStats is a GET HTTP handler that returns the uptime, check and failure counts and response time percentiles of
+// a site over a window, for each step of the window and for the whole window, for example ?window=24h&step=1h
+// The window defaults to the last 24 hours in steps of an hour, and must be a multiple of the step. Steps before the
+// retention of the site are summarised from the rolled up aggregates. Invalid windows result in a HTTP 400
+func (app *application) getStats(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now()
+	window, err := models.ParseStatsWindow(q.Get("window"), q.Get("step"), now)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	site, err := app.sites.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	buckets, total, err := app.statsFor(site, window, now)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.respond(w, struct {
+		SiteID  int             `json:"site_id"`
+		From    time.Time       `json:"from"`
+		To      time.Time       `json:"to"`
+		Step    models.Period   `json:"step"`
+		Total   *models.Stats   `json:"total"`
+		Buckets []*models.Stats `json:"buckets"`
+	}{id, window.From, window.To, models.Period(window.Step), total, buckets}, http.StatusOK)
 }
 
-// getMetrics returns a list of the last 20 metrics for the given site
+// getMetrics returns a page of the latest metrics for the given site, paginated like the list of sites.
+// Metrics can be narrowed down to a time range and filtered with the from, to, status, matched, failure and
+// min_response_time query parameters, and listed oldest first with sort=asc
 func (app *application) getMetrics(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
@@ -70,8 +610,18 @@ func (app *application) getMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metrics, err := app.results.GetResultsForSite(id)
+	q := r.URL.Query()
+	page, err := models.ParsePage(q.Get("limit"), q.Get("cursor"))
 	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	filter, err := models.ParseResultFilter(q)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if _, err := app.sites.Get(id); err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.clientError(w, http.StatusNotFound)
 		} else {
@@ -79,6 +629,288 @@ func (app *application) getMetrics(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	metrics, next, err := app.results.Find(id, filter, page)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
+	linkNext(w, r, next)
 	app.respond(w, metrics, http.StatusOK)
 }
+
+// exportResults is a GET HTTP handler that streams the results of a site in the csv, ndjson or parquet format, for
+// example ?format=csv&from=2021-03-01T00:00:00Z&to=2021-03-08T00:00:00Z
+// Results can be filtered like the metrics of a site, and are exported oldest first unless sort=desc. Unknown formats
+// and invalid filters result in a HTTP 400. Errors once the export has started abort the response, so that a
+// truncated export is not mistaken for a complete one
+func (app *application) exportResults(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = pkg.FormatCSV
+	}
+	contentType, ok := pkg.ExportContentTypes[format]
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	filter, err := models.ParseResultFilter(q)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	filter.Ascending = q.Get("sort") != "desc"
+	if _, err := app.sites.Get(id); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="site-%d-results.%s"`, id, format))
+	e, err := pkg.NewExporter(format, w)
+	if err == nil {
+		err = app.results.Export(id, filter, e.Write)
+	}
+	if err == nil {
+		err = e.Close()
+	}
+	if err != nil {
+		app.errorLog.Printf("export: unable to export the results of site [%d]: %s", id, err.Error())
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// addWindow is a POST HTTP handler that registers a maintenance window for a site, or for all sites matching
+// a label selector. The handler expects the request body to have the following schema
+// { "site_id": <int>, "selector": <string>, "start": <timestamp>, "duration": <string>, "schedule": <string>, "reason": <string> }
+// The schedule is optional and can be a cron expression or an RRULE for recurring windows
+func (app *application) addWindow(w http.ResponseWriter, r *http.Request) {
+	window := models.MaintenanceWindow{}
+	err := decode(r, &window)
+	if err != nil {
+		app.errorLog.Print("error processing request: ", err.Error())
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if window.Start.IsZero() {
+		window.Start = time.Now().UTC()
+	}
+	if err := pkg.ParseRecurrence(&window); err != nil {
+		app.errorLog.Print("error processing request: ", err.Error())
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if window.SiteID > 0 {
+		if _, err := app.sites.Get(window.SiteID); err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.clientError(w, http.StatusNotFound)
+			} else {
+				app.serverError(w, err)
+			}
+			return
+		}
+	}
+
+	window.ID, err = app.maintenance.Insert(window.SiteID, window.Selector, window.Start, window.Duration, window.Schedule, window.Reason)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	window.Created = time.Now().UTC()
+	if err := app.schedule.Add(&window); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.infoLog.Printf("maintenance: window [%d] registered for site [%d] or selector [%s]", window.ID, window.SiteID, window.Selector)
+
+	w.Header().Add("Location", fmt.Sprintf("/maintenance/%d", window.ID))
+	app.respond(w, window, http.StatusCreated)
+}
+
+// listWindows is a GET HTTP handler that returns all registered maintenance windows
+func (app *application) listWindows(w http.ResponseWriter, r *http.Request) {
+	windows, err := app.maintenance.GetAll()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.respond(w, windows, http.StatusOK)
+}
+
+// removeWindow is a DELETE HTTP handler that removes a maintenance window, ending it if it is in effect
+func (app *application) removeWindow(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	if err := app.maintenance.Delete(id); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	app.schedule.Remove(id)
+	app.respond(w, nil, http.StatusNoContent)
+}
+
+// addGroup is a POST HTTP handler that registers a site group
+// The handler expects the request body to have the following schema
+// { "name": <string>, "rule": "all"|"any"|"quorum", "quorum": <int>, "sites": [<int>] }
+// Duplicate group names result in a HTTP 409, unknown member sites in a HTTP 422
+func (app *application) addGroup(w http.ResponseWriter, r *http.Request) {
+	group := models.Group{}
+	err := decode(r, &group)
+	if err != nil {
+		app.errorLog.Print("error processing request: ", err.Error())
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	group.ID, err = app.groups.Insert(group.Name, group.Rule, group.Quorum, group.Sites)
+	if err != nil {
+		app.groupError(w, err)
+		return
+	}
+	group.Created = time.Now().UTC()
+
+	w.Header().Add("Location", fmt.Sprintf("/groups/%d", group.ID))
+	app.respond(w, group, http.StatusCreated)
+}
+
+// listGroups is a GET HTTP handler that returns all site groups
+func (app *application) listGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := app.groups.GetAll()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.respond(w, groups, http.StatusOK)
+}
+
+// getGroup is a GET HTTP handler that returns the definition of a site group
+func (app *application) getGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.lookupGroup(w, r)
+	if !ok {
+		return
+	}
+	app.respond(w, group, http.StatusOK)
+}
+
+// updateGroup is a PUT HTTP handler that replaces the definition of a site group
+// The request body follows the same schema as for registering a group
+func (app *application) updateGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	group := models.Group{}
+	if err := decode(r, &group); err != nil {
+		app.errorLog.Print("error processing request: ", err.Error())
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if err := app.groups.Update(id, group.Name, group.Rule, group.Quorum, group.Sites); err != nil {
+		app.groupError(w, err)
+		return
+	}
+	app.getGroup(w, r)
+}
+
+// removeGroup is a DELETE HTTP handler that removes a site group along with its history
+func (app *application) removeGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	if err := app.groups.Delete(id); err != nil {
+		app.groupError(w, err)
+		return
+	}
+	app.respond(w, nil, http.StatusNoContent)
+}
+
+// getGroupStatus is a GET HTTP handler that returns the current health of a site group, computed from
+// the latest result of each of its members
+func (app *application) getGroupStatus(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.lookupGroup(w, r)
+	if !ok {
+		return
+	}
+	gs, err := app.groupStatus(group, time.Now().UTC())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.respond(w, gs, http.StatusOK)
+}
+
+// getGroupHistory is a GET HTTP handler that returns the last 20 changes in the health of a site group
+func (app *application) getGroupHistory(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.lookupGroup(w, r)
+	if !ok {
+		return
+	}
+	history, err := app.groups.GetHistory(group.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.respond(w, history, http.StatusOK)
+}
+
+// lookupGroup fetches the site group identified in the request path, responding with an error if there is none
+func (app *application) lookupGroup(w http.ResponseWriter, r *http.Request) (*models.Group, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return nil, false
+	}
+	group, err := app.groups.Get(id)
+	if err != nil {
+		app.groupError(w, err)
+		return nil, false
+	}
+	return group, true
+}
+
+// listDeadLetters is a GET HTTP handler that lists the most recent messages on the dead letter queue, newest first.
+// The number of messages is given by the optional limit query parameter, 20 by default and at most 500.
+// Without a Kafka pipeline there is no dead letter queue, which results in a HTTP 404
+func (app *application) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if app.deadLetters == nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 500 {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	letters, err := app.deadLetters.Browse(ctx, limit)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.respond(w, letters, http.StatusOK)
+}
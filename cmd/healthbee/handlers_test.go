@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dnataraj/healthbee/pkg"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/dnataraj/healthbee/pkg/models/sqlite"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestApplication returns an application backed by a SQLite database in a temporary directory, holding the
+// given sites
+func newTestApplication(t *testing.T, sites ...*models.Site) *application {
+	dir, err := ioutil.TempDir("", "healthbee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlite.Open(filepath.Join(dir, "healthbee.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{
+		errorLog:     log.New(ioutil.Discard, "", 0),
+		infoLog:      log.New(ioutil.Discard, "", 0),
+		sites:        &sqlite.SiteModel{DB: db},
+		results:      &sqlite.ResultModel{DB: db},
+		monitors:     make(map[int]*pkg.Monitor),
+		schedule:     pkg.NewSchedule(),
+		dependencies: pkg.NewDependencies(),
+		metrics:      pkg.NewSiteMetrics(),
+		publisher:    pkg.NewMemoryPublisher(100),
+		wg:           &sync.WaitGroup{},
+	}
+	t.Cleanup(func() {
+		app.Mutex.Lock()
+		for _, m := range app.monitors {
+			m.Cancel()
+		}
+		app.Mutex.Unlock()
+		app.wg.Wait()
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	if err := app.sites.InsertBatch(sites); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func serve(app *application, method, target, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rr
+}
+
+func TestRegisterSites(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	app := newTestApplication(t, &models.Site{URL: "https://www.example.com", Interval: models.Period(time.Minute), Paused: true})
+
+	rr := serve(app, http.MethodPost, "/sites:batch", `[
+		{"url": "`+srv.URL+`/a", "interval": "30s", "parents": [1]},
+		{"url": "https://www.example.org", "interval": "30s", "labels": {"team": "payments"}, "paused": true},
+		{"url": "https://www.example.com", "interval": "30s"},
+		{"url": "`+srv.URL+`/a", "interval": "1m"},
+		{"url": "https://www.example.net"},
+		{"url": "https://www.example.net", "interval": "30s", "pattern": "("},
+		{"url": "https://www.example.net", "interval": "30s", "parents": [99]},
+		{"url": "https://www.example.net", "interval": "soon"}
+	]`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want %d, got %d", http.StatusOK, rr.Code)
+	}
+	var results []*batchResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{batchCreated, batchCreated, batchDuplicate, batchDuplicate, batchInvalid, batchInvalid, batchInvalid, batchInvalid}
+	got := make([]string, len(results))
+	for i, res := range results {
+		got[i] = res.Status
+		if (res.Status == batchCreated) != (res.Site != nil && res.Error == "") {
+			t.Errorf("want site %d with a site only if created, got %+v", i+1, res)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+
+	// the sites are stored along with their parents, and only the sites that are not paused are monitored
+	site, err := app.sites.Get(results[0].Site.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(site.Parents, []int{1}) {
+		t.Errorf("want parents [1], got %v", site.Parents)
+	}
+	app.Mutex.Lock()
+	_, first := app.monitors[results[0].Site.ID]
+	_, second := app.monitors[results[1].Site.ID]
+	app.Mutex.Unlock()
+	if !first || second {
+		t.Errorf("want only the first site monitored, got %t and %t", first, second)
+	}
+
+	for _, body := range []string{`{"url": "https://www.example.com", "interval": "30s"}`, `[`} {
+		if rr := serve(app, http.MethodPost, "/sites:batch", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("want %d for %s, got %d", http.StatusBadRequest, body, rr.Code)
+		}
+	}
+
+	// sites registered one at a time are validated the same way
+	if rr := serve(app, http.MethodPost, "/sites", `{"url": "https://www.example.net", "interval": "30s", "pattern": "("}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("want %d for an invalid pattern, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestRegisterSites_cycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		// body registers sites depending on themselves, the next site being given ID 2
+		body       string
+		wantStatus int
+	}{
+		{
+			name: "Self parent", target: "/sites",
+			body:       `{"url": "https://www.example.org", "interval": "30s", "parents": [1, 2]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		// the parents of a batch are expected to be registered already, so the sites are reported invalid instead
+		{
+			name: "Self parent in a batch", target: "/sites:batch",
+			body:       `[{"url": "https://www.example.org", "interval": "30s", "parents": [1, 2]}]`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "Mutual parents in a batch", target: "/sites:batch",
+			body: `[
+				{"url": "https://www.example.org", "interval": "30s", "parents": [3]},
+				{"url": "https://www.example.net", "interval": "30s", "parents": [2]}
+			]`,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, &models.Site{URL: "https://www.example.com", Interval: models.Period(time.Minute), Paused: true})
+			rr := serve(app, http.MethodPost, tt.target, tt.body)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if rr.Code == http.StatusOK {
+				var results []*batchResult
+				if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+					t.Fatal(err)
+				}
+				for i, res := range results {
+					if res.Status != batchInvalid {
+						t.Errorf("want site %d invalid, got %+v", i+1, res)
+					}
+				}
+			}
+			if _, err := app.sites.Get(2); err != models.ErrNoRecord {
+				t.Errorf("want no site registered, got %v", err)
+			}
+			app.Mutex.Lock()
+			n := len(app.monitors)
+			app.Mutex.Unlock()
+			if n != 0 {
+				t.Errorf("want no site monitored, got %d", n)
+			}
+		})
+	}
+}
+
+func TestStopAndDeleteSites(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	payments := map[string]string{"team": "payments"}
+	newSites := func() []*models.Site {
+		return []*models.Site{
+			{URL: srv.URL + "/a", Interval: models.Period(time.Minute), Labels: payments, Paused: true},
+			{URL: srv.URL + "/b", Interval: models.Period(time.Minute), Labels: payments},
+			{URL: srv.URL + "/c", Interval: models.Period(time.Minute), Labels: map[string]string{"team": "search"}},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		// wantSites are the sites changed, newest first
+		wantSites []int
+		// wantLeft checks what is left of the sites afterwards, deleted sites being nil
+		wantLeft func(sites []*models.Site) bool
+	}{
+		{name: "Stop without a selector", method: http.MethodPost, target: "/sites:stop", wantStatus: http.StatusBadRequest},
+		{name: "Stop with an invalid selector", method: http.MethodPost, target: "/sites:stop?selector=team+name%3Dpayments", wantStatus: http.StatusBadRequest},
+		{
+			name: "Stop", method: http.MethodPost, target: "/sites:stop?selector=team%3Dpayments",
+			wantStatus: http.StatusOK, wantSites: []int{2},
+			wantLeft: func(sites []*models.Site) bool {
+				return sites[0].Paused && sites[1].Paused && !sites[2].Paused
+			},
+		},
+		{name: "Delete without a selector", method: http.MethodDelete, target: "/sites", wantStatus: http.StatusBadRequest},
+		{name: "Delete with an invalid selector", method: http.MethodDelete, target: "/sites?selector=team+name%3Dpayments", wantStatus: http.StatusBadRequest},
+		{
+			name: "Delete", method: http.MethodDelete, target: "/sites?selector=team%3Dpayments",
+			wantStatus: http.StatusOK, wantSites: []int{2, 1},
+			wantLeft: func(sites []*models.Site) bool {
+				return sites[0] == nil && sites[1] == nil && sites[2] != nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sites := newSites()
+			app := newTestApplication(t, sites...)
+			for _, site := range sites {
+				if !site.Paused {
+					app.NewMonitor(site).Start(app.wg)
+				}
+			}
+
+			rr := serve(app, tt.method, tt.target, "")
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var changed []*models.Site
+			if err := json.NewDecoder(rr.Body).Decode(&changed); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]int, 0, len(changed))
+			for _, site := range changed {
+				got = append(got, site.ID)
+			}
+			if !reflect.DeepEqual(got, tt.wantSites) {
+				t.Errorf("want sites %v, got %v", tt.wantSites, got)
+			}
+			left := make([]*models.Site, len(sites))
+			for i, site := range sites {
+				left[i], _ = app.sites.Get(site.ID)
+			}
+			if !tt.wantLeft(left) {
+				t.Errorf("want the selected sites changed, got %+v, %+v and %+v", left[0], left[1], left[2])
+			}
+			app.Mutex.Lock()
+			_, monitored := app.monitors[2]
+			app.Mutex.Unlock()
+			if monitored {
+				t.Error("want site 2 no longer monitored")
+			}
+		})
+	}
+}
+
+func TestDeleteSites_queuedResults(t *testing.T) {
+	payments := map[string]string{"team": "payments"}
+	app := newTestApplication(t,
+		&models.Site{URL: "https://www.example.com", Interval: models.Period(time.Minute), Labels: payments, Paused: true},
+		&models.Site{URL: "https://www.example.org", Interval: models.Period(time.Minute), Paused: true},
+	)
+	ap := pkg.NewAsyncPublisher(&pkg.DirectPublisher{Sink: app.results}, 10)
+	var delivered []error
+	ap.OnDelivery = func(_ []*models.CheckResult, err error, _ time.Duration) {
+		delivered = append(delivered, err)
+	}
+	app.publisher = ap
+	at := time.Now().UTC().Truncate(time.Second)
+	for _, id := range []int{1, 2} {
+		if err := ap.Publish(context.Background(), &models.CheckResult{SiteID: id, At: at, ResponseCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if rr := serve(app, http.MethodDelete, "/sites?selector=team%3Dpayments", ""); rr.Code != http.StatusOK {
+		t.Fatalf("want %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	// the queued result of the deleted site is dropped, while the other result is still stored
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	ap.Start(ctx, &wg)
+	wg.Wait()
+	if len(delivered) != 1 || delivered[0] != nil {
+		t.Fatalf("want the batch delivered without an error, got %v", delivered)
+	}
+	latest, err := app.results.GetLatest([]int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 1 || latest[0].SiteID != 2 {
+		t.Errorf("want only the result of site 2 stored, got %+v", latest)
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/dnataraj/healthbee/pkg"
 	"github.com/dnataraj/healthbee/pkg/models"
-	"github.com/segmentio/kafka-go"
+	"io/ioutil"
 	"net/http"
 	"runtime/debug"
 	"sync"
+	"time"
 )
 
 func (app *application) serverError(w http.ResponseWriter, err error) {
@@ -24,6 +26,31 @@ func (app *application) clientError(w http.ResponseWriter, status int) {
 	http.Error(w, http.StatusText(status), status)
 }
 
+// requires serves a handler only if the store it needs is available, and responds with a HTTP 501 otherwise,
+// such as for maintenance windows with the SQLite store
+func (app *application) requires(available bool, h http.HandlerFunc) http.HandlerFunc {
+	if available {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.clientError(w, http.StatusNotImplemented)
+	}
+}
+
+// groupError responds to failures managing site groups with the matching HTTP status
+func (app *application) groupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		app.clientError(w, http.StatusNotFound)
+	case errors.Is(err, models.ErrDuplicateGroup):
+		app.clientError(w, http.StatusConflict)
+	case errors.Is(err, models.ErrInvalidMember):
+		app.clientError(w, http.StatusUnprocessableEntity)
+	default:
+		app.serverError(w, err)
+	}
+}
+
 // decode is a simple response deserializer.
 // If the destination interface as an OK method, this can be used for simple validation
 func decode(r *http.Request, v interface{}) error {
@@ -46,6 +73,16 @@ func (app *application) ping(w http.ResponseWriter, r *http.Request) {
 	app.respond(w, "{}", http.StatusOK)
 }
 
+// linkNext links to the next page of a listing in the Link header, if there is one, keeping the other query parameters
+func linkNext(w http.ResponseWriter, r *http.Request, next *models.Cursor) {
+	if next == nil {
+		return
+	}
+	q := r.URL.Query()
+	q.Set("cursor", next.String())
+	w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, q.Encode()))
+}
+
 func (app *application) respond(w http.ResponseWriter, v interface{}, code int) {
 	w.WriteHeader(code)
 	if v == nil {
@@ -64,7 +101,13 @@ func (app *application) respond(w http.ResponseWriter, v interface{}, code int)
 // NewMonitor initializes and returns a site monitor. The monitor encapsulates
 // context cancellation and is retained in a map so that it can be managed afterwards
 func (app *application) NewMonitor(s *models.Site) *pkg.Monitor {
-	m := pkg.NewMonitor(s, app.writer)
+	m := pkg.NewMonitor(s, app.publisher)
+	m.Schedule = app.schedule
+	m.Dependencies = app.dependencies
+	m.Notifier = app.notifier
+	m.Events = app.events
+	m.Metrics = app.metrics
+	m.Source = app.source
 	app.Mutex.Lock()
 	defer app.Mutex.Unlock()
 	app.monitors[s.ID] = m
@@ -72,50 +115,477 @@ func (app *application) NewMonitor(s *models.Site) *pkg.Monitor {
 	return m
 }
 
-// Start resumes monitoring for the last 20 (for now) registered sites when HealthBee is started
+// stopMonitor stops monitoring a site, if it is being monitored
+func (app *application) stopMonitor(siteID int) {
+	app.Mutex.Lock()
+	m, ok := app.monitors[siteID]
+	delete(app.monitors, siteID)
+	app.Mutex.Unlock()
+	if ok {
+		m.Cancel()
+	}
+}
+
+// publishEvent publishes a lifecycle event for a site, if site events are enabled. Failures are only logged, as
+// the change to the site has already been made by then
+func (app *application) publishEvent(typ string, site *models.Site) {
+	if app.events == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := app.events.PublishEvent(ctx, pkg.NewSiteEvent(typ, site)); err != nil {
+		app.errorLog.Printf("events: unable to publish %s event for site [%d]: %s", typ, site.ID, err.Error())
+	}
+}
+
+// resume resumes monitoring for every registered site that is not paused when HealthBee is started, reading the
+// sites a page at a time
 func (app *application) resume() {
-	sites, err := app.sites.GetAll()
+	page := models.Page{Limit: models.MaxPageLimit}
+	resumed := 0
+	for {
+		sites, next, err := app.sites.GetAll(nil, page)
+		if err != nil {
+			app.errorLog.Fatal("server: unable to resume monitoring, failed with: ", err)
+		}
+		for _, site := range sites {
+			if site.Paused {
+				continue
+			}
+			m := app.NewMonitor(site)
+			app.infoLog.Printf("server: resuming monitoring for site [%d] with address [%s]...", site.ID, site.URL)
+			m.Start(app.wg)
+			resumed++
+		}
+		if next == nil {
+			break
+		}
+		page.After = next
+	}
+	app.infoLog.Printf("server: resumed monitoring for %d sites", resumed)
+}
+
+// loadSchedule makes the monitors aware of the maintenance windows registered before HealthBee was started
+func (app *application) loadSchedule() {
+	if app.maintenance == nil {
+		return
+	}
+	windows, err := app.maintenance.GetAll()
 	if err != nil {
-		app.errorLog.Fatal("server: unable to resume monitoring, failed with: ", err)
+		app.errorLog.Fatal("server: unable to load maintenance windows, failed with: ", err)
+	}
+	for _, w := range windows {
+		if err := app.schedule.Add(w); err != nil {
+			app.errorLog.Printf("server: skipping maintenance window [%d], failed with: %s", w.ID, err.Error())
+		}
+	}
+	app.infoLog.Printf("server: loaded %d maintenance windows", len(windows))
+}
+
+// updateParents stores the dependencies of a site, after checking that they do not introduce a cycle,
+// and makes them known to the monitors. Changes to dependencies are made one at a time, so that concurrent
+// changes cannot each pass the check and together form a cycle
+func (app *application) updateParents(siteID int, parents []int) error {
+	app.parentsMu.Lock()
+	defer app.parentsMu.Unlock()
+	if err := app.dependencies.Check(siteID, parents); err != nil {
+		return err
+	}
+	if err := app.sites.SetParents(siteID, parents); err != nil {
+		return err
+	}
+	return app.dependencies.Set(siteID, parents)
+}
+
+// setDependencies makes the parents of a newly registered site known to the monitors, the parents having been
+// stored along with the site
+func (app *application) setDependencies(site *models.Site) {
+	if len(site.Parents) == 0 {
+		return
 	}
-	app.infoLog.Printf("server: resuming monitoring for %d sites", len(sites))
-	for _, site := range sites {
-		m := app.NewMonitor(site)
-		app.infoLog.Printf("server: resuming monitoring for site [%d] with address [%s]...", site.ID, site.URL)
-		m.Start(app.wg)
+	app.parentsMu.Lock()
+	defer app.parentsMu.Unlock()
+	if err := app.dependencies.Set(site.ID, site.Parents); err != nil {
+		app.errorLog.Printf("server: unable to set the dependencies of site [%d]: %s", site.ID, err.Error())
 	}
 }
 
-// read consumes messages from a specific Kafka topic and publishes this to a PostgreSQL database
-// These are the site availability metrics previously published by the site monitors
-// Readers (a.k.a auditors) can be cancelled via the passed in Context and are closed here.
-// TODO: This belongs in pkg along with Monitor
-func (app *application) read(ctx context.Context, id int, r *kafka.Reader, wg *sync.WaitGroup) {
+// loadDependencies builds the site dependency graph from the registered dependencies
+func (app *application) loadDependencies() {
+	deps, err := app.sites.GetDependencies()
+	if err != nil {
+		app.errorLog.Fatal("server: unable to load site dependencies, failed with: ", err)
+	}
+	for siteID, parents := range deps {
+		if err := app.dependencies.Set(siteID, parents); err != nil {
+			app.errorLog.Printf("server: skipping dependencies for site [%d], failed with: %s", siteID, err.Error())
+		}
+	}
+	app.infoLog.Printf("server: loaded dependencies for %d sites", len(deps))
+}
+
+// groupStatus computes the composite health of a group from the latest result of each of its members
+func (app *application) groupStatus(g *models.Group, at time.Time) (*models.GroupStatus, error) {
+	latest, err := app.results.GetLatest(g.Sites)
+	if err != nil {
+		return nil, err
+	}
+	return g.Evaluate(latest, at), nil
+}
+
+// watchGroups periodically evaluates the health of every site group. Changes in the health of a group are
+// recorded in its history, and alerted on like changes in the status of a site
+func (app *application) watchGroups(ctx context.Context, interval time.Duration, wg *sync.WaitGroup) {
 	defer wg.Done()
-	defer r.Close()
 
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	status := make(map[int]string)
 	for {
 		select {
+		case now := <-t.C:
+			groups, err := app.groups.GetAll()
+			if err != nil {
+				app.errorLog.Printf("groups: unable to fetch site groups: %s", err.Error())
+				continue
+			}
+			for _, g := range groups {
+				gs, err := app.groupStatus(g, now.UTC())
+				if err != nil {
+					app.errorLog.Printf("groups: unable to evaluate group [%d]: %s", g.ID, err.Error())
+					continue
+				}
+				previous, ok := status[g.ID]
+				if !ok {
+					// pick up from the recorded history when HealthBee is restarted
+					if history, err := app.groups.GetHistory(g.ID); err == nil && len(history) > 0 {
+						previous = history[0].Status
+					}
+				}
+				status[g.ID] = gs.Status
+				if gs.Status == previous {
+					continue
+				}
+				if _, err := app.groups.InsertStatus(g.ID, gs.At, gs.Status, gs.Up, gs.Total); err != nil {
+					app.errorLog.Printf("groups: unable to record status for group [%d]: %s", g.ID, err.Error())
+				}
+				if previous == "" && gs.Status == models.StatusUp {
+					continue
+				}
+				err = app.notifier.Notify(ctx, &pkg.Alert{GroupID: g.ID, Group: g.Name, Status: gs.Status, At: gs.At, Health: gs})
+				if err != nil {
+					app.errorLog.Printf("groups: unable to raise alert for group [%d]: %s", g.ID, err.Error())
+				}
+			}
 		case <-ctx.Done():
 			return
-		default:
-			msg, err := r.ReadMessage(ctx)
-			if err != nil {
-				app.errorLog.Printf("auditor %d: unable to read message: %s", id, err.Error())
-				return
+		}
+	}
+}
+
+// rollup rolls the results up into hourly and daily aggregates every interval, after which the raw results and
+// hourly aggregates past their retention are pruned. Results partitions are created ahead of time, and dropped once
+// all of their results are past their retention
+func (app *application) rollup(ctx context.Context, interval time.Duration, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case now := <-t.C:
+			app.createPartitions(now)
+			rolledUp := true
+			for _, resolution := range []string{models.ResolutionHour, models.ResolutionDay} {
+				n, err := app.rollups.Rollup(resolution, now)
+				if err != nil {
+					app.errorLog.Printf("rollups: unable to roll up results by the %s: %s", resolution, err.Error())
+					rolledUp = false
+					continue
+				}
+				app.infoLog.Printf("rollups: rolled up %d aggregates by the %s", n, resolution)
+			}
+			// raw results are only removed once they are rolled up, so they are kept until the next tick otherwise
+			if rolledUp {
+				app.expireResults(now)
 			}
-			app.infoLog.Printf("auditor %d: fetched result: %s", id, string(msg.Value))
-			res := models.CheckResult{}
-			if err := json.Unmarshal(msg.Value, &res); err != nil {
-				app.errorLog.Printf("auditor %d: unable to detect valid message: %s", id, err.Error())
-				return
+			if app.hourlyRetention == 0 {
+				continue
 			}
-			resID, err := app.results.Insert(res.SiteID, res.At, res.ResponseTime, res.ResponseCode, res.MatchedPattern)
+			n, err := app.rollups.PruneRollups(models.ResolutionHour, now.Add(-app.hourlyRetention))
 			if err != nil {
-				app.errorLog.Printf("auditor %d: unable to write metrics for site [%d], failing with: %s", id, res.SiteID, err.Error())
-				return
+				app.errorLog.Printf("rollups: unable to prune hourly aggregates: %s", err.Error())
+			} else if n > 0 {
+				app.infoLog.Printf("rollups: pruned %d hourly aggregates past their retention", n)
 			}
-			app.infoLog.Printf("auditor %d: added metrics for site [%d], with id: %d", id, res.SiteID, resID)
+		case <-ctx.Done():
+			return
 		}
 	}
 }
+
+// expireResults drops the results partitions past the longest retention, and prunes the remaining results past the
+// retention of their site
+func (app *application) expireResults(now time.Time) {
+	dropped, err := app.partitions.Drop(app.retention, now)
+	if err != nil {
+		app.errorLog.Printf("rollups: unable to drop results partitions: %s", err.Error())
+	}
+	for _, p := range dropped {
+		app.infoLog.Printf("rollups: dropped results partition %s past its retention", p.Name)
+	}
+	n, err := app.rollups.Prune(app.retention, now)
+	if err != nil {
+		app.errorLog.Printf("rollups: unable to prune results: %s", err.Error())
+	} else if n > 0 {
+		app.infoLog.Printf("rollups: pruned %d results past their retention", n)
+	}
+}
+
+// createPartitions creates the results partitions that are due, results are otherwise stored in the default partition
+func (app *application) createPartitions(now time.Time) {
+	created, err := app.partitions.Create(app.partitionBy, now, app.partitionsAhead)
+	if err != nil {
+		app.errorLog.Printf("partitions: unable to create results partitions: %s", err.Error())
+	}
+	for _, p := range created {
+		app.infoLog.Printf("partitions: created results partition %s", p.Name)
+	}
+}
+
+// rollupsFor fetches the aggregated results of a site between from and to, hourly for ranges of up to a week and
+// daily otherwise. Aggregates are read from the raw results while they are kept, and from the rolled up aggregates
+// after that, with the latest buckets that are not rolled up yet aggregated from the raw results
+func (app *application) rollupsFor(site *models.Site, from, to time.Time) (string, []*models.Rollup, error) {
+	resolution := models.ResolutionHour
+	if to.Sub(from) > 7*24*time.Hour || !app.hourlyKept(from) {
+		resolution = models.ResolutionDay
+	}
+	rollups, err := app.rollupsAt(site, resolution, from, to)
+	return resolution, rollups, err
+}
+
+// hourlyKept tells whether the hourly aggregates are kept from the given time on
+func (app *application) hourlyKept(from time.Time) bool {
+	return app.hourlyRetention == 0 || !from.Before(time.Now().Add(-app.hourlyRetention))
+}
+
+// rollupsAt fetches the aggregated results of a site between from and to at the given resolution, like rollupsFor
+func (app *application) rollupsAt(site *models.Site, resolution string, from, to time.Time) ([]*models.Rollup, error) {
+	unit := time.Hour
+	if resolution == models.ResolutionDay {
+		unit = 24 * time.Hour
+	}
+	retention := app.retentionOf(site)
+	if retention == 0 || !from.Before(time.Now().Add(-retention)) {
+		return app.rollups.Aggregate(site.ID, resolution, from, to)
+	}
+
+	rollups, err := app.rollups.Get(site.ID, resolution, from, to)
+	if err != nil {
+		return nil, err
+	}
+	rest := from
+	if len(rollups) > 0 {
+		rest = rollups[len(rollups)-1].Bucket.Add(unit)
+	}
+	if rest.Before(to) {
+		latest, err := app.rollups.Aggregate(site.ID, resolution, rest, to)
+		if err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, latest...)
+	}
+	return rollups, nil
+}
+
+// statsFor summarises the results of a site over each step of a statistics window, and over the whole window.
+// Steps are summarised from the raw results while they are kept, and from the hourly or daily aggregates before
+// that, in which case the steps, and the whole window, have no response time percentiles
+func (app *application) statsFor(site *models.Site, window models.StatsWindow, now time.Time) ([]*models.Stats, *models.Stats, error) {
+	expired, retained := window.Split(app.retentionOf(site), now)
+	if expired.Buckets() == 0 {
+		return app.rollups.Stats(site.ID, window)
+	}
+
+	resolution := models.ResolutionHour
+	if !app.hourlyKept(expired.From) {
+		resolution = models.ResolutionDay
+	}
+	rollups, err := app.rollupsAt(site, resolution, expired.From, expired.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	buckets := expired.Summarise(rollups, resolution)
+	total := &models.Stats{Bucket: window.From, Resolution: resolution}
+	for _, b := range buckets {
+		total.Add(b)
+	}
+	if retained.Buckets() > 0 {
+		latest, latestTotal, err := app.rollups.Stats(site.ID, retained)
+		if err != nil {
+			return nil, nil, err
+		}
+		buckets = append(buckets, latest...)
+		total.Add(latestTotal)
+	}
+	return buckets, total, nil
+}
+
+// retentionOf returns how long the raw results of a site are kept for, which is forever if zero
+func (app *application) retentionOf(site *models.Site) time.Duration {
+	if retention := site.Retention.Duration(); retention != 0 {
+		return retention
+	}
+	return app.retention
+}
+
+// allSites returns every registered site matching the label selector, reading them a page at a time
+func (app *application) allSites(sel models.Selector) ([]*models.Site, error) {
+	page := models.Page{Limit: models.MaxPageLimit}
+	all := make([]*models.Site, 0)
+	for {
+		sites, next, err := app.sites.GetAll(sel, page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, sites...)
+		if next == nil {
+			return all, nil
+		}
+		page.After = next
+	}
+}
+
+// planSites plans the changes that bring the registered sites in line with a sites file, deleting the sites missing
+// from it if pruning, and applies them unless this is a dry run. Given the hash of a plan, the changes are only
+// applied if they are the same as those planned before. Sites files are applied one at a time, and the changes are
+// applied in order until one fails, so the number of changes that were applied is returned along with them
+func (app *application) planSites(f *pkg.SitesFile, prune, dryRun bool, plan string) ([]*pkg.SiteChange, int, error) {
+	app.applying.Lock()
+	defer app.applying.Unlock()
+	registered, err := app.allSites(nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	changes := f.Plan(registered, prune)
+	if dryRun {
+		return changes, 0, nil
+	}
+	if plan != "" && pkg.PlanHash(changes) != plan {
+		return changes, 0, pkg.ErrPlanChanged
+	}
+	for i, c := range changes {
+		if err := app.applySite(c); err != nil {
+			return changes, i, fmt.Errorf("apply: unable to %s: %w", c, err)
+		}
+		app.infoLog.Printf("apply: %s", c)
+	}
+	return changes, len(changes), nil
+}
+
+// applySite makes a planned change to a site, and starts, restarts or stops its monitor to match
+func (app *application) applySite(c *pkg.SiteChange) error {
+	site := c.Site
+	switch c.Action {
+	case pkg.ActionCreate:
+		// the site is registered along with its labels and whether it is paused, so it is never left half created
+		if err := app.sites.InsertBatch([]*models.Site{site}); err != nil {
+			return err
+		}
+		if site.ID == 0 {
+			return models.ErrDuplicateSite
+		}
+		if !site.Paused {
+			app.NewMonitor(site).Start(app.wg)
+		}
+		app.publishEvent(pkg.EventRegistered, site)
+	case pkg.ActionUpdate:
+		if err := app.sites.Update(site.ID, site.Interval, site.Pattern); err != nil {
+			return err
+		}
+		if err := app.sites.SetLabels(site.ID, site.Labels); err != nil {
+			return err
+		}
+		app.Mutex.Lock()
+		_, running := app.monitors[site.ID]
+		app.Mutex.Unlock()
+		if running {
+			app.stopMonitor(site.ID)
+			app.NewMonitor(site).Start(app.wg)
+		}
+		app.publishEvent(pkg.EventUpdated, site)
+	case pkg.ActionPause:
+		return app.pauseSite(site)
+	case pkg.ActionResume:
+		if err := app.sites.SetPaused(site.ID, false); err != nil {
+			return err
+		}
+		app.NewMonitor(site).Start(app.wg)
+		app.publishEvent(pkg.EventResumed, site)
+	case pkg.ActionDelete:
+		return app.deleteSite(site)
+	}
+	return nil
+}
+
+// pauseSite stops monitoring a site and records it as paused, so that it is not resumed when HealthBee is restarted
+func (app *application) pauseSite(site *models.Site) error {
+	if err := app.sites.SetPaused(site.ID, true); err != nil {
+		return err
+	}
+	app.stopMonitor(site.ID)
+	app.dependencies.Record(site.ID, "")
+	site.Paused = true
+	app.publishEvent(pkg.EventPaused, site)
+	return nil
+}
+
+// deleteSite stops monitoring a site and removes it, along with its results and the dependencies on it
+func (app *application) deleteSite(site *models.Site) error {
+	app.stopMonitor(site.ID)
+	if err := app.sites.Delete(site.ID); err != nil {
+		return err
+	}
+	app.dependencies.Remove(site.ID)
+	app.publishEvent(pkg.EventDeleted, site)
+	return nil
+}
+
+// reconcile applies the sites file at the given path every interval, so that the registered sites are brought back
+// in line with it when either of them changes. Sites missing from the file are only deleted if pruning
+func (app *application) reconcile(ctx context.Context, path string, prune bool, interval time.Duration, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := app.applySitesFile(path, prune); err != nil {
+				app.errorLog.Printf("apply: unable to reconcile sites with %s: %s", path, err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// applySitesFile reads, plans and applies the sites file at the given path, deleting the sites missing from it
+// if pruning
+func (app *application) applySitesFile(path string, prune bool) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := pkg.ParseSitesFile(data)
+	if err != nil {
+		return err
+	}
+	_, _, err = app.planSites(f, prune, false, "")
+	return err
+}
@@ -4,13 +4,14 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"flag"
 	"fmt"
 	"github.com/dnataraj/healthbee/pkg"
+	"github.com/dnataraj/healthbee/pkg/models"
 	"github.com/dnataraj/healthbee/pkg/models/postgres"
+	"github.com/dnataraj/healthbee/pkg/models/sqlite"
 	_ "github.com/lib/pq"
-	"github.com/segmentio/kafka-go"
+	"github.com/prometheus/client_golang/prometheus"
 	"log"
 	"net/http"
 	"os"
@@ -24,69 +25,172 @@ type application struct {
 	errorLog *log.Logger
 	infoLog  *log.Logger
 
-	sites   *postgres.SiteModel
-	results *postgres.ResultModel
+	sites       models.SiteStore
+	results     models.ResultStore
+	maintenance *postgres.MaintenanceModel
+	groups      *postgres.GroupModel
+	rollups     *postgres.RollupModel
+	partitions  *postgres.PartitionModel
 
-	monitors map[int]*pkg.Monitor
-	writer   *kafka.Writer
-	wg       *sync.WaitGroup
+	monitors        map[int]*pkg.Monitor
+	schedule        *pkg.Schedule
+	dependencies    *pkg.Dependencies
+	notifier        pkg.Notifier
+	metrics         *pkg.SiteMetrics
+	publisher       pkg.Publisher
+	deadLetters     *pkg.DeadLetterQueue
+	events          pkg.EventPublisher
+	source          string
+	retention       time.Duration
+	hourlyRetention time.Duration
+	// interval that results are partitioned by, and the number of partitions created ahead of time
+	partitionBy     string
+	partitionsAhead int
+	wg              *sync.WaitGroup
+	// applying serializes the application of sites files, and parentsMu the changes to site dependencies
+	applying  sync.Mutex
+	parentsMu sync.Mutex
 	sync.Mutex
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			replay(os.Args[2:])
+			return
+		case "migrate":
+			migrate(os.Args[2:])
+			return
+		case "export":
+			export(os.Args[2:])
+			return
+		case "apply":
+			apply(os.Args[2:])
+			return
+		}
+	}
+
 	// Passing in --local avoids configuring TLS for local service integration, for example
 	local := flag.Bool("local", false, "Set for local development mode")
 	addr := flag.String("addr", ":8000", "HTTP network address")
 	brokerList := flag.String("brokers", "localhost:9092", "Comma separated distributed cache peers")
-	dsn := flag.String("dsn", "", "DSN/Connection string for the PostgreSQL database")
+	dsn := flag.String("dsn", "", "DSN/Connection string for the PostgreSQL database, or the path of the SQLite database")
+	store := flag.String("store", "postgres", "Store for sites and results, either postgres or sqlite for single node deployments")
+	autoMigrate := flag.Bool("migrate", true, "Apply pending schema migrations at startup")
 	srvCertPath := flag.String("service-cert", "./certs/kafka/service.cert", "Path to the service public certificate")
 	srvKeyPath := flag.String("service-key", "./certs/kafka/service.key", "Path to the private key")
 	caPath := flag.String("ca-cert", "./certs/kafka/ca.pem", "Path to the CA certificate")
+	webhook := flag.String("alert-webhook", "", "URL to post site alerts to, alerts are logged if not set")
+	pipeline := flag.String("pipeline", "kafka", "Result pipeline, either kafka or direct to write results straight to PostgreSQL")
+	rollupInterval := flag.Duration("rollup-interval", 10*time.Minute, "Interval at which results are rolled up into hourly and daily aggregates")
+	retention := flag.Duration("retention", 0, "How long raw results are kept for once rolled up, forever if not set")
+	hourlyRetention := flag.Duration("hourly-retention", 0, "How long hourly aggregates are kept for, forever if not set")
+	resultsPartition := flag.String("results-partition", postgres.PartitionDay, "Interval that results are partitioned by, either day or week")
+	partitionsAhead := flag.Int("results-partitions-ahead", 7, "Number of results partitions created ahead of time")
+	sitesFile := flag.String("sites-file", "", "Path of a sites file that the registered sites are kept in line with, see healthbee apply")
+	sitesFilePrune := flag.Bool("sites-file-prune", false, "Delete the registered sites that are missing from the sites file")
+	sitesFileInterval := flag.Duration("sites-file-interval", 30*time.Second, "Interval at which the registered sites are reconciled with the sites file")
+	groupInterval := flag.Duration("group-interval", 30*time.Second, "Interval at which the health of site groups is evaluated")
+	auditors := flag.Int("auditors", 2, "Number of auditors consuming results from Kafka")
+	flushSize := flag.Int("flush-size", 100, "Number of results an auditor stores in a single batch")
+	flushInterval := flag.Duration("flush-interval", time.Second, "Interval at which auditors store pending results")
+	encoding := flag.String("encoding", "json", "Encoding of the results published to Kafka, either json, protobuf or avro")
+	registryURL := flag.String("schema-registry", "", "URL of a Confluent compatible schema registry to register the result schema with")
+	topic := flag.String("topic", "Metrics", "Kafka topic that results are published to")
+	dlqTopic := flag.String("dlq-topic", "Metrics-DLQ", "Kafka topic that results which cannot be stored are sent to")
+	eventsTopic := flag.String("events-topic", "SiteEvents", "Kafka topic that site lifecycle events are published to")
+	groupID := flag.String("group-id", "message-reader-group", "Kafka consumer group of the auditors")
+	partitions := flag.Int("partitions", 4, "Number of partitions of the Kafka topics, when they are created")
+	replicationFactor := flag.Int("replication-factor", 2, "Replication factor of the Kafka topics, when they are created")
+	saslMechanism := flag.String("sasl-mechanism", "", "SASL mechanism for Kafka, either plain, scram-sha-256 or scram-sha-512")
+	saslUsername := flag.String("sasl-username", "", "SASL username for Kafka")
+	saslPassword := flag.String("sasl-password", "", "SASL password for Kafka, read from HB_KAFKA_SASL_PASSWORD if not set")
+	compression := flag.String("compression", "none", "Compression of published results, either none, gzip, snappy, lz4 or zstd")
+	batchSize := flag.Int("batch-size", 100, "Maximum number of results published to Kafka in a single batch")
+	linger := flag.Duration("linger", time.Second, "Time to wait for a batch of results to fill up before publishing it")
+	requiredAcks := flag.String("required-acks", "all", "Acknowledgements required for published results, either none, one or all")
+	publishQueue := flag.Int("publish-queue", 1000, "Number of results queued for publishing in the background")
+	publishPolicy := flag.String("publish-policy", "block", "What to do with results when the publish queue is full, either block, drop-oldest or spool")
+	spoolDir := flag.String("spool-dir", "", "Directory to spool results to while they cannot be published, spooling is disabled if not set")
+	spoolMaxBytes := flag.Int64("spool-max-bytes", 256<<20, "Maximum size of the spool, after which the oldest results are evicted")
+	spoolSegmentBytes := flag.Int64("spool-segment-bytes", 16<<20, "Size of the spool segment files")
+	spoolRetry := flag.Duration("spool-retry", 5*time.Second, "Interval at which spooled results are published again")
+	hostname, _ := os.Hostname()
+	source := flag.String("source", hostname, "Name identifying this instance in check results, defaults to the host name")
 	flag.Parse()
+	if err := models.ValidateRetention(models.Period(*retention)); err != nil {
+		log.Fatalf("server: --retention must be at least %s", models.MinRetention)
+	}
+	if err := postgres.ValidatePartitionInterval(*resultsPartition); err != nil {
+		log.Fatalf("server: --results-partition must be either %s or %s", postgres.PartitionDay, postgres.PartitionWeek)
+	}
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
-	db, err := pkg.OpenDB(*dsn)
-	if err != nil {
-		errorLog.Fatal("server: unable to connect to sites database: ", err.Error())
-	}
-	defer db.Close()
-
-	// initialize TLS config for non-local services
-	var tlsConfig *tls.Config
-	if !*local {
-		infoLog.Println("server: configuring TLS for kafka service...")
-		tlsConfig, err = pkg.GetTLSConfig(*srvCertPath, *srvKeyPath, *caPath)
-		if err != nil {
-			errorLog.Fatal("server: error initializing kafka dialer: ", err.Error())
-		}
+	var notifier pkg.Notifier = pkg.LogNotifier{}
+	if *webhook != "" {
+		notifier = &pkg.WebhookNotifier{URL: *webhook}
 	}
 
-	brokers := strings.Split(*brokerList, ",")
-	err = pkg.CreateTopic("Metrics", brokers, tlsConfig)
-	if err != nil {
-		errorLog.Fatal("server: error creating metrics topic on cluster: ", err.Error())
+	wg := sync.WaitGroup{}
+	app := &application{
+		errorLog:        errorLog,
+		infoLog:         infoLog,
+		monitors:        make(map[int]*pkg.Monitor),
+		schedule:        pkg.NewSchedule(),
+		dependencies:    pkg.NewDependencies(),
+		notifier:        notifier,
+		metrics:         pkg.NewSiteMetrics(),
+		source:          *source,
+		retention:       *retention,
+		hourlyRetention: *hourlyRetention,
+		partitionBy:     *resultsPartition,
+		partitionsAhead: *partitionsAhead,
+		wg:              &wg,
 	}
 
-	w := &kafka.Writer{
-		Addr:  kafka.TCP(brokers...),
-		Topic: "Metrics", RequiredAcks: kafka.RequireAll,
-		Transport: &kafka.Transport{TLS: tlsConfig},
+	switch *store {
+	case "sqlite":
+		// maintenance windows, site groups and rollups are only available with PostgreSQL
+		if *dsn == "" {
+			*dsn = "healthbee.db"
+		}
+		db, err := sqlite.Connect(*dsn)
+		if err != nil {
+			errorLog.Fatal("server: unable to open sites database: ", err.Error())
+		}
+		defer db.Close()
+		if *autoMigrate {
+			if err := upgrade(*store, db, infoLog); err != nil {
+				errorLog.Fatal("server: unable to migrate the database schema: ", err.Error())
+			}
+		}
+		app.sites = &sqlite.SiteModel{DB: db}
+		app.results = &sqlite.ResultModel{DB: db}
+	case "postgres":
+		db, err := pkg.OpenDB(*dsn)
+		if err != nil {
+			errorLog.Fatal("server: unable to connect to sites database: ", err.Error())
+		}
+		defer db.Close()
+		if *autoMigrate {
+			if err := upgrade(*store, db, infoLog); err != nil {
+				errorLog.Fatal("server: unable to migrate the database schema: ", err.Error())
+			}
+		}
+		app.sites = &postgres.SiteModel{DB: db}
+		app.results = &postgres.ResultModel{DB: db}
+		app.maintenance = &postgres.MaintenanceModel{DB: db}
+		app.groups = &postgres.GroupModel{DB: db}
+		app.rollups = &postgres.RollupModel{DB: db}
+		app.partitions = &postgres.PartitionModel{DB: db}
+	default:
+		errorLog.Fatalf("server: unknown store %s, use either postgres or sqlite", *store)
 	}
-	// we will close our only writer (for now) here
-	defer w.Close()
 
-	wg := sync.WaitGroup{}
-	app := &application{
-		errorLog: errorLog,
-		infoLog:  infoLog,
-		sites:    &postgres.SiteModel{DB: db},
-		results:  &postgres.ResultModel{DB: db},
-		monitors: make(map[int]*pkg.Monitor),
-		writer:   w,
-		wg:       &wg,
-	}
+	prometheus.MustRegister(app.metrics)
 
 	srv := &http.Server{
 		Addr:     ":8000",
@@ -96,18 +200,140 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// start the auditors - these are the consumers for the topic
-	infoLog.Println("server: creating readers for incoming metrics...")
-	dialer := &kafka.Dialer{Timeout: 10 * time.Second, TLS: tlsConfig}
-	r1 := pkg.NewReader(brokers, dialer)
-	r2 := pkg.NewReader(brokers, dialer)
-	infoLog.Println("auditor: starting 2 readers for incoming metrics...")
-	wg.Add(1)
-	go app.read(ctx, 1, r1, &wg)
-	wg.Add(1)
-	go app.read(ctx, 2, r2, &wg)
+	switch *pipeline {
+	case "direct":
+		// without kafka, the monitors write their results to the database themselves
+		infoLog.Println("server: publishing metrics directly to the database...")
+		app.publisher = &pkg.DirectPublisher{Sink: app.results}
+	case "kafka":
+		cfg := pkg.NewKafkaConfig(strings.Split(*brokerList, ","))
+		cfg.Topic, cfg.DeadLetterTopic, cfg.GroupID = *topic, *dlqTopic, *groupID
+		cfg.EventsTopic = *eventsTopic
+		cfg.Partitions, cfg.ReplicationFactor = *partitions, *replicationFactor
+		cfg.BatchSize, cfg.BatchTimeout = *batchSize, *linger
+
+		var err error
+		// initialize TLS config for non-local services
+		if !*local {
+			infoLog.Println("server: configuring TLS for kafka service...")
+			cfg.TLS, err = pkg.GetTLSConfig(*srvCertPath, *srvKeyPath, *caPath)
+			if err != nil {
+				errorLog.Fatal("server: error initializing kafka dialer: ", err.Error())
+			}
+		}
+		if *saslPassword == "" {
+			*saslPassword = os.Getenv("HB_KAFKA_SASL_PASSWORD")
+		}
+		cfg.SASL, err = pkg.NewSASLMechanism(*saslMechanism, *saslUsername, *saslPassword)
+		if err != nil {
+			errorLog.Fatal("server: error initializing kafka authentication: ", err.Error())
+		}
+		cfg.Compression, err = pkg.ParseCompression(*compression)
+		if err != nil {
+			errorLog.Fatal("server: ", err.Error())
+		}
+		cfg.RequiredAcks, err = pkg.ParseRequiredAcks(*requiredAcks)
+		if err != nil {
+			errorLog.Fatal("server: ", err.Error())
+		}
+
+		dialer := cfg.Dialer()
+		for _, tc := range cfg.TopicConfigs() {
+			err = pkg.CreateTopic(tc, cfg.Brokers, dialer)
+			if err != nil {
+				errorLog.Fatalf("server: error creating topic %s on cluster: %s", tc.Topic, err.Error())
+			}
+		}
+
+		w := cfg.Writer()
+		// results are batched by the asynchronous publisher, so the writer need not wait for its batches to fill up
+		w.BatchTimeout = 10 * time.Millisecond
+		// we will close our only writer (for now) here
+		defer w.Close()
+		codec, err := pkg.NewCodec(*encoding)
+		if err != nil {
+			errorLog.Fatal("server: ", err.Error())
+		}
+		schemaID := 0
+		if *registryURL != "" {
+			registry := &pkg.SchemaRegistry{URL: *registryURL}
+			schemaID, err = registry.Register(cfg.Topic+"-value", codec)
+			if err != nil {
+				errorLog.Fatal("server: error registering result schema: ", err.Error())
+			}
+			infoLog.Printf("server: registered %s result schema with id %d", *encoding, schemaID)
+		}
+		app.publisher = &pkg.KafkaPublisher{Writer: w, Codec: codec, SchemaID: schemaID}
+		ew := cfg.EventWriter()
+		defer ew.Close()
+		app.events = &pkg.KafkaEventPublisher{Writer: ew}
+
+		// start the auditors - these are the consumers for the topic
+		infoLog.Println("server: creating readers for incoming metrics...")
+		app.deadLetters = pkg.NewDeadLetterQueue(cfg.DeadLetterTopic, cfg.Brokers, dialer)
+		defer app.deadLetters.Close()
+
+		a := pkg.NewAuditor(cfg.Brokers, dialer, app.results)
+		a.Topic, a.GroupID = cfg.Topic, cfg.GroupID
+		a.Workers, a.FlushSize, a.FlushInterval = *auditors, *flushSize, *flushInterval
+		a.DeadLetters = app.deadLetters
+		prometheus.MustRegister(a)
+		a.Start(ctx, &wg)
+	default:
+		errorLog.Fatal("server: unknown pipeline, expecting kafka or direct: ", *pipeline)
+	}
+
+	policy, err := pkg.ParsePolicy(*publishPolicy)
+	if err != nil {
+		errorLog.Fatal("server: ", err.Error())
+	}
+	var spool *pkg.Spool
+	if *spoolDir != "" {
+		infoLog.Printf("server: spooling unpublished metrics to %s...", *spoolDir)
+		spool, err = pkg.OpenSpool(*spoolDir, *spoolMaxBytes, *spoolSegmentBytes)
+		if err != nil {
+			errorLog.Fatal("server: unable to open spool: ", err.Error())
+		}
+		defer spool.Close()
+		prometheus.MustRegister(spool)
+		sp := &pkg.SpoolingPublisher{Publisher: app.publisher, Spool: spool, RetryInterval: *spoolRetry}
+		sp.Start(ctx, &wg)
+		app.publisher = sp
+	} else if policy == pkg.PolicySpool {
+		errorLog.Fatal("server: the spool publish policy requires a spool directory, see --spool-dir")
+	}
 
+	// publish results in the background, so that a slow broker does not hold up the monitors
+	ap := pkg.NewAsyncPublisher(app.publisher, *publishQueue)
+	ap.Policy, ap.Spool = policy, spool
+	ap.BatchSize, ap.Linger = *batchSize, *linger
+	prometheus.MustRegister(ap)
+	ap.Start(ctx, &wg)
+	app.publisher = ap
+
+	if app.partitions != nil {
+		app.createPartitions(time.Now())
+	}
+	app.loadSchedule()
+	app.loadDependencies()
 	app.resume()
+	if *sitesFile != "" {
+		if err := app.applySitesFile(*sitesFile, *sitesFilePrune); err != nil {
+			errorLog.Fatalf("server: unable to apply sites file %s: %s", *sitesFile, err.Error())
+		}
+		infoLog.Printf("server: reconciling sites with %s every %s", *sitesFile, *sitesFileInterval)
+		wg.Add(1)
+		go app.reconcile(ctx, *sitesFile, *sitesFilePrune, *sitesFileInterval, &wg)
+	}
+
+	if app.groups != nil {
+		wg.Add(1)
+		go app.watchGroups(ctx, *groupInterval, &wg)
+	}
+	if app.rollups != nil {
+		wg.Add(1)
+		go app.rollup(ctx, *rollupInterval, &wg)
+	}
 
 	infoLog.Printf("starting HealthBee API server on %s", *addr)
 	wg.Add(1)
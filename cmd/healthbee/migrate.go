@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg"
+	migrations "github.com/dnataraj/healthbee/pkg/models/migrate"
+	"github.com/dnataraj/healthbee/pkg/models/postgres"
+	"github.com/dnataraj/healthbee/pkg/models/sqlite"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// migrate implements the migrate command, which applies, reverts or lists the schema migrations of either store.
+// For example healthbee migrate --dsn=... status
+func migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	store := fs.String("store", "postgres", "Store to migrate, either postgres or sqlite")
+	dsn := fs.String("dsn", "", "DSN/Connection string for the PostgreSQL database, or the path of the SQLite database")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: healthbee migrate [--store=postgres|sqlite] [--dsn=<dsn>] up|down|status")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	var db *sql.DB
+	var err error
+	switch *store {
+	case "sqlite":
+		if *dsn == "" {
+			*dsn = "healthbee.db"
+		}
+		db, err = sqlite.Connect(*dsn)
+	default:
+		db, err = pkg.OpenDB(*dsn)
+	}
+	if err != nil {
+		errorLog.Fatal("migrate: unable to connect to database: ", err.Error())
+	}
+	defer db.Close()
+	m, err := newMigrator(*store, db)
+	if err != nil {
+		errorLog.Fatal("migrate: ", err.Error())
+	}
+
+	switch fs.Arg(0) {
+	case "up":
+		applied, err := m.Up()
+		for _, mig := range applied {
+			infoLog.Printf("migrate: applied version %d (%s)", mig.Version, mig.Name)
+		}
+		if err != nil {
+			errorLog.Fatal(err.Error())
+		}
+		if len(applied) == 0 {
+			infoLog.Println("migrate: the schema is up to date")
+		}
+	case "down":
+		mig, err := m.Down()
+		if err != nil {
+			if errors.Is(err, migrations.ErrNoMigration) {
+				infoLog.Println("migrate: no migrations are applied")
+				return
+			}
+			errorLog.Fatal(err.Error())
+		}
+		infoLog.Printf("migrate: reverted version %d (%s)", mig.Version, mig.Name)
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			errorLog.Fatal(err.Error())
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied != nil {
+				applied = s.Applied.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		tw.Flush()
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// newMigrator returns the migrator of the given store
+func newMigrator(store string, db *sql.DB) (*migrations.Migrator, error) {
+	switch store {
+	case "sqlite":
+		return sqlite.NewMigrator(db)
+	case "postgres":
+		return postgres.NewMigrator(db)
+	}
+	return nil, fmt.Errorf("unknown store %s, use either postgres or sqlite", store)
+}
+
+// upgrade applies the pending migrations of the given store at startup
+func upgrade(store string, db *sql.DB, infoLog *log.Logger) error {
+	m, err := newMigrator(store, db)
+	if err != nil {
+		return err
+	}
+	applied, err := m.Up()
+	for _, mig := range applied {
+		infoLog.Printf("server: applied schema migration %d (%s)", mig.Version, mig.Name)
+	}
+	return err
+}
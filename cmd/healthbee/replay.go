@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/dnataraj/healthbee/pkg"
+	"github.com/dnataraj/healthbee/pkg/models/postgres"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// replay implements the replay command, which rebuilds the results store from the results topic. For example
+// healthbee replay --dsn=... --from=2021-03-01T00:00:00Z --to=2021-03-02T00:00:00Z
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	local := fs.Bool("local", false, "Set for local development mode")
+	brokerList := fs.String("brokers", "localhost:9092", "Comma separated distributed cache peers")
+	dsn := fs.String("dsn", "", "DSN/Connection string for the PostgreSQL database")
+	srvCertPath := fs.String("service-cert", "./certs/kafka/service.cert", "Path to the service public certificate")
+	srvKeyPath := fs.String("service-key", "./certs/kafka/service.key", "Path to the private key")
+	caPath := fs.String("ca-cert", "./certs/kafka/ca.pem", "Path to the CA certificate")
+	saslMechanism := fs.String("sasl-mechanism", "", "SASL mechanism for Kafka, either plain, scram-sha-256 or scram-sha-512")
+	saslUsername := fs.String("sasl-username", "", "SASL username for Kafka")
+	saslPassword := fs.String("sasl-password", "", "SASL password for Kafka, read from HB_KAFKA_SASL_PASSWORD if not set")
+	topic := fs.String("topic", "Metrics", "Kafka topic that results are replayed from")
+	groupID := fs.String("group-id", "healthbee-replay", "Kafka consumer group used for the replay, apart from that of the auditors")
+	from := fs.String("from", "", "Offset or RFC 3339 timestamp to replay from, the start of the topic if not set")
+	to := fs.String("to", "", "Offset or RFC 3339 timestamp to replay up to, the end of the topic if not set")
+	flushSize := fs.Int("flush-size", 1000, "Number of results stored in a single batch")
+	_ = fs.Parse(args)
+
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	cfg := pkg.NewKafkaConfig(strings.Split(*brokerList, ","))
+	var err error
+	if !*local {
+		cfg.TLS, err = pkg.GetTLSConfig(*srvCertPath, *srvKeyPath, *caPath)
+		if err != nil {
+			errorLog.Fatal("replay: error initializing kafka dialer: ", err.Error())
+		}
+	}
+	if *saslPassword == "" {
+		*saslPassword = os.Getenv("HB_KAFKA_SASL_PASSWORD")
+	}
+	cfg.SASL, err = pkg.NewSASLMechanism(*saslMechanism, *saslUsername, *saslPassword)
+	if err != nil {
+		errorLog.Fatal("replay: error initializing kafka authentication: ", err.Error())
+	}
+
+	db, err := pkg.OpenDB(*dsn)
+	if err != nil {
+		errorLog.Fatal("replay: unable to connect to results database: ", err.Error())
+	}
+	defer db.Close()
+
+	r := pkg.NewReplay(cfg.Brokers, cfg.Dialer(), &postgres.ResultModel{DB: db})
+	r.Topic, r.GroupID, r.FlushSize = *topic, *groupID, *flushSize
+	if r.From, err = pkg.ParsePosition(*from); err != nil {
+		errorLog.Fatal(err.Error())
+	}
+	if r.To, err = pkg.ParsePosition(*to); err != nil {
+		errorLog.Fatal(err.Error())
+	}
+
+	// stop replaying on interrupt, keeping what was stored so far
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		cancel()
+	}()
+
+	n, err := r.Run(ctx)
+	if err != nil {
+		errorLog.Fatalf("replay: stopped after storing %d results: %s", n, err.Error())
+	}
+	infoLog.Printf("replay: stored %d results from %s", n, r.Topic)
+}
@@ -2,17 +2,45 @@ package main
 
 import (
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
 )
 
 func (app *application) routes() http.Handler {
 	r := mux.NewRouter().StrictSlash(true)
+	windows, groups, rollups := app.maintenance != nil, app.groups != nil, app.rollups != nil
 
 	r.HandleFunc("/sites", app.monitor).Methods(http.MethodPost, http.MethodGet)
+	r.HandleFunc("/sites", app.deleteSites).Methods(http.MethodDelete)
+	r.HandleFunc("/sites:batch", app.registerSites).Methods(http.MethodPost)
+	r.HandleFunc("/sites:stop", app.stopSites).Methods(http.MethodPost)
+	r.HandleFunc("/sites:apply", app.applySites).Methods(http.MethodPost)
 	r.HandleFunc("/sites/{id}/stop", app.stop).Methods(http.MethodPost)
+	r.HandleFunc("/sites/{id}/parents", app.setParents).Methods(http.MethodPut)
+	r.HandleFunc("/sites/{id}/labels", app.setLabels).Methods(http.MethodPut)
+	r.HandleFunc("/sites/{id}/retention", app.requires(rollups, app.setRetention)).Methods(http.MethodPut)
+	r.HandleFunc("/sites/{id}/rollups", app.requires(rollups, app.getRollups)).Methods(http.MethodGet)
+	r.HandleFunc("/sites/{id}/stats", app.requires(rollups, app.getStats)).Methods(http.MethodGet)
+	r.HandleFunc("/sites/{id}/results/export", app.exportResults).Methods(http.MethodGet)
+	r.HandleFunc("/heartbeat/{token}", app.heartbeat).Methods(http.MethodPost)
 	r.HandleFunc("/sites/{id}", app.getMetrics).Methods(http.MethodGet)
 
+	r.HandleFunc("/maintenance", app.requires(windows, app.addWindow)).Methods(http.MethodPost)
+	r.HandleFunc("/maintenance", app.requires(windows, app.listWindows)).Methods(http.MethodGet)
+	r.HandleFunc("/maintenance/{id}", app.requires(windows, app.removeWindow)).Methods(http.MethodDelete)
+
+	r.HandleFunc("/groups", app.requires(groups, app.addGroup)).Methods(http.MethodPost)
+	r.HandleFunc("/groups", app.requires(groups, app.listGroups)).Methods(http.MethodGet)
+	r.HandleFunc("/groups/{id}", app.requires(groups, app.getGroup)).Methods(http.MethodGet)
+	r.HandleFunc("/groups/{id}", app.requires(groups, app.updateGroup)).Methods(http.MethodPut)
+	r.HandleFunc("/groups/{id}", app.requires(groups, app.removeGroup)).Methods(http.MethodDelete)
+	r.HandleFunc("/groups/{id}/status", app.requires(groups, app.getGroupStatus)).Methods(http.MethodGet)
+	r.HandleFunc("/groups/{id}/history", app.requires(groups, app.getGroupHistory)).Methods(http.MethodGet)
+
+	r.HandleFunc("/admin/dead-letters", app.listDeadLetters).Methods(http.MethodGet)
+
 	r.HandleFunc("/ping", app.ping).Methods(http.MethodGet)
+	r.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
 
 	return r
 }
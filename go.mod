@@ -1,9 +1,18 @@
 module github.com/dnataraj/healthbee
 
-go 1.15
+go 1.16
 
 require (
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.9.0
+	github.com/linkedin/goavro/v2 v2.10.0
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/prometheus/client_golang v1.10.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/segmentio/kafka-go v0.4.9
+	github.com/teambition/rrule-go v1.8.2
+	github.com/xitongsys/parquet-go v1.6.0
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+	google.golang.org/protobuf v1.23.0
+	gopkg.in/yaml.v2 v2.4.0
 )
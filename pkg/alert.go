@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"net/http"
+	"time"
+)
+
+// Alert describes a change in the availability of a monitored site, or of a site group
+type Alert struct {
+	SiteID  int                 `json:"site_id,omitempty"`
+	URL     string              `json:"url,omitempty"`
+	Labels  map[string]string   `json:"labels,omitempty"`
+	GroupID int                 `json:"group_id,omitempty"`
+	Group   string              `json:"group,omitempty"`
+	Status  string              `json:"status"`
+	At      time.Time           `json:"at"`
+	Result  *models.CheckResult `json:"result,omitempty"`
+	Health  *models.GroupStatus `json:"health,omitempty"`
+}
+
+// Notifier delivers alerts raised by site monitors
+type Notifier interface {
+	Notify(ctx context.Context, a *Alert) error
+}
+
+// LogNotifier reports alerts to the HealthBee log, and is used when no other notifier is configured
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(_ context.Context, a *Alert) error {
+	if a.GroupID != 0 {
+		warnLog.Printf("alert: group [%d] %s is %s as of %s", a.GroupID, a.Group, a.Status, a.At.Format(time.Stamp))
+		return nil
+	}
+	warnLog.Printf("alert: site [%d] with address [%s] is %s as of %s", a.SiteID, a.URL, a.Status, a.At.Format(time.Stamp))
+	return nil
+}
+
+// WebhookNotifier posts alerts as JSON to a HTTP endpoint
+type WebhookNotifier struct {
+	URL string
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, a *Alert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("alert failed with: %s", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("alert failed with: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("alert failed with: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert failed with status: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidSitesFile = errors.New("apply: invalid sites file")
+	ErrPlanChanged      = errors.New("apply: the planned changes differ from those confirmed, as the registered sites changed")
+)
+
+// SitesFile declares the HTTP sites that are monitored, so that they can be kept in version control and applied to
+// every environment. The file manages the sites matching its selector, or every HTTP site if it has none, and
+// sites are identified by their URL. Managed sites missing from the file are only deleted when pruning. For example
+//
+//	selector: team=payments
+//	sites:
+//	  - url: https://www.example.com
+//	    interval: 30s
+//	    pattern: Example Domain
+//	    labels: { team: payments, env: prod }
+//	    paused: false
+type SitesFile struct {
+	Selector string      `yaml:"selector"`
+	Sites    []*SiteSpec `yaml:"sites"`
+
+	sel models.Selector
+}
+
+// SiteSpec declares a site of a sites file
+type SiteSpec struct {
+	URL      string            `yaml:"url"`
+	Interval models.Period     `yaml:"interval"`
+	Pattern  string            `yaml:"pattern"`
+	Labels   map[string]string `yaml:"labels"`
+	Paused   bool              `yaml:"paused"`
+}
+
+// ParseSitesFile parses and validates a sites file. Sites must have a URL, declared only once, a valid interval,
+// pattern and labels, and labels that match the selector of the file, so that they stay managed by it
+func ParseSitesFile(data []byte) (*SitesFile, error) {
+	f := &SitesFile{}
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSitesFile, err.Error())
+	}
+	sel, err := models.ParseSelector(f.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSitesFile, err.Error())
+	}
+	f.sel = sel
+	seen := make(map[string]bool)
+	for i, spec := range f.Sites {
+		if spec == nil {
+			return nil, fmt.Errorf("%w: site %d is empty", ErrInvalidSitesFile, i+1)
+		}
+		site := &models.Site{Type: models.SiteHTTP, URL: spec.URL, Interval: spec.Interval, Pattern: spec.Pattern, Labels: spec.Labels}
+		if err := site.OK(); err != nil {
+			return nil, fmt.Errorf("%w: site %d (%s): %s", ErrInvalidSitesFile, i+1, spec.URL, err.Error())
+		}
+		if !sel.Matches(spec.Labels) {
+			return nil, fmt.Errorf("%w: site %d (%s) does not match the selector %q", ErrInvalidSitesFile, i+1, spec.URL, f.Selector)
+		}
+		if seen[spec.URL] {
+			return nil, fmt.Errorf("%w: site %d (%s) is declared more than once", ErrInvalidSitesFile, i+1, spec.URL)
+		}
+		seen[spec.URL] = true
+	}
+	return f, nil
+}
+
+// Actions planned for the sites of a sites file
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionPause  = "pause"
+	ActionResume = "resume"
+	ActionDelete = "delete"
+)
+
+// SiteChange is a change to a site, planned to bring the registered sites in line with a sites file.
+// The site is as it should be after the change, so sites declared as paused are created paused, and updates
+// describe the fields that change
+type SiteChange struct {
+	Action string       `json:"action"`
+	Site   *models.Site `json:"site"`
+	Diff   []string     `json:"diff,omitempty"`
+}
+
+// ApplyResult reports the changes planned for a sites file, along with their hash, and how many of them were
+// applied before any failure
+type ApplyResult struct {
+	DryRun  bool          `json:"dry_run"`
+	Changes []*SiteChange `json:"changes"`
+	Plan    string        `json:"plan"`
+	Applied int           `json:"applied"`
+	Error   string        `json:"error,omitempty"`
+}
+
+func (c *SiteChange) String() string {
+	s := fmt.Sprintf("%s %s", c.Action, c.Site.URL)
+	if c.Site.ID != 0 {
+		s += fmt.Sprintf(" [%d]", c.Site.ID)
+	}
+	diff := c.Diff
+	if c.Action == ActionCreate && c.Site.Paused {
+		diff = append(diff, "paused")
+	}
+	if len(diff) > 0 {
+		s += " (" + strings.Join(diff, ", ") + ")"
+	}
+	return s
+}
+
+// Plan compares the registered sites with the sites file, and returns the changes that bring them in line, in the
+// order of the file followed by the deletions. Sites missing from the file are only deleted when pruning, while
+// heartbeat sites and sites outside the selector of the file are always left alone
+func (f *SitesFile) Plan(registered []*models.Site, prune bool) []*SiteChange {
+	current := make(map[string]*models.Site)
+	for _, s := range registered {
+		if s.Type == models.SiteHTTP && f.sel.Matches(s.Labels) {
+			current[s.URL] = s
+		}
+	}
+	changes := make([]*SiteChange, 0)
+	for _, spec := range f.Sites {
+		site := &models.Site{Type: models.SiteHTTP, URL: spec.URL, Interval: spec.Interval, Pattern: spec.Pattern, Labels: spec.Labels, Paused: spec.Paused}
+		cur, ok := current[spec.URL]
+		if !ok {
+			changes = append(changes, &SiteChange{Action: ActionCreate, Site: site})
+			continue
+		}
+		delete(current, spec.URL)
+		site.ID, site.Created, site.Parents, site.Retention = cur.ID, cur.Created, cur.Parents, cur.Retention
+		if diff := diffSites(cur, site); len(diff) > 0 {
+			changes = append(changes, &SiteChange{Action: ActionUpdate, Site: site, Diff: diff})
+		}
+		switch {
+		case spec.Paused && !cur.Paused:
+			changes = append(changes, &SiteChange{Action: ActionPause, Site: site})
+		case !spec.Paused && cur.Paused:
+			changes = append(changes, &SiteChange{Action: ActionResume, Site: site})
+		}
+	}
+	if !prune {
+		return changes
+	}
+	deleted := make([]*models.Site, 0, len(current))
+	for _, s := range current {
+		deleted = append(deleted, s)
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].ID < deleted[j].ID })
+	for _, s := range deleted {
+		changes = append(changes, &SiteChange{Action: ActionDelete, Site: s})
+	}
+	return changes
+}
+
+// PlanHash identifies planned changes by a hash of their descriptions, so that changes can be applied only if they
+// are still the ones that were confirmed
+func PlanHash(changes []*SiteChange) string {
+	h := sha256.New()
+	for _, c := range changes {
+		fmt.Fprintln(h, c)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// diffSites describes the differences in the interval, pattern and labels of a site
+func diffSites(from, to *models.Site) []string {
+	diff := make([]string, 0)
+	if from.Interval != to.Interval {
+		diff = append(diff, fmt.Sprintf("interval: %s -> %s", from.Interval.Duration(), to.Interval.Duration()))
+	}
+	if from.Pattern != to.Pattern {
+		diff = append(diff, fmt.Sprintf("pattern: %q -> %q", from.Pattern, to.Pattern))
+	}
+	if formatLabels(from.Labels) != formatLabels(to.Labels) {
+		diff = append(diff, fmt.Sprintf("labels: {%s} -> {%s}", formatLabels(from.Labels), formatLabels(to.Labels)))
+	}
+	return diff
+}
+
+// formatLabels formats labels in the order of their keys, such as env=prod,team=payments
+func formatLabels(labels map[string]string) string {
+	terms := make([]string, 0, len(labels))
+	for _, k := range models.LabelKeys(labels) {
+		terms = append(terms, k+"="+labels[k])
+	}
+	return strings.Join(terms, ",")
+}
@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSitesFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantSites int
+		wantError bool
+	}{
+		{
+			name: "Valid",
+			data: `
+selector: team=payments
+sites:
+  - url: https://www.example.com
+    interval: 30s
+    pattern: Example Domain
+    labels: { team: payments }
+  - url: https://api.example.com
+    interval: 1m
+    labels: { team: payments, env: prod }
+    paused: true
+`,
+			wantSites: 2,
+		},
+		{name: "Empty", data: ``, wantSites: 0},
+		{name: "Unknown field", data: "sites:\n  - url: https://www.example.com\n    interval: 30s\n    period: 30\n", wantError: true},
+		{name: "Invalid interval", data: "sites:\n  - url: https://www.example.com\n    interval: soon\n", wantError: true},
+		{name: "Missing interval", data: "sites:\n  - url: https://www.example.com\n", wantError: true},
+		{name: "Missing URL", data: "sites:\n  - interval: 30s\n", wantError: true},
+		{name: "Invalid pattern", data: "sites:\n  - url: https://www.example.com\n    interval: 30s\n    pattern: \"(\"\n", wantError: true},
+		{name: "Invalid selector", data: "selector: team name=payments\nsites: []\n", wantError: true},
+		{
+			name:      "Outside the selector",
+			data:      "selector: team=payments\nsites:\n  - url: https://www.example.com\n    interval: 30s\n    labels: { team: search }\n",
+			wantError: true,
+		},
+		{
+			name:      "Duplicate URL",
+			data:      "sites:\n  - url: https://www.example.com\n    interval: 30s\n  - url: https://www.example.com\n    interval: 1m\n",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseSitesFile([]byte(tt.data))
+			if tt.wantError {
+				if !errors.Is(err, ErrInvalidSitesFile) {
+					t.Errorf("want %v, got %v", ErrInvalidSitesFile, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(f.Sites) != tt.wantSites {
+				t.Errorf("want %d sites, got %d", tt.wantSites, len(f.Sites))
+			}
+		})
+	}
+}
+
+func TestSitesFile_Plan(t *testing.T) {
+	f, err := ParseSitesFile([]byte(`
+selector: team=payments
+sites:
+  - url: https://new.example.com
+    interval: 30s
+    labels: { team: payments }
+  - url: https://changed.example.com
+    interval: 1m
+    pattern: OK
+    labels: { team: payments, env: prod }
+  - url: https://same.example.com
+    interval: 30s
+    labels: { team: payments }
+  - url: https://paused.example.com
+    interval: 30s
+    labels: { team: payments }
+    paused: true
+  - url: https://resumed.example.com
+    interval: 30s
+    labels: { team: payments }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	site := func(id int, url string, paused bool, labels map[string]string) *models.Site {
+		return &models.Site{ID: id, Type: models.SiteHTTP, URL: url, Interval: models.Period(30 * time.Second), Labels: labels, Paused: paused, Created: created}
+	}
+	payments := map[string]string{"team": "payments"}
+	changed := site(2, "https://changed.example.com", false, payments)
+	changed.Parents = []int{1}
+	registered := []*models.Site{
+		site(7, "https://gone.example.com", false, payments),
+		site(6, "https://removed.example.com", true, payments),
+		changed,
+		site(3, "https://same.example.com", false, payments),
+		site(4, "https://paused.example.com", false, payments),
+		site(5, "https://resumed.example.com", true, payments),
+		site(8, "https://search.example.com", false, map[string]string{"team": "search"}),
+		{ID: 9, Type: models.SiteHeartbeat, URL: "/heartbeat/abc", Interval: models.Period(time.Hour), Labels: payments},
+	}
+
+	changes := f.Plan(registered, true)
+	want := []string{
+		"create https://new.example.com",
+		`update https://changed.example.com [2] (interval: 30s -> 1m0s, pattern: "" -> "OK", labels: {team=payments} -> {env=prod,team=payments})`,
+		"pause https://paused.example.com [4]",
+		"resume https://resumed.example.com [5]",
+		"delete https://removed.example.com [6]",
+		"delete https://gone.example.com [7]",
+	}
+	got := make([]string, len(changes))
+	for i, c := range changes {
+		got[i] = c.String()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+	if update := changes[1].Site; update.Created != created || !reflect.DeepEqual(update.Parents, []int{1}) {
+		t.Errorf("want the update to keep the site's creation time and parents, got %+v", update)
+	}
+
+	// sites missing from the file are only deleted when pruning
+	if kept := f.Plan(registered, false); len(kept) != len(changes)-2 || kept[len(kept)-1].Action == ActionDelete {
+		t.Errorf("want no deletions without pruning, got %d changes", len(kept))
+	}
+	if changes := f.Plan(nil, true); len(changes) != len(f.Sites) {
+		t.Errorf("want every site created, got %d changes", len(changes))
+	}
+}
+
+func TestPlanHash(t *testing.T) {
+	site := &models.Site{ID: 1, URL: "https://www.example.com"}
+	plan := PlanHash([]*SiteChange{{Action: ActionPause, Site: site}})
+	if plan != PlanHash([]*SiteChange{{Action: ActionPause, Site: site}}) {
+		t.Error("want the same hash for the same changes")
+	}
+	for _, changes := range [][]*SiteChange{
+		nil,
+		{{Action: ActionDelete, Site: site}},
+		{{Action: ActionPause, Site: site}, {Action: ActionDelete, Site: &models.Site{ID: 2, URL: "https://www.example.org"}}},
+	} {
+		if PlanHash(changes) == plan {
+			t.Errorf("want a different hash for %v", changes)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backpressure policies of the AsyncPublisher, deciding what happens to a check result when the queue is full
+const (
+	// PolicyBlock waits for room in the queue, holding up the monitor that published the result
+	PolicyBlock = "block"
+	// PolicyDropOldest drops the oldest queued result to make room for the new one
+	PolicyDropOldest = "drop-oldest"
+	// PolicySpool appends the result to the spool, from which it is published once the queue has drained
+	PolicySpool = "spool"
+)
+
+// ErrDropped is reported for check results dropped from a full queue
+var ErrDropped = errors.New("publish: result dropped from a full queue")
+
+// ParsePolicy returns the backpressure policy for the given name, either block, drop-oldest or spool
+func ParsePolicy(name string) (string, error) {
+	switch p := strings.ToLower(name); p {
+	case PolicyBlock, PolicyDropOldest, PolicySpool:
+		return p, nil
+	}
+	return "", fmt.Errorf("publish: unknown backpressure policy %q, expecting block, drop-oldest or spool", name)
+}
+
+// queued is a check result waiting in the queue of the AsyncPublisher
+type queued struct {
+	res *models.CheckResult
+	at  time.Time
+}
+
+// AsyncPublisher queues check results and publishes them in batches in the background, so that a slow broker
+// does not hold up the monitors. Results are taken off the queue in batches of up to BatchSize, waiting up to
+// Linger for a batch to fill up, and published with the underlying publisher.
+//
+// The outcome of every batch is reported to OnDelivery if set, along with the time the results spent in the
+// pipeline. Batches that fail are logged and dropped, unless the underlying publisher is a SpoolingPublisher.
+// With PolicySpool, results that do not fit in the queue are appended to Spool, which is expected to be the
+// spool of such a SpoolingPublisher so that they are replayed in order.
+type AsyncPublisher struct {
+	Publisher  Publisher
+	Policy     string
+	Spool      *Spool
+	BatchSize  int
+	Linger     time.Duration
+	OnDelivery func(results []*models.CheckResult, err error, latency time.Duration)
+
+	queue     chan *queued
+	latency   prometheus.Histogram
+	published prometheus.Counter
+	failed    prometheus.Counter
+	dropped   prometheus.Counter
+	spooled   prometheus.Counter
+}
+
+var (
+	publishQueueDepth = prometheus.NewDesc("healthbee_publish_queue_depth",
+		"Number of check results waiting to be published.", nil, nil)
+	publishQueueCapacity = prometheus.NewDesc("healthbee_publish_queue_capacity",
+		"Maximum number of check results waiting to be published.", nil, nil)
+)
+
+// NewAsyncPublisher creates an asynchronous publisher queueing up to size results, blocking when the queue
+// is full and publishing batches of up to 100 results
+func NewAsyncPublisher(p Publisher, size int) *AsyncPublisher {
+	return &AsyncPublisher{
+		Publisher: p,
+		Policy:    PolicyBlock,
+		BatchSize: 100,
+		Linger:    100 * time.Millisecond,
+		queue:     make(chan *queued, size),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "healthbee_publish_latency_seconds",
+			Help:    "Time from queueing a batch of check results until it is delivered.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		}),
+		published: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "healthbee_published_results_total",
+			Help: "Number of check results published.",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "healthbee_publish_failures_total",
+			Help: "Number of check results that could not be published.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "healthbee_publish_dropped_total",
+			Help: "Number of check results dropped from a full queue.",
+		}),
+		spooled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "healthbee_publish_spooled_total",
+			Help: "Number of check results spooled by the publisher.",
+		}),
+	}
+}
+
+// Publish queues a check result, applying the backpressure policy if the queue is full
+func (p *AsyncPublisher) Publish(ctx context.Context, res *models.CheckResult) error {
+	q := &queued{res: res, at: time.Now()}
+	select {
+	case p.queue <- q:
+		return nil
+	default:
+	}
+
+	switch p.Policy {
+	case PolicyDropOldest:
+		for {
+			select {
+			case old := <-p.queue:
+				p.dropped.Inc()
+				warnLog.Printf("publish: site[%d] dropping result at %s, queue is full", old.res.SiteID, old.res.At.Format(time.Stamp))
+				p.report([]*models.CheckResult{old.res}, ErrDropped, time.Since(old.at))
+			default:
+			}
+			// the queue may have been drained meanwhile, or filled up again by other monitors
+			select {
+			case p.queue <- q:
+				return nil
+			default:
+			}
+		}
+	case PolicySpool:
+		if err := p.Spool.Append(res); err != nil {
+			return fmt.Errorf("spool failed with: %s", err)
+		}
+		p.spooled.Inc()
+		return nil
+	default:
+		select {
+		case p.queue <- q:
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("publish failed with: %s", ctx.Err())
+		}
+	}
+}
+
+// Start publishes queued results in the background until the Context is cancelled, after which the results
+// still in the queue are published before returning
+func (p *AsyncPublisher) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			batch, ok := p.next(ctx)
+			if !ok {
+				p.drain(batch)
+				return
+			}
+			p.deliver(ctx, batch)
+		}
+	}()
+}
+
+// next collects a batch of queued results, returning once it is full, Linger has passed since its first result
+// was taken off the queue, or the Context is cancelled
+func (p *AsyncPublisher) next(ctx context.Context) ([]*queued, bool) {
+	var batch []*queued
+	select {
+	case q := <-p.queue:
+		batch = append(batch, q)
+	case <-ctx.Done():
+		return nil, false
+	}
+
+	linger := time.NewTimer(p.Linger)
+	defer linger.Stop()
+	for len(batch) < p.BatchSize {
+		select {
+		case q := <-p.queue:
+			batch = append(batch, q)
+		case <-linger.C:
+			return batch, true
+		case <-ctx.Done():
+			return batch, false
+		}
+	}
+	return batch, true
+}
+
+// drain publishes the pending batch and the results left in the queue when shutting down, giving up after
+// 10 seconds
+func (p *AsyncPublisher) drain(batch []*queued) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for {
+	collect:
+		for len(batch) < p.BatchSize {
+			select {
+			case q := <-p.queue:
+				batch = append(batch, q)
+			default:
+				break collect
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+		infoLog.Printf("publish: publishing %d queued results before shutting down", len(batch))
+		p.deliver(ctx, batch)
+		batch = nil
+	}
+}
+
+// deliver publishes a batch of results and reports the outcome
+func (p *AsyncPublisher) deliver(ctx context.Context, batch []*queued) {
+	results := make([]*models.CheckResult, 0, len(batch))
+	for _, q := range batch {
+		results = append(results, q.res)
+	}
+	err := publishBatch(ctx, p.Publisher, results)
+	latency := time.Since(batch[0].at)
+	if err == nil {
+		p.latency.Observe(latency.Seconds())
+		p.published.Add(float64(len(results)))
+		p.report(results, nil, latency)
+		return
+	}
+
+	p.failed.Add(float64(len(results)))
+	warnLog.Printf("publish: unable to publish %d results: %s", len(results), err)
+	p.report(results, err, latency)
+}
+
+func (p *AsyncPublisher) report(results []*models.CheckResult, err error, latency time.Duration) {
+	if p.OnDelivery != nil {
+		p.OnDelivery(results, err, latency)
+	}
+}
+
+// Len returns the number of results waiting in the queue
+func (p *AsyncPublisher) Len() int {
+	return len(p.queue)
+}
+
+// Describe sends the descriptors of the publisher metrics
+func (p *AsyncPublisher) Describe(ch chan<- *prometheus.Desc) {
+	ch <- publishQueueDepth
+	ch <- publishQueueCapacity
+	p.latency.Describe(ch)
+	p.published.Describe(ch)
+	p.failed.Describe(ch)
+	p.dropped.Describe(ch)
+	p.spooled.Describe(ch)
+}
+
+// Collect sends the depth of the queue and the publish latency and counts
+func (p *AsyncPublisher) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(publishQueueDepth, prometheus.GaugeValue, float64(len(p.queue)))
+	ch <- prometheus.MustNewConstMetric(publishQueueCapacity, prometheus.GaugeValue, float64(cap(p.queue)))
+	p.latency.Collect(ch)
+	p.published.Collect(ch)
+	p.failed.Collect(ch)
+	p.dropped.Collect(ch)
+	p.spooled.Collect(ch)
+}
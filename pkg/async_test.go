@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchRecorder is a BatchPublisher recording the batches it was given, failing while err is set
+type batchRecorder struct {
+	sync.Mutex
+	batches [][]*models.CheckResult
+	err     error
+}
+
+func (r *batchRecorder) Publish(ctx context.Context, res *models.CheckResult) error {
+	return r.PublishBatch(ctx, []*models.CheckResult{res})
+}
+
+func (r *batchRecorder) PublishBatch(_ context.Context, results []*models.CheckResult) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.batches = append(r.batches, results)
+	return nil
+}
+
+func (r *batchRecorder) published() []int {
+	r.Lock()
+	defer r.Unlock()
+	ids := make([]int, 0)
+	for _, b := range r.batches {
+		ids = append(ids, siteIDs(b)...)
+	}
+	return ids
+}
+
+func TestAsyncPublisher(t *testing.T) {
+	rec := &batchRecorder{}
+	p := NewAsyncPublisher(rec, 100)
+	p.BatchSize = 4
+	p.Linger = 10 * time.Millisecond
+	delivered := make(chan int, 10)
+	p.OnDelivery = func(results []*models.CheckResult, err error, _ time.Duration) {
+		if err != nil {
+			t.Errorf("want no delivery error, got %s", err)
+		}
+		delivered <- len(results)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 1; i <= 10; i++ {
+		if err := p.Publish(ctx, &models.CheckResult{SiteID: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	p.Start(ctx, &wg)
+
+	// full batches are published right away, the last one once the linger time has passed
+	for _, want := range []int{4, 4, 2} {
+		select {
+		case got := <-delivered:
+			if got != want {
+				t.Errorf("want a batch of %d results, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("want a batch of %d results, got none", want)
+		}
+	}
+	cancel()
+	wg.Wait()
+
+	got := rec.published()
+	if len(got) != 10 || got[0] != 1 || got[9] != 10 {
+		t.Errorf("want results for sites 1 to 10 in order, got %v", got)
+	}
+	if n := testutil.ToFloat64(p.published); n != 10 {
+		t.Errorf("want 10 published results, got %v", n)
+	}
+}
+
+func TestAsyncPublisher_drain(t *testing.T) {
+	rec := &batchRecorder{}
+	p := NewAsyncPublisher(rec, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 1; i <= 3; i++ {
+		if err := p.Publish(context.Background(), &models.CheckResult{SiteID: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// results still queued are published when shutting down
+	var wg sync.WaitGroup
+	p.Start(ctx, &wg)
+	wg.Wait()
+	if got := rec.published(); len(got) != 3 {
+		t.Errorf("want 3 published results, got %v", got)
+	}
+	if p.Len() != 0 {
+		t.Errorf("want an empty queue, got %d results", p.Len())
+	}
+}
+
+func TestAsyncPublisher_failure(t *testing.T) {
+	rec := &batchRecorder{err: errors.New("broker unavailable")}
+	p := NewAsyncPublisher(rec, 10)
+	var failed []*models.CheckResult
+	p.OnDelivery = func(results []*models.CheckResult, err error, _ time.Duration) {
+		if err != nil {
+			failed = append(failed, results...)
+		}
+	}
+	p.deliver(context.Background(), []*queued{{res: &models.CheckResult{SiteID: 1}, at: time.Now()}})
+	if len(failed) != 1 {
+		t.Errorf("want 1 failed result reported, got %d", len(failed))
+	}
+	if n := testutil.ToFloat64(p.failed); n != 1 {
+		t.Errorf("want 1 failed result counted, got %v", n)
+	}
+}
+
+func TestAsyncPublisher_backpressure(t *testing.T) {
+	t.Run("Block", func(t *testing.T) {
+		p := NewAsyncPublisher(&batchRecorder{}, 1)
+		if err := p.Publish(context.Background(), &models.CheckResult{SiteID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := p.Publish(ctx, &models.CheckResult{SiteID: 2}); err == nil {
+			t.Error("want an error once the context is done, got none")
+		}
+	})
+
+	t.Run("Drop oldest", func(t *testing.T) {
+		p := NewAsyncPublisher(&batchRecorder{}, 2)
+		p.Policy = PolicyDropOldest
+		var dropped []int
+		p.OnDelivery = func(results []*models.CheckResult, err error, _ time.Duration) {
+			if err == ErrDropped {
+				dropped = append(dropped, siteIDs(results)...)
+			}
+		}
+		for i := 1; i <= 4; i++ {
+			if err := p.Publish(context.Background(), &models.CheckResult{SiteID: i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(dropped) != 2 || dropped[0] != 1 || dropped[1] != 2 {
+			t.Errorf("want results for sites 1 and 2 dropped, got %v", dropped)
+		}
+		if q := <-p.queue; q.res.SiteID != 3 {
+			t.Errorf("want result for site 3 queued, got %d", q.res.SiteID)
+		}
+		if n := testutil.ToFloat64(p.dropped); n != 2 {
+			t.Errorf("want 2 dropped results counted, got %v", n)
+		}
+	})
+
+	t.Run("Spool", func(t *testing.T) {
+		s, _ := newTestSpool(t, 1<<20, 1<<20)
+		defer s.Close()
+		p := NewAsyncPublisher(&batchRecorder{}, 1)
+		p.Policy = PolicySpool
+		p.Spool = s
+		for i := 1; i <= 3; i++ {
+			if err := p.Publish(context.Background(), &models.CheckResult{SiteID: i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if p.Len() != 1 || s.Len() != 2 {
+			t.Errorf("want 1 queued and 2 spooled results, got %d and %d", p.Len(), s.Len())
+		}
+	})
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, name := range []string{"block", "Drop-Oldest", "spool"} {
+		if _, err := ParsePolicy(name); err != nil {
+			t.Errorf("want policy %q accepted, got %s", name, err)
+		}
+	}
+	if _, err := ParsePolicy("drop-newest"); err == nil {
+		t.Error("want an unknown policy rejected, got no error")
+	}
+}
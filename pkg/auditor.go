@@ -0,0 +1,328 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+	"sync"
+	"time"
+)
+
+// Sink stores batches of check results, as implemented by postgres.ResultModel and sqlite.ResultModel
+// Batches holding results that can never be stored are expected to fail with models.ErrInvalidResult
+type Sink interface {
+	InsertBatch(results []*models.CheckResult) error
+}
+
+// messageReader is the part of a kafka.Reader used by the auditor workers
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// commitTimeout bounds the time taken to commit offsets and send dead letters, which also happens after the
+// worker is cancelled
+const commitTimeout = 10 * time.Second
+
+// Auditor consumes the check results published by the monitors from a Kafka topic and stores them in a sink.
+// Each of its workers reads from the topic as part of the same consumer group, and buffers results so that
+// they are stored in batches, once FlushSize results are pending or FlushInterval has passed.
+// Offsets are only committed once a batch has been stored, so results are delivered at least once and
+// the sink is expected to skip results it already has.
+//
+// Failed writes are retried up to Retries times, waiting Backoff in between and doubling it up to MaxBackoff.
+// Messages that can never be stored are sent to the optional DeadLetters queue, and dropped if it is not set.
+// Workers that stop on an error are restarted with the same backoff
+type Auditor struct {
+	Brokers       []string
+	Dialer        *kafka.Dialer
+	Topic         string
+	GroupID       string
+	Sink          Sink
+	Workers       int
+	FlushSize     int
+	FlushInterval time.Duration
+	DeadLetters   *DeadLetterQueue
+	Retries       int
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	newReader     func() messageReader
+	restarts      prometheus.Counter
+	retries       prometheus.Counter
+	deadLetters   prometheus.Counter
+}
+
+// NewAuditor creates an auditor reading from the Metrics topic as part of the message-reader-group consumer group,
+// with 2 workers storing results in batches of up to 100 every second.
+// Failed writes are retried 5 times, backing off from 500ms up to 30s
+func NewAuditor(brokers []string, dialer *kafka.Dialer, sink Sink) *Auditor {
+	a := &Auditor{
+		Brokers:       brokers,
+		Dialer:        dialer,
+		Topic:         "Metrics",
+		GroupID:       "message-reader-group",
+		Sink:          sink,
+		Workers:       2,
+		FlushSize:     100,
+		FlushInterval: time.Second,
+		Retries:       5,
+		Backoff:       500 * time.Millisecond,
+		MaxBackoff:    30 * time.Second,
+		restarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "healthbee_auditor_restarts_total",
+			Help: "Number of times an auditor was restarted after failing.",
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "healthbee_auditor_retries_total",
+			Help: "Number of retried writes of check results.",
+		}),
+		deadLetters: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "healthbee_auditor_dead_letters_total",
+			Help: "Number of messages that could not be stored and were sent to the dead letter queue.",
+		}),
+	}
+	a.newReader = func() messageReader {
+		return NewReader(a.Brokers, a.Dialer, a.Topic, a.GroupID)
+	}
+	return a
+}
+
+// Describe sends the descriptors of the auditor metrics
+func (a *Auditor) Describe(ch chan<- *prometheus.Desc) {
+	a.restarts.Describe(ch)
+	a.retries.Describe(ch)
+	a.deadLetters.Describe(ch)
+}
+
+// Collect sends the auditor metrics
+func (a *Auditor) Collect(ch chan<- prometheus.Metric) {
+	a.restarts.Collect(ch)
+	a.retries.Collect(ch)
+	a.deadLetters.Collect(ch)
+}
+
+// Start starts the auditor workers, each in a goroutine. The wait group operand is incremented for every worker,
+// and workers can be cancelled via the passed in Context, flushing any pending results as they stop
+func (a *Auditor) Start(ctx context.Context, wg *sync.WaitGroup) {
+	infoLog.Printf("auditor: starting %d readers for incoming metrics...", a.Workers)
+	for id := 1; id <= a.Workers; id++ {
+		wg.Add(1)
+		go a.supervise(ctx, id, wg)
+	}
+}
+
+// supervise runs a worker, restarting it with a new reader whenever it stops on an error
+func (a *Auditor) supervise(ctx context.Context, id int, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	delay := a.Backoff
+	for {
+		started := time.Now()
+		err := a.work(ctx, id, a.newReader())
+		if ctx.Err() != nil {
+			return
+		}
+		// a worker that ran for a while has recovered, so it is restarted promptly
+		if time.Since(started) > a.MaxBackoff {
+			delay = a.Backoff
+		}
+		warnLog.Printf("auditor %d: stopped with: %s, restarting in %s", id, err, delay)
+		a.restarts.Inc()
+		if !sleep(ctx, delay) {
+			return
+		}
+		delay = a.next(delay)
+	}
+}
+
+// work reads messages in the background, batching the check results they hold until the batch is full
+// or the flush interval elapses. It returns with an error if messages can no longer be read or stored
+func (a *Auditor) work(ctx context.Context, id int, r messageReader) error {
+	defer r.Close()
+
+	// the reading goroutine is stopped along with the worker
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var readErr error
+	messages := make(chan kafka.Message)
+	go func() {
+		defer close(messages)
+		for {
+			msg, err := r.FetchMessage(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					readErr = err
+				}
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	t := time.NewTicker(a.FlushInterval)
+	defer t.Stop()
+
+	b := &batch{}
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				if err := a.flush(ctx, id, r, b); err != nil {
+					return err
+				}
+				return readErr
+			}
+			res, err := DecodeResult(msg)
+			if err != nil {
+				warnLog.Printf("auditor %d: unable to detect valid message at offset %d: %s", id, msg.Offset, err.Error())
+				if err := a.deadLetter(id, msg, err); err != nil {
+					return err
+				}
+				// the message is committed along with the batch, so that it is not delivered again
+				b.messages = append(b.messages, msg)
+				continue
+			}
+			b.add(msg, res)
+			if len(b.results) < a.FlushSize {
+				continue
+			}
+			if err := a.flush(ctx, id, r, b); err != nil {
+				return err
+			}
+		case <-t.C:
+			if err := a.flush(ctx, id, r, b); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// batch holds the results pending storage, along with the messages they were read from
+// Messages that were sent to the dead letter queue are only kept to be committed
+type batch struct {
+	results  []*models.CheckResult
+	sources  []kafka.Message
+	messages []kafka.Message
+}
+
+func (b *batch) add(msg kafka.Message, res *models.CheckResult) {
+	b.results = append(b.results, res)
+	b.sources = append(b.sources, msg)
+	b.messages = append(b.messages, msg)
+}
+
+func (b *batch) reset() {
+	b.results = b.results[:0]
+	b.sources = b.sources[:0]
+	b.messages = b.messages[:0]
+}
+
+// flush writes a batch of results to the sink and commits the messages they were read from.
+// Nothing is committed when the write fails, so the messages will be delivered again
+func (a *Auditor) flush(ctx context.Context, id int, r messageReader, b *batch) error {
+	if len(b.messages) == 0 {
+		return nil
+	}
+	if len(b.results) > 0 {
+		if err := a.store(ctx, id, b); err != nil {
+			warnLog.Printf("auditor %d: unable to write %d metrics, failing with: %s", id, len(b.results), err.Error())
+			return err
+		}
+		infoLog.Printf("auditor %d: added %d metrics", id, len(b.results))
+	}
+	cctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancel()
+	if err := r.CommitMessages(cctx, b.messages...); err != nil {
+		warnLog.Printf("auditor %d: unable to commit %d messages, failing with: %s", id, len(b.messages), err.Error())
+		return err
+	}
+	b.reset()
+	return nil
+}
+
+// store writes a batch of results to the sink. If the batch holds results that can never be stored, the results
+// are written one at a time instead, sending the invalid ones to the dead letter queue
+func (a *Auditor) store(ctx context.Context, id int, b *batch) error {
+	err := a.retry(ctx, id, func() error {
+		return a.Sink.InsertBatch(b.results)
+	})
+	if !errors.Is(err, models.ErrInvalidResult) {
+		return err
+	}
+	for i, res := range b.results {
+		res := res
+		err := a.retry(ctx, id, func() error {
+			return a.Sink.InsertBatch([]*models.CheckResult{res})
+		})
+		if errors.Is(err, models.ErrInvalidResult) {
+			err = a.deadLetter(id, b.sources[i], err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retry calls f until it succeeds, fails with an invalid result, or the retries are exhausted.
+// Retries stop early once the context is done
+func (a *Auditor) retry(ctx context.Context, id int, f func() error) error {
+	delay := a.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || errors.Is(err, models.ErrInvalidResult) || attempt > a.Retries {
+			return err
+		}
+		warnLog.Printf("auditor %d: write attempt %d failed with: %s, retrying in %s", id, attempt, err.Error(), delay)
+		a.retries.Inc()
+		if !sleep(ctx, delay) {
+			return err
+		}
+		delay = a.next(delay)
+	}
+}
+
+// deadLetter sends a message that can never be stored to the dead letter queue
+func (a *Auditor) deadLetter(id int, msg kafka.Message, cause error) error {
+	if a.DeadLetters == nil {
+		warnLog.Printf("auditor %d: dropping message at offset %d: %s", id, msg.Offset, cause.Error())
+		a.deadLetters.Inc()
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancel()
+	if err := a.DeadLetters.Send(ctx, msg, cause, id); err != nil {
+		return err
+	}
+	a.deadLetters.Inc()
+	return nil
+}
+
+// next doubles a backoff delay, up to the maximum backoff
+func (a *Auditor) next(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > a.MaxBackoff {
+		return a.MaxBackoff
+	}
+	return delay
+}
+
+// sleep waits for the given duration, reporting false if the context was done before it passed
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
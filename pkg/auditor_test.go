@@ -0,0 +1,221 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeReader is a messageReader that hands out the messages queued on it, and keeps the offsets committed
+type fakeReader struct {
+	messages  chan kafka.Message
+	mu        sync.Mutex
+	committed []int64
+}
+
+func newFakeReader(results ...*models.CheckResult) *fakeReader {
+	values := make([][]byte, 0, len(results))
+	for _, res := range results {
+		data, _ := json.Marshal(res)
+		values = append(values, data)
+	}
+	return newRawReader(values...)
+}
+
+func newRawReader(values ...[]byte) *fakeReader {
+	r := &fakeReader{messages: make(chan kafka.Message, len(values))}
+	for i, v := range values {
+		r.messages <- kafka.Message{Topic: "Metrics", Offset: int64(i), Value: v}
+	}
+	return r
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg, ok := <-r.messages:
+		if !ok {
+			return kafka.Message{}, errors.New("connection lost")
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range msgs {
+		r.committed = append(r.committed, msg.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	return nil
+}
+
+func TestAuditor_work(t *testing.T) {
+	results := make([]*models.CheckResult, 0, 5)
+	for i := 1; i <= 5; i++ {
+		results = append(results, &models.CheckResult{SiteID: i, At: time.Now().UTC(), ResponseCode: 200})
+	}
+
+	tests := []struct {
+		name        string
+		flushSize   int
+		interval    time.Duration
+		wantBatches []int
+	}{
+		{
+			name:        "Flush on size",
+			flushSize:   2,
+			interval:    time.Hour,
+			wantBatches: []int{2, 2, 1},
+		},
+		{
+			name:        "Flush on interval",
+			flushSize:   100,
+			interval:    10 * time.Millisecond,
+			wantBatches: []int{5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memorySink{}
+			a := NewAuditor(nil, nil, sink)
+			a.FlushSize, a.FlushInterval = tt.flushSize, tt.interval
+
+			ctx, cancel := context.WithCancel(context.Background())
+			r := newFakeReader(results...)
+			done := make(chan error)
+			go func() {
+				done <- a.work(ctx, 1, r)
+			}()
+
+			// wait for the interval to pass, the pending results are flushed on cancellation otherwise
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("want nil, got %s", err)
+			}
+
+			if len(sink.results) != len(results) {
+				t.Fatalf("want %d results stored, got %d", len(results), len(sink.results))
+			}
+			if len(sink.batches) != len(tt.wantBatches) {
+				t.Fatalf("want batches %v, got %v", tt.wantBatches, sink.batches)
+			}
+			for i, n := range tt.wantBatches {
+				if sink.batches[i] != n {
+					t.Errorf("want batches %v, got %v", tt.wantBatches, sink.batches)
+				}
+			}
+			if len(r.committed) != len(results) {
+				t.Errorf("want %d messages committed, got %v", len(results), r.committed)
+			}
+		})
+	}
+}
+
+func TestAuditor_work_failure(t *testing.T) {
+	sink := &memorySink{err: errors.New("database unavailable")}
+	a := NewAuditor(nil, nil, sink)
+	a.FlushSize, a.Retries, a.Backoff = 1, 2, time.Millisecond
+
+	r := newFakeReader(&models.CheckResult{SiteID: 1, At: time.Now().UTC()})
+	// the worker stops once the write has been retried
+	if err := a.work(context.Background(), 1, r); err != sink.err {
+		t.Errorf("want %v, got %v", sink.err, err)
+	}
+	if got := testutil.ToFloat64(a.retries); got != 2 {
+		t.Errorf("want 2 retries, got %v", got)
+	}
+	// leaving the message to be delivered again
+	if len(r.committed) != 0 {
+		t.Errorf("want no messages committed, got %v", r.committed)
+	}
+}
+
+// letterBox is a MessageWriter that keeps the messages written to it
+type letterBox struct {
+	messages []kafka.Message
+}
+
+func (l *letterBox) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	l.messages = append(l.messages, msgs...)
+	return nil
+}
+
+func TestAuditor_work_deadLetters(t *testing.T) {
+	valid, _ := json.Marshal(&models.CheckResult{SiteID: 1, At: time.Now().UTC()})
+	invalid, _ := json.Marshal(&models.CheckResult{SiteID: 10, At: time.Now().UTC()})
+	r := newRawReader(valid, []byte("{not json"), invalid, valid)
+	close(r.messages)
+
+	sink := &memorySink{invalid: 10}
+	box := &letterBox{}
+	a := NewAuditor(nil, nil, sink)
+	a.FlushInterval = time.Hour
+	a.DeadLetters = &DeadLetterQueue{Topic: "Metrics-DLQ", Writer: box}
+
+	// the worker stops as the reader fails, flushing what it has read
+	if err := a.work(context.Background(), 1, r); err == nil {
+		t.Errorf("want error from the reader, got nil")
+	}
+	if len(sink.results) != 2 {
+		t.Errorf("want 2 results stored, got %d", len(sink.results))
+	}
+	if len(box.messages) != 2 {
+		t.Fatalf("want 2 dead letters, got %d", len(box.messages))
+	}
+	dl := newDeadLetter(box.messages[0])
+	if dl.SourceTopic != "Metrics" || dl.SourceOffset != 1 || dl.Auditor != 1 || dl.Error == "" {
+		t.Errorf("want dead letter for offset 1 with an error, got %+v", dl)
+	}
+	dl = newDeadLetter(box.messages[1])
+	if dl.SourceOffset != 2 || dl.Error != models.ErrInvalidResult.Error() {
+		t.Errorf("want dead letter for offset 2 with %q, got %+v", models.ErrInvalidResult, dl)
+	}
+	// every message is committed, including the dead letters
+	if len(r.committed) != 4 {
+		t.Errorf("want 4 messages committed, got %v", r.committed)
+	}
+}
+
+func TestAuditor_supervise(t *testing.T) {
+	sink := &memorySink{}
+	a := NewAuditor(nil, nil, sink)
+	a.Workers, a.FlushSize, a.Backoff = 1, 1, time.Millisecond
+
+	// the first reader fails straight away, the second one delivers a result
+	readers := make(chan *fakeReader, 2)
+	failing := newFakeReader()
+	close(failing.messages)
+	readers <- failing
+	readers <- newFakeReader(&models.CheckResult{SiteID: 1, At: time.Now().UTC()})
+	a.newReader = func() messageReader {
+		return <-readers
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	a.Start(ctx, &wg)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	if got := testutil.ToFloat64(a.restarts); got != 1 {
+		t.Errorf("want 1 restart, got %v", got)
+	}
+	if len(sink.results) != 1 {
+		t.Errorf("want 1 result stored, got %d", len(sink.results))
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"io/ioutil"
 	"net"
 	"strconv"
-	"time"
 )
 
 func OpenDB(dsn string) (*sql.DB, error) {
@@ -22,25 +21,41 @@ func OpenDB(dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
-func CreateTopic(name string, brokers []string, config *tls.Config) error {
-	ctlrConn, err := getController(brokers, config)
+// CreateTopic creates a topic on the cluster. A topic that already exists is left as it is, since its partitions
+// and replication are typically managed by the cluster operators, though differences are logged
+func CreateTopic(config kafka.TopicConfig, brokers []string, dialer *kafka.Dialer) error {
+	ctlrConn, err := getController(brokers, dialer)
 	if err != nil {
 		return err
 	}
 	defer ctlrConn.Close()
 
-	topicConfigs := []kafka.TopicConfig{
-		{
-			Topic:             name,
-			NumPartitions:     4,
-			ReplicationFactor: 2,
-		},
+	// listing every partition avoids having the topic auto-created by the metadata request
+	all, err := ctlrConn.ReadPartitions()
+	if err != nil {
+		return err
+	}
+	partitions := make([]kafka.Partition, 0)
+	for _, p := range all {
+		if p.Topic == config.Topic {
+			partitions = append(partitions, p)
+		}
 	}
-	return ctlrConn.CreateTopics(topicConfigs...)
+	if len(partitions) > 0 {
+		if len(partitions) != config.NumPartitions {
+			warnLog.Printf("kafka: topic %s exists with %d partitions, rather than %d", config.Topic, len(partitions), config.NumPartitions)
+		}
+		if len(partitions[0].Replicas) != config.ReplicationFactor {
+			warnLog.Printf("kafka: topic %s exists with a replication factor of %d, rather than %d", config.Topic,
+				len(partitions[0].Replicas), config.ReplicationFactor)
+		}
+		return nil
+	}
+	return ctlrConn.CreateTopics(config)
 }
 
-func DeleteTopic(name string, brokers []string, config *tls.Config) error {
-	ctlrConn, err := getController(brokers, config)
+func DeleteTopic(name string, brokers []string, dialer *kafka.Dialer) error {
+	ctlrConn, err := getController(brokers, dialer)
 	if err != nil {
 		return err
 	}
@@ -49,8 +64,7 @@ func DeleteTopic(name string, brokers []string, config *tls.Config) error {
 	return ctlrConn.DeleteTopics(name)
 }
 
-func getController(brokers []string, config *tls.Config) (*kafka.Conn, error) {
-	dialer := &kafka.Dialer{Timeout: 10 * time.Second, TLS: config}
+func getController(brokers []string, dialer *kafka.Dialer) (*kafka.Conn, error) {
 	conn, err := dialer.Dial("tcp", brokers[0])
 	if err != nil {
 		return nil, err
@@ -63,30 +77,34 @@ func getController(brokers []string, config *tls.Config) (*kafka.Conn, error) {
 	return dialer.Dial("tcp", net.JoinHostPort(ctlr.Host, strconv.Itoa(ctlr.Port)))
 }
 
+// GetTLSConfig loads the certificates for secure communication with Kafka. Without a service certificate and key
+// the client is not authenticated with TLS, as when using SASL, and without a CA the system roots are used
 func GetTLSConfig(srvCertPath, keyPath, caPath string) (*tls.Config, error) {
-	srvCert, err := tls.LoadX509KeyPair(srvCertPath, keyPath)
-	if err != nil {
-		return nil, err
+	config := &tls.Config{}
+	if srvCertPath != "" || keyPath != "" {
+		srvCert, err := tls.LoadX509KeyPair(srvCertPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{srvCert}
 	}
-	cac, err := ioutil.ReadFile(caPath)
-	if err != nil {
-		return nil, err
+	if caPath != "" {
+		cac, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		caPool := x509.NewCertPool()
+		caPool.AppendCertsFromPEM(cac)
+		config.RootCAs = caPool
 	}
-	caPool := x509.NewCertPool()
-	caPool.AppendCertsFromPEM(cac)
-
-	return &tls.Config{
-			Certificates: []tls.Certificate{srvCert},
-			RootCAs:      caPool,
-		},
-		nil
+	return config, nil
 }
 
-func NewReader(brokers []string, dialer *kafka.Dialer) *kafka.Reader {
+func NewReader(brokers []string, dialer *kafka.Dialer, topic, groupID string) *kafka.Reader {
 	return kafka.NewReader(kafka.ReaderConfig{
 		Brokers: brokers,
-		GroupID: "message-reader-group",
-		Topic:   "Metrics",
+		GroupID: groupID,
+		Topic:   topic,
 		Dialer:  dialer,
 	})
 }
@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Headers added to dead letters, recording where the original message was read from and why it could not be stored
+const (
+	HeaderError     = "dlq.error"
+	HeaderTopic     = "dlq.topic"
+	HeaderPartition = "dlq.partition"
+	HeaderOffset    = "dlq.offset"
+	HeaderAuditor   = "dlq.auditor"
+	HeaderAt        = "dlq.at"
+)
+
+// MessageWriter publishes messages to a Kafka topic, as implemented by kafka.Writer
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// DeadLetterQueue keeps messages that can never be stored, such as malformed results or results of deleted sites,
+// on a separate topic so that they do not hold up the auditors and can be inspected later
+type DeadLetterQueue struct {
+	Topic   string
+	Brokers []string
+	Dialer  *kafka.Dialer
+	Writer  MessageWriter
+}
+
+// NewDeadLetterQueue creates a dead letter queue for the given topic, which is expected to exist
+func NewDeadLetterQueue(topic string, brokers []string, dialer *kafka.Dialer) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		Topic:   topic,
+		Brokers: brokers,
+		Dialer:  dialer,
+		Writer: &kafka.Writer{
+			Addr:  kafka.TCP(brokers...),
+			Topic: topic, RequiredAcks: kafka.RequireAll,
+			Transport: &kafka.Transport{TLS: dialer.TLS, SASL: dialer.SASLMechanism},
+		},
+	}
+}
+
+// Send publishes a message to the dead letter queue, along with the error that prevented it from being stored.
+// The key, value and headers of the original message are kept as they are
+func (q *DeadLetterQueue) Send(ctx context.Context, msg kafka.Message, cause error, auditor int) error {
+	headers := make([]kafka.Header, 0, len(msg.Headers)+6)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: HeaderError, Value: []byte(cause.Error())},
+		kafka.Header{Key: HeaderTopic, Value: []byte(msg.Topic)},
+		kafka.Header{Key: HeaderPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: HeaderOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: HeaderAuditor, Value: []byte(strconv.Itoa(auditor))},
+		kafka.Header{Key: HeaderAt, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+	)
+	err := q.Writer.WriteMessages(ctx, kafka.Message{Key: msg.Key, Value: msg.Value, Headers: headers})
+	if err != nil {
+		return fmt.Errorf("dead letter failed with: %s", err)
+	}
+	return nil
+}
+
+// Close closes the writer of the dead letter queue
+func (q *DeadLetterQueue) Close() error {
+	if c, ok := q.Writer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// DeadLetter is a message on the dead letter queue, along with the metadata recorded when it was sent there
+type DeadLetter struct {
+	Partition       int       `json:"partition"`
+	Offset          int64     `json:"offset"`
+	Key             string    `json:"key"`
+	Value           string    `json:"value"`
+	Error           string    `json:"error"`
+	SourceTopic     string    `json:"source_topic"`
+	SourcePartition int       `json:"source_partition"`
+	SourceOffset    int64     `json:"source_offset"`
+	Auditor         int       `json:"auditor"`
+	At              time.Time `json:"at"`
+}
+
+func newDeadLetter(msg kafka.Message) *DeadLetter {
+	dl := &DeadLetter{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       string(msg.Key),
+		Value:     string(msg.Value),
+		At:        msg.Time,
+	}
+	for _, h := range msg.Headers {
+		v := string(h.Value)
+		switch h.Key {
+		case HeaderError:
+			dl.Error = v
+		case HeaderTopic:
+			dl.SourceTopic = v
+		case HeaderPartition:
+			dl.SourcePartition, _ = strconv.Atoi(v)
+		case HeaderOffset:
+			dl.SourceOffset, _ = strconv.ParseInt(v, 10, 64)
+		case HeaderAuditor:
+			dl.Auditor, _ = strconv.Atoi(v)
+		case HeaderAt:
+			if at, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				dl.At = at
+			}
+		}
+	}
+	return dl
+}
+
+// Browse fetches up to limit of the most recent dead letters, across all partitions of the topic and
+// newest first. Browsing does not consume the dead letters
+func (q *DeadLetterQueue) Browse(ctx context.Context, limit int) ([]*DeadLetter, error) {
+	conn, err := q.Dialer.DialContext(ctx, "tcp", q.Brokers[0])
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := conn.ReadPartitions(q.Topic)
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*DeadLetter, 0)
+	for _, p := range partitions {
+		dls, err := q.browsePartition(ctx, p.ID, limit)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, dls...)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].At.After(letters[j].At)
+	})
+	if len(letters) > limit {
+		letters = letters[:limit]
+	}
+	return letters, nil
+}
+
+// browsePartition reads the last limit messages of a partition of the dead letter queue
+func (q *DeadLetterQueue) browsePartition(ctx context.Context, partition, limit int) ([]*DeadLetter, error) {
+	conn, err := q.Dialer.DialLeader(ctx, "tcp", q.Brokers[0], q.Topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	first, last, err := conn.ReadOffsets()
+	if err != nil {
+		return nil, err
+	}
+	start := last - int64(limit)
+	if start < first {
+		start = first
+	}
+	if _, err := conn.Seek(start, kafka.SeekAbsolute); err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+	} else {
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	}
+
+	letters := make([]*DeadLetter, 0, last-start)
+	for offset := start; offset < last; {
+		msg, err := conn.ReadMessage(10e6)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, newDeadLetter(msg))
+		offset = msg.Offset + 1
+	}
+	return letters, nil
+}
@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"github.com/dnataraj/healthbee/pkg/models"
+	"sync"
+)
+
+// Dependencies is the graph of site dependencies, along with the latest known status of every monitored site.
+// Monitors consult it to tell a failing site apart from one that is merely unreachable because a site it
+// depends on (for example a load balancer or DNS check) is down.
+// It is safe for concurrent use
+type Dependencies struct {
+	sync.RWMutex
+	parents map[int][]int
+	status  map[int]string
+}
+
+func NewDependencies() *Dependencies {
+	return &Dependencies{
+		parents: make(map[int][]int),
+		status:  make(map[int]string),
+	}
+}
+
+// Set replaces the parents of a site, failing with models.ErrDependencyCycle if the site would end up
+// depending on itself
+func (d *Dependencies) Set(siteID int, parents []int) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.cyclic(siteID, parents) {
+		return models.ErrDependencyCycle
+	}
+	if len(parents) == 0 {
+		delete(d.parents, siteID)
+		return nil
+	}
+	d.parents[siteID] = append([]int(nil), parents...)
+	return nil
+}
+
+// Check reports whether the given parents could be set for a site without introducing a cycle
+func (d *Dependencies) Check(siteID int, parents []int) error {
+	d.RLock()
+	defer d.RUnlock()
+	if d.cyclic(siteID, parents) {
+		return models.ErrDependencyCycle
+	}
+	return nil
+}
+
+// cyclic checks if any of the given parents already depends on the site
+func (d *Dependencies) cyclic(siteID int, parents []int) bool {
+	for _, p := range parents {
+		if p == siteID || d.reaches(p, siteID, make(map[int]bool)) {
+			return true
+		}
+	}
+	return false
+}
+
+// reaches walks the parents of a site depth first, to find out if it depends on the target site
+func (d *Dependencies) reaches(from, target int, seen map[int]bool) bool {
+	if seen[from] {
+		return false
+	}
+	seen[from] = true
+	for _, p := range d.parents[from] {
+		if p == target || d.reaches(p, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parents returns the sites a site directly depends on
+func (d *Dependencies) Parents(siteID int) []int {
+	d.RLock()
+	defer d.RUnlock()
+	return append([]int(nil), d.parents[siteID]...)
+}
+
+// Remove forgets a deleted site, along with its status and the dependencies of other sites on it
+func (d *Dependencies) Remove(siteID int) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.parents, siteID)
+	delete(d.status, siteID)
+	for id, parents := range d.parents {
+		kept := make([]int, 0, len(parents))
+		for _, p := range parents {
+			if p != siteID {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == 0 {
+			delete(d.parents, id)
+		} else {
+			d.parents[id] = kept
+		}
+	}
+}
+
+// Record keeps the latest status of a site, as evaluated by its monitor
+func (d *Dependencies) Record(siteID int, status string) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.status[siteID] = status
+}
+
+// Blocked reports whether any of the parents of a site is currently down or itself unreachable,
+// along with the ID of that parent
+func (d *Dependencies) Blocked(siteID int) (int, bool) {
+	if d == nil {
+		return 0, false
+	}
+	d.RLock()
+	defer d.RUnlock()
+	for _, p := range d.parents[siteID] {
+		if s := d.status[p]; s == models.StatusDown || s == models.StatusUnreachable {
+			return p, true
+		}
+	}
+	return 0, false
+}
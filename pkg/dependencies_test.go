@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"github.com/dnataraj/healthbee/pkg/models"
+	"testing"
+)
+
+func TestDependencies_Set(t *testing.T) {
+	// 3 (app) -> 2 (load balancer) -> 1 (dns)
+	d := NewDependencies()
+	if err := d.Set(2, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set(3, []int{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		siteID    int
+		parents   []int
+		wantError error
+	}{
+		{name: "Self dependency", siteID: 1, parents: []int{1}, wantError: models.ErrDependencyCycle},
+		{name: "Direct cycle", siteID: 1, parents: []int{2}, wantError: models.ErrDependencyCycle},
+		{name: "Transitive cycle", siteID: 1, parents: []int{4, 3}, wantError: models.ErrDependencyCycle},
+		{name: "Shared parent", siteID: 4, parents: []int{1, 2}, wantError: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := d.Check(tt.siteID, tt.parents); err != tt.wantError {
+				t.Errorf("want %v, got %v", tt.wantError, err)
+			}
+		})
+	}
+}
+
+func TestDependencies_Blocked(t *testing.T) {
+	d := NewDependencies()
+	if err := d.Set(2, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set(3, []int{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Record(1, models.StatusUp)
+	if _, blocked := d.Blocked(2); blocked {
+		t.Errorf("want site 2 reachable while its parent is up")
+	}
+
+	d.Record(1, models.StatusDown)
+	d.Record(2, models.StatusUnreachable)
+	if parent, blocked := d.Blocked(2); !blocked || parent != 1 {
+		t.Errorf("want site 2 blocked by site 1, got %d", parent)
+	}
+	if parent, blocked := d.Blocked(3); !blocked || parent != 2 {
+		t.Errorf("want site 3 blocked by site 2, got %d", parent)
+	}
+}
+
+func TestDependencies_Remove(t *testing.T) {
+	// 3 (app) -> 2 (load balancer) -> 1 (dns), where 2 is removed
+	d := NewDependencies()
+	_ = d.Set(2, []int{1})
+	_ = d.Set(3, []int{2, 1})
+	d.Record(2, models.StatusDown)
+
+	d.Remove(2)
+	if parents := d.Parents(2); len(parents) != 0 {
+		t.Errorf("want no parents for the removed site, got %v", parents)
+	}
+	if parents := d.Parents(3); len(parents) != 1 || parents[0] != 1 {
+		t.Errorf("want [1], got %v", parents)
+	}
+	if _, blocked := d.Blocked(3); blocked {
+		t.Error("want site 3 not to be blocked by the removed site")
+	}
+	if err := d.Check(1, []int{3}); err != models.ErrDependencyCycle {
+		t.Errorf("want %v, got %v", models.ErrDependencyCycle, err)
+	}
+}
+
+func TestMonitor_evaluate(t *testing.T) {
+	d := NewDependencies()
+	if err := d.Set(2, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	d.Record(1, models.StatusDown)
+
+	n := &recorder{}
+	m := NewMonitor(&models.Site{ID: 2, URL: "http://app"}, nil)
+	m.Dependencies = d
+	m.Notifier = n
+	defer m.Cancel()
+
+	res := &models.CheckResult{SiteID: 2, ResponseCode: 503}
+	m.evaluate(res)
+	if !res.Unreachable || len(n.alerts) != 0 {
+		t.Errorf("want unreachable result and no alerts, got %+v and %d alerts", res, len(n.alerts))
+	}
+
+	// the parent recovers, but the site is still down
+	d.Record(1, models.StatusUp)
+	res = &models.CheckResult{SiteID: 2, ResponseCode: 503}
+	m.evaluate(res)
+	if res.Unreachable || len(n.alerts) != 1 || n.alerts[0].Status != models.StatusDown {
+		t.Errorf("want a single down alert, got %+v", n.alerts)
+	}
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/segmentio/kafka-go"
+	"strconv"
+	"time"
+)
+
+// Types of site lifecycle events
+const (
+	EventRegistered   = "registered"
+	EventUpdated      = "updated"
+	EventPaused       = "paused"
+	EventResumed      = "resumed"
+	EventDeleted      = "deleted"
+	EventStateChanged = "state_changed"
+)
+
+// HeaderEventType is added to site events, so that consumers can pick the events they are interested in
+// without decoding them
+const HeaderEventType = "event.type"
+
+// SiteEvent describes a change to a monitored site, either to its registration or to its state.
+// Registration events carry the site as it is after the change, while state changes carry the previous and
+// the new status of the site
+type SiteEvent struct {
+	Type     string            `json:"type"`
+	SiteID   int               `json:"site_id"`
+	At       time.Time         `json:"at"`
+	Site     *models.Site      `json:"site,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Status   string            `json:"status,omitempty"`
+	Previous string            `json:"previous,omitempty"`
+	Source   string            `json:"source,omitempty"`
+}
+
+// NewSiteEvent creates an event of the given type for a change to the registration of a site.
+// The heartbeat token of the site is left out of the event
+func NewSiteEvent(typ string, s *models.Site) *SiteEvent {
+	site := *s
+	site.Token = ""
+	return &SiteEvent{Type: typ, SiteID: s.ID, At: time.Now().UTC(), Site: &site, Labels: s.Labels}
+}
+
+// EventPublisher delivers site lifecycle events to interested parties, such as a CMDB or incident tooling
+type EventPublisher interface {
+	PublishEvent(ctx context.Context, e *SiteEvent) error
+}
+
+// KafkaEventPublisher publishes site events as JSON to a Kafka topic, keyed by the site ID so that the
+// events of a site are kept in order
+type KafkaEventPublisher struct {
+	Writer MessageWriter
+}
+
+func (p *KafkaEventPublisher) PublishEvent(ctx context.Context, e *SiteEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("event failed with: %s", err)
+	}
+	err = p.Writer.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(strconv.Itoa(e.SiteID)),
+		Value:   data,
+		Headers: []kafka.Header{{Key: HeaderEventType, Value: []byte(e.Type)}},
+	})
+	if err != nil {
+		return fmt.Errorf("event failed with: %s", err)
+	}
+	return nil
+}
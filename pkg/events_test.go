@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"testing"
+)
+
+func TestKafkaEventPublisher(t *testing.T) {
+	box := &letterBox{}
+	p := &KafkaEventPublisher{Writer: box}
+	site := &models.Site{ID: 7, Type: models.SiteHeartbeat, Token: "secret", Labels: map[string]string{"team": "payments"}}
+	if err := p.PublishEvent(context.Background(), NewSiteEvent(EventRegistered, site)); err != nil {
+		t.Fatal(err)
+	}
+	if len(box.messages) != 1 {
+		t.Fatalf("want 1 message, got %d", len(box.messages))
+	}
+	msg := box.messages[0]
+	if string(msg.Key) != "7" {
+		t.Errorf("want key 7, got %q", msg.Key)
+	}
+	if len(msg.Headers) != 1 || msg.Headers[0].Key != HeaderEventType || string(msg.Headers[0].Value) != EventRegistered {
+		t.Errorf("want an %s header with %q, got %v", HeaderEventType, EventRegistered, msg.Headers)
+	}
+	e := &SiteEvent{}
+	if err := json.Unmarshal(msg.Value, e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventRegistered || e.SiteID != 7 || e.Labels["team"] != "payments" {
+		t.Errorf("want a registered event for site 7, got %+v", e)
+	}
+	if e.Site == nil || e.Site.Token != "" {
+		t.Errorf("want the site without its token, got %+v", e.Site)
+	}
+	if site.Token != "secret" {
+		t.Error("want the site itself left as it is")
+	}
+}
+
+// eventLog is an EventPublisher that keeps the events published to it
+type eventLog struct {
+	events []*SiteEvent
+}
+
+func (l *eventLog) PublishEvent(_ context.Context, e *SiteEvent) error {
+	l.events = append(l.events, e)
+	return nil
+}
+
+func TestMonitor_evaluate_events(t *testing.T) {
+	events := &eventLog{}
+	m := NewMonitor(&models.Site{ID: 3, URL: "http://app"}, nil)
+	m.Events = events
+	m.Source = "bee-1"
+	defer m.Cancel()
+
+	for _, code := range []int{200, 200, 503, 503, 200} {
+		m.evaluate(&models.CheckResult{SiteID: 3, ResponseCode: code, MatchedPattern: true})
+	}
+	if len(events.events) != 2 {
+		t.Fatalf("want 2 state changes, got %d", len(events.events))
+	}
+	down, up := events.events[0], events.events[1]
+	if down.Type != EventStateChanged || down.Previous != models.StatusUp || down.Status != models.StatusDown {
+		t.Errorf("want a change from up to down, got %+v", down)
+	}
+	if up.Previous != models.StatusDown || up.Status != models.StatusUp || up.Source != "bee-1" {
+		t.Errorf("want a change from down to up by bee-1, got %+v", up)
+	}
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formats that results can be exported in
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// ExportContentTypes identify the export formats in HTTP responses
+var ExportContentTypes = map[string]string{
+	FormatCSV:     "text/csv",
+	FormatNDJSON:  "application/x-ndjson",
+	FormatParquet: "application/vnd.apache.parquet",
+}
+
+// Exporter writes check results to a stream, one at a time
+type Exporter interface {
+	Write(res *models.CheckResult) error
+	// Close completes the export, such as writing the footer of a Parquet file, but does not close the stream
+	Close() error
+}
+
+// NewExporter returns an exporter writing results to w, in either the csv, ndjson or parquet format. Exporters may
+// write to w as soon as they are created, such as the header of a CSV export
+func NewExporter(format string, w io.Writer) (Exporter, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		return &csvExporter{w: cw}, cw.Write(csvHeader)
+	case FormatNDJSON:
+		return &ndjsonExporter{enc: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		pw, err := writer.NewParquetWriterFromWriter(w, new(parquetResult), 1)
+		if err != nil {
+			return nil, err
+		}
+		pw.RowGroupSize = parquetRowGroupSize
+		pw.CompressionType = parquet.CompressionCodec_SNAPPY
+		return &parquetExporter{w: pw}, nil
+	}
+	return nil, fmt.Errorf("export: unknown format %q, expecting csv, ndjson or parquet", format)
+}
+
+// csvHeader names the columns of exported results, response times are in milliseconds
+var csvHeader = []string{"id", "site_id", "checked_at", "response_time_ms", "response_code", "matched", "maintenance", "unreachable", "source"}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) Write(res *models.CheckResult) error {
+	return e.w.Write([]string{
+		strconv.Itoa(res.ID),
+		strconv.Itoa(res.SiteID),
+		res.At.UTC().Format(time.RFC3339Nano),
+		strconv.FormatInt(res.ResponseTime.Duration().Milliseconds(), 10),
+		strconv.Itoa(res.ResponseCode),
+		strconv.FormatBool(res.MatchedPattern),
+		strconv.FormatBool(res.Maintenance),
+		strconv.FormatBool(res.Unreachable),
+		res.Source,
+	})
+}
+
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonExporter writes results as newline delimited JSON, encoded like the results returned by the API
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExporter) Write(res *models.CheckResult) error {
+	return e.enc.Encode(res)
+}
+
+func (e *ndjsonExporter) Close() error {
+	return nil
+}
+
+// parquetRowGroupSize caps the size of a row group, which is buffered in memory until it is written out
+const parquetRowGroupSize = 16 * 1024 * 1024
+
+// parquetResult is the schema of results exported as Parquet, with the same columns as the CSV export
+type parquetResult struct {
+	ID           int64  `parquet:"name=id, type=INT64"`
+	SiteID       int64  `parquet:"name=site_id, type=INT64"`
+	CheckedAt    int64  `parquet:"name=checked_at, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	ResponseTime int64  `parquet:"name=response_time_ms, type=INT64"`
+	ResponseCode int32  `parquet:"name=response_code, type=INT32"`
+	Matched      bool   `parquet:"name=matched, type=BOOLEAN"`
+	Maintenance  bool   `parquet:"name=maintenance, type=BOOLEAN"`
+	Unreachable  bool   `parquet:"name=unreachable, type=BOOLEAN"`
+	Source       string `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+type parquetExporter struct {
+	w *writer.ParquetWriter
+}
+
+func (e *parquetExporter) Write(res *models.CheckResult) error {
+	return e.w.Write(parquetResult{
+		ID:           int64(res.ID),
+		SiteID:       int64(res.SiteID),
+		CheckedAt:    res.At.UnixNano() / int64(time.Microsecond),
+		ResponseTime: res.ResponseTime.Duration().Milliseconds(),
+		ResponseCode: int32(res.ResponseCode),
+		Matched:      res.MatchedPattern,
+		Maintenance:  res.Maintenance,
+		Unreachable:  res.Unreachable,
+		Source:       res.Source,
+	})
+}
+
+func (e *parquetExporter) Close() error {
+	return e.w.WriteStop()
+}
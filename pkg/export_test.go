@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewExporter(t *testing.T) {
+	results := []*models.CheckResult{
+		{
+			ID:             1,
+			SiteID:         2,
+			At:             time.Date(2021, 3, 2, 3, 0, 0, 123456000, time.UTC),
+			ResponseTime:   models.Period(600 * time.Millisecond),
+			ResponseCode:   200,
+			MatchedPattern: true,
+			Source:         "bee-1",
+		},
+		{
+			ID:           2,
+			SiteID:       2,
+			At:           time.Date(2021, 3, 2, 3, 0, 5, 0, time.UTC),
+			ResponseTime: models.Period(-time.Millisecond),
+			ResponseCode: -1,
+			Maintenance:  true,
+			Unreachable:  true,
+		},
+	}
+	export := func(t *testing.T, format string) []byte {
+		var buf bytes.Buffer
+		e, err := NewExporter(format, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range results {
+			if err := e.Write(res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		want := "id,site_id,checked_at,response_time_ms,response_code,matched,maintenance,unreachable,source\n" +
+			"1,2,2021-03-02T03:00:00.123456Z,600,200,true,false,false,bee-1\n" +
+			"2,2,2021-03-02T03:00:05Z,-1,-1,false,true,true,\n"
+		if got := string(export(t, FormatCSV)); got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSuffix(string(export(t, FormatNDJSON)), "\n"), "\n")
+		if len(lines) != len(results) {
+			t.Fatalf("want %d lines, got %d", len(results), len(lines))
+		}
+		for i, line := range lines {
+			res := &models.CheckResult{}
+			if err := json.Unmarshal([]byte(line), res); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res, results[i]) {
+				t.Errorf("want %+v, got %+v", results[i], res)
+			}
+		}
+	})
+
+	t.Run("Parquet", func(t *testing.T) {
+		f, err := buffer.NewBufferFile(export(t, FormatParquet))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pr, err := reader.NewParquetReader(f, new(parquetResult), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pr.ReadStop()
+		if n := pr.GetNumRows(); n != int64(len(results)) {
+			t.Fatalf("want %d rows, got %d", len(results), n)
+		}
+		rows := make([]parquetResult, len(results))
+		if err := pr.Read(&rows); err != nil {
+			t.Fatal(err)
+		}
+		want := parquetResult{ID: 1, SiteID: 2, CheckedAt: results[0].At.UnixNano() / 1000, ResponseTime: 600, ResponseCode: 200, Matched: true, Source: "bee-1"}
+		if rows[0] != want {
+			t.Errorf("want %+v, got %+v", want, rows[0])
+		}
+		if rows[1].ResponseCode != -1 || !rows[1].Maintenance || !rows[1].Unreachable {
+			t.Errorf("want a failed check during maintenance, got %+v", rows[1])
+		}
+	})
+
+	if _, err := NewExporter("xlsx", &bytes.Buffer{}); err == nil {
+		t.Error("want an error for an unknown format, got none")
+	}
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"sync"
+	"time"
+)
+
+// NewToken generates a random token used in the ping URL of a heartbeat site
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Ping records a ping received from a heartbeat site, such as a cron job or batch worker reporting in
+func (m *Monitor) Ping(at time.Time) {
+	select {
+	case m.pings <- at:
+	default:
+		// a ping is already pending, which is just as good
+	}
+}
+
+// watch is the inverse of the monitor ticker loop for heartbeat sites. Rather than checking the site every
+// interval, the monitor waits for pings and reports the site as down once no ping has arrived within the
+// expected period plus the grace time. A down result is published every period for as long as the site stays silent.
+// Monitoring starts as though a ping had just been received, giving the site a full period to report in
+func (m *Monitor) watch(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func(wg *sync.WaitGroup) {
+		defer wg.Done()
+
+		period, grace := m.Site.Interval.Duration(), m.Site.Grace.Duration()
+		t := time.NewTimer(period + grace)
+		defer t.Stop()
+
+		for {
+			select {
+			case at := <-m.pings:
+				at = at.UTC()
+				infoLog.Printf("monitor: heartbeat [%d] pinged at %s", m.Site.ID, at.Format(time.Stamp))
+				m.process(&models.CheckResult{
+					SiteID:         m.Site.ID,
+					At:             at,
+					ResponseCode:   200,
+					MatchedPattern: true,
+				})
+				if !t.Stop() {
+					<-t.C
+				}
+				t.Reset(period + grace)
+			case now := <-t.C:
+				at := now.UTC()
+				warnLog.Printf("monitor: heartbeat [%d] overdue at %s", m.Site.ID, at.Format(time.Stamp))
+				m.process(&models.CheckResult{
+					SiteID:         m.Site.ID,
+					At:             at,
+					ResponseTime:   -1,
+					ResponseCode:   -1,
+					MatchedPattern: false,
+				})
+				t.Reset(period)
+			case <-m.Context.Done():
+				infoLog.Printf("monitor: monitoring halted for site [%d]", m.Site.ID)
+				return
+			}
+		}
+	}(wg)
+}
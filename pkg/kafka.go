@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"strings"
+	"time"
+)
+
+// KafkaConfig describes how HealthBee connects to Kafka, the topics and consumer group it uses and how
+// results are produced
+type KafkaConfig struct {
+	Brokers []string
+	TLS     *tls.Config
+	SASL    sasl.Mechanism
+
+	Topic             string
+	DeadLetterTopic   string
+	EventsTopic       string
+	GroupID           string
+	Partitions        int
+	ReplicationFactor int
+
+	Compression  kafka.Compression
+	BatchSize    int
+	BatchTimeout time.Duration
+	RequiredAcks kafka.RequiredAcks
+}
+
+// NewKafkaConfig creates a configuration for the given brokers, with the topics and consumer group HealthBee
+// has always used, and the producer defaults of kafka-go waiting for all replicas to acknowledge
+func NewKafkaConfig(brokers []string) *KafkaConfig {
+	return &KafkaConfig{
+		Brokers:           brokers,
+		Topic:             "Metrics",
+		DeadLetterTopic:   "Metrics-DLQ",
+		EventsTopic:       "SiteEvents",
+		GroupID:           "message-reader-group",
+		Partitions:        4,
+		ReplicationFactor: 2,
+		BatchSize:         100,
+		BatchTimeout:      time.Second,
+		RequiredAcks:      kafka.RequireAll,
+	}
+}
+
+// Dialer returns a dialer using the TLS and SASL configuration
+func (c *KafkaConfig) Dialer() *kafka.Dialer {
+	return &kafka.Dialer{Timeout: 10 * time.Second, TLS: c.TLS, SASLMechanism: c.SASL}
+}
+
+// Writer returns a writer for the results topic, with the producer settings of the configuration
+func (c *KafkaConfig) Writer() *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(c.Brokers...),
+		Topic:        c.Topic,
+		Compression:  c.Compression,
+		BatchSize:    c.BatchSize,
+		BatchTimeout: c.BatchTimeout,
+		RequiredAcks: c.RequiredAcks,
+		Transport:    &kafka.Transport{TLS: c.TLS, SASL: c.SASL},
+	}
+}
+
+// EventWriter returns a writer for the site events topic. Events are few and far between, so they are sent
+// right away rather than waiting for a batch to fill up
+func (c *KafkaConfig) EventWriter() *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(c.Brokers...),
+		Topic:        c.EventsTopic,
+		BatchTimeout: 10 * time.Millisecond,
+		RequiredAcks: kafka.RequireAll,
+		Transport:    &kafka.Transport{TLS: c.TLS, SASL: c.SASL},
+	}
+}
+
+// TopicConfigs returns the configuration of the results, dead letter and site events topics
+func (c *KafkaConfig) TopicConfigs() []kafka.TopicConfig {
+	return []kafka.TopicConfig{
+		{Topic: c.Topic, NumPartitions: c.Partitions, ReplicationFactor: c.ReplicationFactor},
+		{Topic: c.DeadLetterTopic, NumPartitions: c.Partitions, ReplicationFactor: c.ReplicationFactor},
+		{Topic: c.EventsTopic, NumPartitions: c.Partitions, ReplicationFactor: c.ReplicationFactor},
+	}
+}
+
+// NewSASLMechanism returns the SASL mechanism for the given name, either plain, scram-sha-256 or scram-sha-512.
+// An empty name disables SASL authentication
+func NewSASLMechanism(name, username, password string) (sasl.Mechanism, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	}
+	return nil, fmt.Errorf("kafka: unknown SASL mechanism %q, expecting plain, scram-sha-256 or scram-sha-512", name)
+}
+
+// ParseCompression returns the compression codec for the given name, either none, gzip, snappy, lz4 or zstd
+func ParseCompression(name string) (kafka.Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	}
+	return 0, fmt.Errorf("kafka: unknown compression %q, expecting none, gzip, snappy, lz4 or zstd", name)
+}
+
+// ParseRequiredAcks returns the acknowledgement level for the given name, either none, one or all
+func ParseRequiredAcks(name string) (kafka.RequiredAcks, error) {
+	switch strings.ToLower(name) {
+	case "none", "0":
+		return kafka.RequireNone, nil
+	case "one", "1":
+		return kafka.RequireOne, nil
+	case "all", "-1":
+		return kafka.RequireAll, nil
+	}
+	return 0, fmt.Errorf("kafka: unknown required acks %q, expecting none, one or all", name)
+}
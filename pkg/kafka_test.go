@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"github.com/segmentio/kafka-go"
+	"testing"
+)
+
+func TestNewSASLMechanism(t *testing.T) {
+	tests := []struct {
+		name      string
+		mechanism string
+		wantName  string
+		wantError bool
+	}{
+		{name: "Disabled", mechanism: "", wantName: ""},
+		{name: "Plain", mechanism: "plain", wantName: "PLAIN"},
+		{name: "SCRAM SHA-256", mechanism: "scram-sha-256", wantName: "SCRAM-SHA-256"},
+		{name: "SCRAM SHA-512", mechanism: "SCRAM-SHA-512", wantName: "SCRAM-SHA-512"},
+		{name: "Unknown mechanism", mechanism: "gssapi", wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewSASLMechanism(tt.mechanism, "healthbee", "secret")
+			if (err != nil) != tt.wantError {
+				t.Fatalf("want error %t, got %v", tt.wantError, err)
+			}
+			name := ""
+			if m != nil {
+				name = m.Name()
+			}
+			if name != tt.wantName {
+				t.Errorf("want %q, got %q", tt.wantName, name)
+			}
+		})
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		name      string
+		want      kafka.Compression
+		wantError bool
+	}{
+		{name: "none", want: 0},
+		{name: "gzip", want: kafka.Gzip},
+		{name: "snappy", want: kafka.Snappy},
+		{name: "lz4", want: kafka.Lz4},
+		{name: "zstd", want: kafka.Zstd},
+		{name: "brotli", wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCompression(tt.name)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("want error %t, got %v", tt.wantError, err)
+			}
+			if c != tt.want {
+				t.Errorf("want %v, got %v", tt.want, c)
+			}
+		})
+	}
+}
+
+func TestParseRequiredAcks(t *testing.T) {
+	tests := []struct {
+		name      string
+		want      kafka.RequiredAcks
+		wantError bool
+	}{
+		{name: "none", want: kafka.RequireNone},
+		{name: "1", want: kafka.RequireOne},
+		{name: "all", want: kafka.RequireAll},
+		{name: "quorum", wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acks, err := ParseRequiredAcks(tt.name)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("want error %t, got %v", tt.wantError, err)
+			}
+			if acks != tt.want {
+				t.Errorf("want %v, got %v", tt.want, acks)
+			}
+		})
+	}
+}
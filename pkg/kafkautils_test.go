@@ -4,6 +4,7 @@ import (
 	"github.com/segmentio/kafka-go"
 	"os"
 	"testing"
+	"time"
 )
 
 type config struct {
@@ -29,7 +30,8 @@ func newWriter(t *testing.T) (*kafka.Writer, func(tt *testing.T)) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = CreateTopic("Metrics-Test", []string{config.endpoint}, tlsConfig)
+	dialer := &kafka.Dialer{Timeout: 10 * time.Second, TLS: tlsConfig}
+	err = CreateTopic(kafka.TopicConfig{Topic: "Metrics-Test", NumPartitions: 4, ReplicationFactor: 2}, []string{config.endpoint}, dialer)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -46,7 +48,7 @@ func newWriter(t *testing.T) (*kafka.Writer, func(tt *testing.T)) {
 			t.Fatal(err)
 		}
 		// clean up topic
-		err = DeleteTopic("Metrics-Test", []string{config.endpoint}, tlsConfig)
+		err = DeleteTopic("Metrics-Test", []string{config.endpoint}, dialer)
 		if err != nil {
 			t.Fatal(err)
 		}
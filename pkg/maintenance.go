@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/robfig/cron/v3"
+	"github.com/teambition/rrule-go"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recurrence determines whether a maintenance window is in effect at a given time
+type recurrence interface {
+	active(at time.Time) bool
+}
+
+// oneOff is a maintenance window with a single occurrence
+type oneOff struct {
+	start    time.Time
+	duration time.Duration
+}
+
+func (o *oneOff) active(at time.Time) bool {
+	return !at.Before(o.start) && at.Before(o.start.Add(o.duration))
+}
+
+// cronWindow is a maintenance window whose occurrences begin on a cron schedule
+type cronWindow struct {
+	start    time.Time
+	duration time.Duration
+	schedule cron.Schedule
+}
+
+// active checks if an occurrence started within the window duration before the given time.
+// Since cron schedules only yield the next activation, we look for the first one after (at - duration)
+func (c *cronWindow) active(at time.Time) bool {
+	if at.Before(c.start) {
+		return false
+	}
+	next := c.schedule.Next(at.Add(-c.duration))
+	return !next.After(at) && !next.Before(c.start)
+}
+
+// ruleWindow is a maintenance window whose occurrences are determined by an RFC 5545 RRULE
+type ruleWindow struct {
+	duration time.Duration
+	rule     *rrule.RRule
+}
+
+func (r *ruleWindow) active(at time.Time) bool {
+	last := r.rule.Before(at, true)
+	if last.IsZero() {
+		return false
+	}
+	return at.Before(last.Add(r.duration))
+}
+
+// ParseRecurrence validates the schedule of a maintenance window.
+// Schedules starting with RRULE: or containing FREQ= are treated as an RFC 5545 RRULE, anything else as a
+// standard 5 field cron expression
+func ParseRecurrence(w *models.MaintenanceWindow) error {
+	_, err := newRecurrence(w)
+	return err
+}
+
+func newRecurrence(w *models.MaintenanceWindow) (recurrence, error) {
+	d := w.Duration.Duration()
+	switch {
+	case w.Schedule == "":
+		return &oneOff{start: w.Start, duration: d}, nil
+	case strings.HasPrefix(w.Schedule, "RRULE:") || strings.Contains(w.Schedule, "FREQ="):
+		opt, err := rrule.StrToROption(w.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %q: %s", w.Schedule, err)
+		}
+		if opt.Dtstart.IsZero() {
+			opt.Dtstart = w.Start
+		}
+		if opt.Dtstart.IsZero() {
+			opt.Dtstart = w.Created
+		}
+		rule, err := rrule.NewRRule(*opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %q: %s", w.Schedule, err)
+		}
+		return &ruleWindow{duration: d, rule: rule}, nil
+	default:
+		sched, err := cron.ParseStandard(w.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %s", w.Schedule, err)
+		}
+		return &cronWindow{start: w.Start, duration: d, schedule: sched}, nil
+	}
+}
+
+// Schedule keeps track of the maintenance windows for all monitored sites, so that monitors can
+// flag results and suppress alerts while a window is in effect.
+// It is safe for concurrent use
+type Schedule struct {
+	sync.RWMutex
+	windows map[int]*scheduledWindow
+}
+
+type scheduledWindow struct {
+	window   *models.MaintenanceWindow
+	selector models.Selector
+	recurrence
+}
+
+// applies checks if the window is attached to the site, either directly or through its labels
+func (sw *scheduledWindow) applies(siteID int, labels map[string]string) bool {
+	if sw.window.SiteID != 0 {
+		return sw.window.SiteID == siteID
+	}
+	return sw.selector.Matches(labels)
+}
+
+func NewSchedule() *Schedule {
+	return &Schedule{windows: make(map[int]*scheduledWindow)}
+}
+
+// Add registers a maintenance window with the schedule, replacing any window with the same ID
+func (s *Schedule) Add(w *models.MaintenanceWindow) error {
+	r, err := newRecurrence(w)
+	if err != nil {
+		return err
+	}
+	sel, err := models.ParseSelector(w.Selector)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.windows[w.ID] = &scheduledWindow{window: w, selector: sel, recurrence: r}
+	return nil
+}
+
+// Remove drops a maintenance window from the schedule
+func (s *Schedule) Remove(id int) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.windows, id)
+}
+
+// InMaintenance reports whether any maintenance window for the given site, or matching its labels,
+// is in effect at the given time
+func (s *Schedule) InMaintenance(siteID int, labels map[string]string, at time.Time) bool {
+	if s == nil {
+		return false
+	}
+	s.RLock()
+	defer s.RUnlock()
+	for _, sw := range s.windows {
+		if sw.applies(siteID, labels) && sw.active(at) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"github.com/dnataraj/healthbee/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestSchedule_InMaintenance(t *testing.T) {
+	// a Sunday, at midnight
+	start := time.Date(2021, time.January, 3, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		window *models.MaintenanceWindow
+		at     time.Time
+		want   bool
+	}{
+		{
+			name:   "One-off window in effect",
+			window: &models.MaintenanceWindow{ID: 1, SiteID: 1, Start: start, Duration: models.Period(time.Hour)},
+			at:     start.Add(30 * time.Minute),
+			want:   true,
+		},
+		{
+			name:   "One-off window ended",
+			window: &models.MaintenanceWindow{ID: 1, SiteID: 1, Start: start, Duration: models.Period(time.Hour)},
+			at:     start.Add(time.Hour),
+			want:   false,
+		},
+		{
+			name:   "Other site",
+			window: &models.MaintenanceWindow{ID: 1, SiteID: 2, Start: start, Duration: models.Period(time.Hour)},
+			at:     start.Add(30 * time.Minute),
+			want:   false,
+		},
+		{
+			name:   "Window for matching labels",
+			window: &models.MaintenanceWindow{ID: 1, Selector: "team=payments", Start: start, Duration: models.Period(time.Hour)},
+			at:     start.Add(30 * time.Minute),
+			want:   true,
+		},
+		{
+			name:   "Window for other labels",
+			window: &models.MaintenanceWindow{ID: 1, Selector: "team=search", Start: start, Duration: models.Period(time.Hour)},
+			at:     start.Add(30 * time.Minute),
+			want:   false,
+		},
+		{
+			name:   "Cron window in effect",
+			window: &models.MaintenanceWindow{ID: 1, SiteID: 1, Start: start, Duration: models.Period(30 * time.Minute), Schedule: "0 2 * * 0"},
+			at:     start.Add(7*24*time.Hour + 2*time.Hour + 10*time.Minute),
+			want:   true,
+		},
+		{
+			name:   "Cron window not in effect",
+			window: &models.MaintenanceWindow{ID: 1, SiteID: 1, Start: start, Duration: models.Period(30 * time.Minute), Schedule: "0 2 * * 0"},
+			at:     start.Add(7*24*time.Hour + 3*time.Hour),
+			want:   false,
+		},
+		{
+			name:   "Cron window before start",
+			window: &models.MaintenanceWindow{ID: 1, SiteID: 1, Start: start, Duration: models.Period(30 * time.Minute), Schedule: "0 2 * * 0"},
+			at:     start.Add(-7*24*time.Hour + 2*time.Hour + 10*time.Minute),
+			want:   false,
+		},
+		{
+			name:   "RRULE window in effect",
+			window: &models.MaintenanceWindow{ID: 1, SiteID: 1, Start: start, Duration: models.Period(time.Hour), Schedule: "RRULE:FREQ=WEEKLY;BYDAY=TU;BYHOUR=22;BYMINUTE=0;BYSECOND=0"},
+			at:     start.Add(2*24*time.Hour + 22*time.Hour + 45*time.Minute),
+			want:   true,
+		},
+		{
+			name:   "RRULE window not in effect",
+			window: &models.MaintenanceWindow{ID: 1, SiteID: 1, Start: start, Duration: models.Period(time.Hour), Schedule: "RRULE:FREQ=WEEKLY;BYDAY=TU;BYHOUR=22;BYMINUTE=0;BYSECOND=0"},
+			at:     start.Add(3*24*time.Hour + 22*time.Hour + 45*time.Minute),
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSchedule()
+			if err := s.Add(tt.window); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.InMaintenance(1, map[string]string{"team": "payments"}, tt.at); got != tt.want {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Invalid schedule", func(t *testing.T) {
+		s := NewSchedule()
+		err := s.Add(&models.MaintenanceWindow{ID: 1, SiteID: 1, Start: start, Duration: models.Period(time.Hour), Schedule: "every sunday"})
+		if err == nil {
+			t.Errorf("want error, got nil")
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// SiteMetrics exposes the latest check result of every monitored site as Prometheus metrics.
+// Site labels are added to every metric with a label_ prefix (team=payments becomes label_team="payments"),
+// so that alerts and dashboards can be routed by owning team.
+// It is safe for concurrent use
+type SiteMetrics struct {
+	sync.Mutex
+	sites map[int]*siteMetrics
+}
+
+type siteMetrics struct {
+	url          string
+	labels       map[string]string
+	up           float64
+	maintenance  float64
+	responseTime float64
+	checks       float64
+	failures     float64
+}
+
+func NewSiteMetrics() *SiteMetrics {
+	return &SiteMetrics{sites: make(map[int]*siteMetrics)}
+}
+
+// Observe records the result of a site check
+func (s *SiteMetrics) Observe(site *models.Site, labels map[string]string, res *models.CheckResult) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	sm, ok := s.sites[site.ID]
+	if !ok {
+		sm = &siteMetrics{}
+		s.sites[site.ID] = sm
+	}
+	sm.url, sm.labels = site.URL, labels
+	sm.up, sm.maintenance = 0, 0
+	switch res.Status() {
+	case models.StatusUp:
+		sm.up = 1
+	case models.StatusDown:
+		sm.failures++
+	}
+	if res.Maintenance {
+		sm.maintenance = 1
+	}
+	sm.responseTime = res.ResponseTime.Duration().Seconds()
+	sm.checks++
+}
+
+// Remove drops the metrics of a site that is no longer monitored
+func (s *SiteMetrics) Remove(siteID int) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.sites, siteID)
+}
+
+// Describe sends no descriptors, which makes SiteMetrics an unchecked collector since the label names
+// depend on the labels of the monitored sites
+func (s *SiteMetrics) Describe(chan<- *prometheus.Desc) {}
+
+// Collect sends the metrics of every monitored site. Sites without a label that another site has are given
+// an empty value for it, keeping the label names consistent across each metric
+func (s *SiteMetrics) Collect(ch chan<- prometheus.Metric) {
+	s.Lock()
+	defer s.Unlock()
+
+	// collect the label names of all sites, dropping any that clash once sanitized
+	keys := make(map[string]string)
+	for _, sm := range s.sites {
+		for k := range sm.labels {
+			keys[k] = "label_" + invalidLabelChars.ReplaceAllString(k, "_")
+		}
+	}
+	names := []string{"site_id", "url"}
+	seen := make(map[string]bool)
+	var labelKeys []string
+	for _, k := range models.LabelKeys(keys) {
+		if seen[keys[k]] {
+			continue
+		}
+		seen[keys[k]] = true
+		names = append(names, keys[k])
+		labelKeys = append(labelKeys, k)
+	}
+
+	up := prometheus.NewDesc("healthbee_site_up", "Whether the site was up at the last check.", names, nil)
+	maintenance := prometheus.NewDesc("healthbee_site_maintenance", "Whether the site was under maintenance at the last check.", names, nil)
+	responseTime := prometheus.NewDesc("healthbee_site_response_time_seconds", "Response time of the site at the last check.", names, nil)
+	checks := prometheus.NewDesc("healthbee_site_checks_total", "Number of checks performed for the site.", names, nil)
+	failures := prometheus.NewDesc("healthbee_site_failures_total", "Number of checks for which the site was down.", names, nil)
+
+	for id, sm := range s.sites {
+		values := []string{strconv.Itoa(id), sm.url}
+		for _, k := range labelKeys {
+			values = append(values, sm.labels[k])
+		}
+		ch <- prometheus.MustNewConstMetric(up, prometheus.GaugeValue, sm.up, values...)
+		ch <- prometheus.MustNewConstMetric(maintenance, prometheus.GaugeValue, sm.maintenance, values...)
+		ch <- prometheus.MustNewConstMetric(responseTime, prometheus.GaugeValue, sm.responseTime, values...)
+		ch <- prometheus.MustNewConstMetric(checks, prometheus.CounterValue, sm.checks, values...)
+		ch <- prometheus.MustNewConstMetric(failures, prometheus.CounterValue, sm.failures, values...)
+	}
+}
@@ -0,0 +1,26 @@
+package pkg
+
+import (
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+func TestSiteMetrics_Collect(t *testing.T) {
+	m := NewSiteMetrics()
+	payments := &models.Site{ID: 1, URL: "https://pay.example.com"}
+	search := &models.Site{ID: 2, URL: "https://search.example.com"}
+	m.Observe(payments, map[string]string{"team": "payments", "k8s.io/env": "prod"}, &models.CheckResult{ResponseCode: 200, MatchedPattern: true})
+	m.Observe(search, nil, &models.CheckResult{ResponseCode: 503})
+
+	want := `
+# HELP healthbee_site_up Whether the site was up at the last check.
+# TYPE healthbee_site_up gauge
+healthbee_site_up{label_k8s_io_env="prod",label_team="payments",site_id="1",url="https://pay.example.com"} 1
+healthbee_site_up{label_k8s_io_env="",label_team="",site_id="2",url="https://search.example.com"} 0
+`
+	if err := testutil.CollectAndCompare(m, strings.NewReader(want), "healthbee_site_up"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidFilter = errors.New("results: invalid result filter")
+
+// Kinds of failed checks that results can be filtered by. Failures while a parent site was down are unreachable,
+// whatever their cause, like CheckResult.Status reports them
+const (
+	// FailureAny selects every failed check
+	FailureAny = "any"
+	// FailureError selects checks where the site could not be fetched, such as on a timeout
+	FailureError = "error"
+	// FailureStatus selects checks where the site responded with an error status code
+	FailureStatus = "status"
+	// FailureContent selects checks where the site responded, but its content did not match the site pattern
+	FailureContent = "content"
+	// FailureUnreachable selects checks that failed while a parent site was down
+	FailureUnreachable = "unreachable"
+)
+
+// ResultFilter selects the results of a site checked between From and To, where zero times leave the range open.
+// Results can be filtered further by status code, either exact codes or classes such as 5 for any 5xx code, by
+// whether the pattern matched, by the kind of failure and by a minimum response time. Results are listed latest
+// first, unless Ascending is set
+type ResultFilter struct {
+	From            time.Time
+	To              time.Time
+	Codes           []int
+	Classes         []int
+	Matched         *bool
+	Failure         string
+	MinResponseTime Period
+	Ascending       bool
+}
+
+// ParseResultFilter parses a result filter from query parameters, all of which are optional. For example
+// ?from=2021-03-02T02:30:00Z&to=2021-03-02T03:30:00Z&status=500,5xx&matched=false&failure=status&min_response_time=2s&sort=asc
+func ParseResultFilter(q url.Values) (ResultFilter, error) {
+	f := ResultFilter{}
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, ErrInvalidFilter
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, ErrInvalidFilter
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, ErrInvalidFilter
+	}
+	if v := q.Get("status"); v != "" {
+		for _, code := range strings.Split(v, ",") {
+			code = strings.ToLower(strings.TrimSpace(code))
+			if len(code) == 3 && strings.HasSuffix(code, "xx") && code[0] >= '1' && code[0] <= '5' {
+				f.Classes = append(f.Classes, int(code[0]-'0'))
+				continue
+			}
+			c, err := strconv.Atoi(code)
+			if err != nil || c < 100 || c > 599 {
+				return f, ErrInvalidFilter
+			}
+			f.Codes = append(f.Codes, c)
+		}
+	}
+	if v := q.Get("matched"); v != "" {
+		matched, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, ErrInvalidFilter
+		}
+		f.Matched = &matched
+	}
+	switch f.Failure = q.Get("failure"); f.Failure {
+	case "", FailureAny, FailureError, FailureStatus, FailureContent, FailureUnreachable:
+	default:
+		return f, ErrInvalidFilter
+	}
+	if v := q.Get("min_response_time"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return f, ErrInvalidFilter
+		}
+		f.MinResponseTime = Period(d)
+	}
+	switch q.Get("sort") {
+	case "", "desc":
+	case "asc":
+		f.Ascending = true
+	default:
+		return f, ErrInvalidFilter
+	}
+	return f, nil
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseResultFilter(t *testing.T) {
+	matched := false
+	tests := []struct {
+		name       string
+		query      string
+		wantFilter ResultFilter
+		wantError  error
+	}{
+		{name: "Empty", wantFilter: ResultFilter{}},
+		{
+			name:  "Time range",
+			query: "from=2021-03-02T02:30:00Z&to=2021-03-02T03:30:00Z",
+			wantFilter: ResultFilter{
+				From: time.Date(2021, 3, 2, 2, 30, 0, 0, time.UTC),
+				To:   time.Date(2021, 3, 2, 3, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "All filters",
+			query: "status=503,5XX,404&matched=false&failure=status&min_response_time=1.5s&sort=asc",
+			wantFilter: ResultFilter{
+				Codes:           []int{503, 404},
+				Classes:         []int{5},
+				Matched:         &matched,
+				Failure:         FailureStatus,
+				MinResponseTime: Period(1500 * time.Millisecond),
+				Ascending:       true,
+			},
+		},
+		{name: "Empty range", query: "from=2021-03-02T03:30:00Z&to=2021-03-02T03:30:00Z", wantError: ErrInvalidFilter},
+		{name: "Invalid time", query: "from=yesterday", wantError: ErrInvalidFilter},
+		{name: "Invalid status", query: "status=6xx", wantError: ErrInvalidFilter},
+		{name: "Invalid matched", query: "matched=maybe", wantError: ErrInvalidFilter},
+		{name: "Invalid failure", query: "failure=dns", wantError: ErrInvalidFilter},
+		{name: "Negative response time", query: "min_response_time=-1s", wantError: ErrInvalidFilter},
+		{name: "Invalid sort", query: "sort=random", wantError: ErrInvalidFilter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter, err := ParseResultFilter(q)
+			if err != tt.wantError {
+				t.Fatalf("want %v, got %v", tt.wantError, err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(filter, tt.wantFilter) {
+				t.Errorf("want %+v, got %+v", tt.wantFilter, filter)
+			}
+		})
+	}
+}
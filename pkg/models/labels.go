@@ -0,0 +1,130 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidLabel = errors.New("sites: invalid site label")
+var ErrInvalidSelector = errors.New("sites: invalid label selector")
+
+// Label selector operators
+const (
+	OpEquals    = "="
+	OpNotEquals = "!="
+	OpExists    = "exists"
+	OpNotExists = "!exists"
+)
+
+var labelKey = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_./-]{0,61}[A-Za-z0-9])?$`)
+var labelValue = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9_.-]{0,61}[A-Za-z0-9])?)?$`)
+
+// ValidateLabels checks that site labels have well-formed keys and values, so that they can be used in selectors
+// and propagated downstream (for example as Prometheus labels)
+func ValidateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if !labelKey.MatchString(k) || !labelValue.MatchString(v) {
+			return ErrInvalidLabel
+		}
+	}
+	return nil
+}
+
+// Requirement is a single condition of a label selector
+type Requirement struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+// Selector selects sites by their labels, every requirement has to hold for a site to be selected.
+// An empty selector selects all sites
+type Selector []Requirement
+
+// ParseSelector parses a comma separated list of label requirements such as "team=payments,env!=staging".
+// Besides equality (= or ==) and inequality (!=), "key" and "!key" select sites with or without a label
+func ParseSelector(s string) (Selector, error) {
+	sel := Selector{}
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		var r Requirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			r = Requirement{Key: parts[0], Operator: OpNotEquals, Value: parts[1]}
+		case strings.Contains(term, "=="):
+			parts := strings.SplitN(term, "==", 2)
+			r = Requirement{Key: parts[0], Operator: OpEquals, Value: parts[1]}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			r = Requirement{Key: parts[0], Operator: OpEquals, Value: parts[1]}
+		case strings.HasPrefix(term, "!"):
+			r = Requirement{Key: term[1:], Operator: OpNotExists}
+		default:
+			r = Requirement{Key: term, Operator: OpExists}
+		}
+		r.Key, r.Value = strings.TrimSpace(r.Key), strings.TrimSpace(r.Value)
+		if !labelKey.MatchString(r.Key) || !labelValue.MatchString(r.Value) {
+			return nil, ErrInvalidSelector
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches reports whether the given labels satisfy every requirement of the selector
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		v, ok := labels[r.Key]
+		switch r.Operator {
+		case OpEquals:
+			if !ok || v != r.Value {
+				return false
+			}
+		case OpNotEquals:
+			if ok && v == r.Value {
+				return false
+			}
+		case OpExists:
+			if !ok {
+				return false
+			}
+		case OpNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String formats the selector so that it can be parsed again
+func (s Selector) String() string {
+	terms := make([]string, 0, len(s))
+	for _, r := range s {
+		switch r.Operator {
+		case OpExists:
+			terms = append(terms, r.Key)
+		case OpNotExists:
+			terms = append(terms, "!"+r.Key)
+		default:
+			terms = append(terms, r.Key+r.Operator+r.Value)
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// LabelKeys returns the sorted keys of a set of labels
+func LabelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,39 @@
+package models
+
+import "testing"
+
+func TestSelector_Matches(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "prod"}
+
+	tests := []struct {
+		name      string
+		selector  string
+		want      bool
+		wantError error
+	}{
+		{name: "Empty selector", selector: "", want: true},
+		{name: "Equality", selector: "team=payments", want: true},
+		{name: "Double equality", selector: "team==payments", want: true},
+		{name: "Inequality", selector: "team=payments,env!=staging", want: true},
+		{name: "Failed inequality", selector: "env!=prod", want: false},
+		{name: "Missing label", selector: "region=eu", want: false},
+		{name: "Existence", selector: "env", want: true},
+		{name: "Absence", selector: "!region", want: true},
+		{name: "Invalid key", selector: "team name=payments", wantError: ErrInvalidSelector},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := ParseSelector(tt.selector)
+			if err != tt.wantError {
+				t.Fatalf("want %v, got %v", tt.wantError, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := sel.Matches(labels); got != tt.want {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+// Package migrate applies versioned migrations to the schema of a store, recording the versions applied in the
+// schema_migrations table. The stores provide the migrations and the statements specific to their database
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// ErrNoMigration is returned when reverting a database that has no migrations applied
+var ErrNoMigration = errors.New("migrations: no migration to revert")
+
+// Migration is a versioned change to the schema, along with the statements reverting it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether and when a migration was applied
+type MigrationStatus struct {
+	Version int        `json:"version"`
+	Name    string     `json:"name"`
+	Applied *time.Time `json:"applied,omitempty"`
+}
+
+// Load reads the migrations from the SQL files at the root of files, named after their version and name such as
+// 0001_initial.up.sql and 0001_initial.down.sql, and returns them in order of their versions
+func Load(files fs.FS) ([]*Migration, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, f := range names {
+		m := migrationName.FindStringSubmatch(f)
+		if m == nil {
+			return nil, fmt.Errorf("migrations: invalid migration file name %s", f)
+		}
+		version, _ := strconv.Atoi(m[1])
+		script, err := fs.ReadFile(files, f)
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migrations: conflicting names for version %d, %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(script)
+		} else {
+			mig.Down = string(script)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migrations: version %d needs both an up and a down migration", mig.Version)
+		}
+		migrations = append(migrations, mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, mig := range migrations {
+		if mig.Version != i+1 {
+			return nil, fmt.Errorf("migrations: missing version %d", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Dialect holds the statements a Migrator runs that are specific to a database. Statements take $1 style
+// placeholders
+type Dialect struct {
+	// Table creates the schema_migrations table, if it does not exist yet
+	Table string
+	// Lock and Unlock are run on the connection before and after migrating, if set, for example to keep other
+	// instances from migrating at the same time
+	Lock, Unlock string
+	// Check is run before a migration is committed, if set, and fails the migration if it returns any rows
+	Check string
+	// Adopted returns the version that a database set up before migrations were introduced is at, if set. It is
+	// only run while no versions are recorded, and the versions up to it are then recorded as applied
+	Adopted string
+}
+
+// Migrator applies migrations to a database, each in a transaction, recording the versions applied in the
+// schema_migrations table
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []*Migration
+	Dialect    Dialect
+}
+
+// Up applies the migrations that have not been applied yet, and returns them
+func (m *Migrator) Up() ([]*Migration, error) {
+	applied := make([]*Migration, 0)
+	err := m.locked(func(conn *sql.Conn) error {
+		versions, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.Migrations {
+			if _, ok := versions[mig.Version]; ok {
+				continue
+			}
+			err := m.migrate(conn, mig.Up,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+				mig.Version, mig.Name, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("migrations: applying version %d (%s) failed with: %s", mig.Version, mig.Name, err)
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the latest migration applied, and returns it
+func (m *Migrator) Down() (*Migration, error) {
+	var reverted *Migration
+	err := m.locked(func(conn *sql.Conn) error {
+		versions, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			mig := m.Migrations[i]
+			if _, ok := versions[mig.Version]; !ok {
+				continue
+			}
+			err := m.migrate(conn, mig.Down, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+			if err != nil {
+				return fmt.Errorf("migrations: reverting version %d (%s) failed with: %s", mig.Version, mig.Name, err)
+			}
+			reverted = mig
+			return nil
+		}
+		return ErrNoMigration
+	})
+	return reverted, err
+}
+
+// Status lists the migrations, along with when they were applied
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	statuses := make([]*MigrationStatus, 0, len(m.Migrations))
+	err := m.locked(func(conn *sql.Conn) error {
+		versions, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.Migrations {
+			s := &MigrationStatus{Version: mig.Version, Name: mig.Name}
+			if at, ok := versions[mig.Version]; ok {
+				at := at
+				s.Applied = &at
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// locked runs fn on a single connection between the Lock and Unlock statements of the dialect, creating the
+// schema_migrations table if needed
+func (m *Migrator) locked(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.Dialect.Lock != "" {
+		if _, err := conn.ExecContext(ctx, m.Dialect.Lock); err != nil {
+			return err
+		}
+	}
+	if m.Dialect.Unlock != "" {
+		defer conn.ExecContext(ctx, m.Dialect.Unlock)
+	}
+	if _, err := conn.ExecContext(ctx, m.Dialect.Table); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// appliedVersions returns the versions applied to the database, along with when they were applied. Databases set
+// up before migrations were introduced are adopted first, if the dialect can tell their version
+func (m *Migrator) appliedVersions(conn *sql.Conn) (map[int]time.Time, error) {
+	ctx := context.Background()
+	versions := make(map[int]time.Time)
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		versions[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(versions) > 0 || m.Dialect.Adopted == "" {
+		return versions, nil
+	}
+
+	var adopted int
+	if err := conn.QueryRowContext(ctx, m.Dialect.Adopted).Scan(&adopted); err != nil {
+		return nil, err
+	}
+	at := time.Now().UTC()
+	for _, mig := range m.Migrations {
+		if mig.Version > adopted {
+			break
+		}
+		stmt := `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`
+		if _, err := conn.ExecContext(ctx, stmt, mig.Version, mig.Name, at); err != nil {
+			return nil, err
+		}
+		versions[mig.Version] = at
+	}
+	return versions, nil
+}
+
+// migrate runs the script of a migration and records it, within a transaction
+func (m *Migrator) migrate(conn *sql.Conn, script, record string, args ...interface{}) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if m.Dialect.Check != "" {
+		rows, err := tx.QueryContext(ctx, m.Dialect.Check)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		failed := rows.Next()
+		rows.Close()
+		if failed {
+			tx.Rollback()
+			return errors.New("the migration fails the checks of the database")
+		}
+	}
+	return tx.Commit()
+}
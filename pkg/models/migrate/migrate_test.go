@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	file := func(script string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(script)}
+	}
+	tests := []struct {
+		name      string
+		files     fstest.MapFS
+		wantError bool
+	}{
+		{
+			name: "Migrations",
+			files: fstest.MapFS{
+				"0002_labels.up.sql": file("ALTER TABLE sites ADD COLUMN labels TEXT"), "0002_labels.down.sql": file("SELECT 1"),
+				"0001_initial.up.sql": file("CREATE TABLE sites (id INT)"), "0001_initial.down.sql": file("DROP TABLE sites"),
+			},
+		},
+		{name: "Invalid name", files: fstest.MapFS{"initial.up.sql": file("SELECT 1")}, wantError: true},
+		{name: "Missing down", files: fstest.MapFS{"0001_initial.up.sql": file("SELECT 1")}, wantError: true},
+		{
+			name:      "Missing version",
+			files:     fstest.MapFS{"0002_labels.up.sql": file("SELECT 1"), "0002_labels.down.sql": file("SELECT 1")},
+			wantError: true,
+		},
+		{
+			name:      "Conflicting names",
+			files:     fstest.MapFS{"0001_initial.up.sql": file("SELECT 1"), "0001_sites.down.sql": file("SELECT 1")},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := Load(tt.files)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("want an error, got %d migrations", len(migrations))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(migrations) != 2 || migrations[0].Name != "initial" || migrations[1].Version != 2 || migrations[1].Down == "" {
+				t.Errorf("want the initial and labels migrations in order, got %+v and %+v", migrations[0], migrations[1])
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package models
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"regexp"
 	"time"
 )
 
@@ -45,15 +47,88 @@ func (p *Period) UnmarshalJSON(b []byte) error {
 	}
 }
 
+// UnmarshalYAML parses a period written as a duration string in YAML, such as 30s
+func (p *Period) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*p = Period(d)
+	return nil
+}
+
 var ErrDuplicateSite = errors.New("sites: duplicate site registration")
+var ErrInvalidSite = errors.New("sites: invalid site registration")
 var ErrNoRecord = errors.New("sites: no record found")
+var ErrInvalidWindow = errors.New("maintenance: invalid maintenance window")
+var ErrDependencyCycle = errors.New("sites: site dependencies form a cycle")
+var ErrInvalidDependency = errors.New("sites: site depends on an unknown site")
+var ErrInvalidGroup = errors.New("groups: invalid group definition")
+var ErrDuplicateGroup = errors.New("groups: duplicate group name")
+var ErrInvalidMember = errors.New("groups: group member is an unknown site")
+var ErrInvalidResult = errors.New("results: result of an unknown site")
+
+// Site types, sites are either checked over HTTP, or expected to ping HealthBee (heartbeats)
+const (
+	SiteHTTP      = "http"
+	SiteHeartbeat = "heartbeat"
+)
+
+// Site availability states, as derived from a check result
+const (
+	StatusUp          = "up"
+	StatusDown        = "down"
+	StatusUnreachable = "unreachable"
+)
 
+// Site is a monitored site. Heartbeat sites are not checked, instead they are expected to ping HealthBee at the
+// URL with their token every interval, and are considered down once the grace time after that has passed as well
 type Site struct {
-	ID       int       `json:"id,omitempty"`
-	URL      string    `json:"url"`
-	Interval Period    `json:"interval"`
-	Pattern  string    `json:"pattern"`
-	Created  time.Time `json:"created"`
+	ID       int               `json:"id,omitempty"`
+	Type     string            `json:"type,omitempty"`
+	URL      string            `json:"url"`
+	Interval Period            `json:"interval"`
+	Grace    Period            `json:"grace,omitempty"`
+	Token    string            `json:"token,omitempty"`
+	Pattern  string            `json:"pattern"`
+	Parents  []int             `json:"parents,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// Retention overrides how long the raw results of the site are kept for, if set
+	Retention Period `json:"retention,omitempty"`
+	// Paused sites are not monitored until they are resumed
+	Paused  bool      `json:"paused,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// OK validates a site registration request, including its pattern
+func (s *Site) OK() error {
+	switch s.Type {
+	case "", SiteHTTP:
+		if s.URL == "" {
+			return ErrInvalidSite
+		}
+	case SiteHeartbeat:
+		if s.Grace < 0 {
+			return ErrInvalidSite
+		}
+	default:
+		return ErrInvalidSite
+	}
+	if s.Interval <= 0 {
+		return ErrInvalidSite
+	}
+	// patterns are compiled by the monitors, so they are checked up front
+	if _, err := regexp.Compile(s.Pattern); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSite, err.Error())
+	}
+	if err := ValidateRetention(s.Retention); err != nil {
+		return err
+	}
+	return ValidateLabels(s.Labels)
 }
 
 //TODO: in retrospect this is a not a good name for the struct, it should be HealthCheckResult or just Result
@@ -64,4 +139,125 @@ type CheckResult struct {
 	ResponseTime   Period    `json:"response_time"`
 	ResponseCode   int       `json:"response_code"`
 	MatchedPattern bool      `json:"matched"`
+	Maintenance    bool      `json:"maintenance"`
+	Unreachable    bool      `json:"unreachable"`
+	// Source identifies the HealthBee instance that performed the check
+	Source string `json:"source,omitempty"`
+	// Labels of the site at the time of the check, these are not stored along with the result
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Status reports whether the site was up or down when this result was recorded.
+// A site is considered up if it responded with a non-error status code and the content matched the site pattern.
+// Failures caused by a site dependency being down are reported as unreachable instead
+func (r *CheckResult) Status() string {
+	if r.Unreachable {
+		return StatusUnreachable
+	}
+	if r.ResponseCode >= 200 && r.ResponseCode < 400 && r.MatchedPattern {
+		return StatusUp
+	}
+	return StatusDown
+}
+
+// Composite health rules for site groups
+const (
+	RuleAll    = "all"
+	RuleAny    = "any"
+	RuleQuorum = "quorum"
+)
+
+// Group is a set of sites that together provide a service, for example the URLs of a checkout service.
+// The health of the group is derived from the latest result of each member according to its rule,
+// which requires all, any or at least a quorum of its members to be up
+type Group struct {
+	ID      int       `json:"id,omitempty"`
+	Name    string    `json:"name"`
+	Rule    string    `json:"rule"`
+	Quorum  int       `json:"quorum,omitempty"`
+	Sites   []int     `json:"sites"`
+	Created time.Time `json:"created"`
+}
+
+// OK validates a group definition
+func (g *Group) OK() error {
+	if g.Name == "" || len(g.Sites) == 0 {
+		return ErrInvalidGroup
+	}
+	switch g.Rule {
+	case RuleAll, RuleAny:
+		return nil
+	case RuleQuorum:
+		if g.Quorum < 1 || g.Quorum > len(g.Sites) {
+			return ErrInvalidGroup
+		}
+		return nil
+	default:
+		return ErrInvalidGroup
+	}
+}
+
+// Evaluate computes the status of the group from the latest result of each of its members.
+// Members under maintenance count as up, while members without any results count as down
+func (g *Group) Evaluate(latest []*CheckResult, at time.Time) *GroupStatus {
+	gs := &GroupStatus{GroupID: g.ID, At: at, Total: len(g.Sites), Members: latest}
+	for _, res := range latest {
+		if res.Maintenance || res.Status() == StatusUp {
+			gs.Up++
+		}
+	}
+	required := gs.Total
+	switch g.Rule {
+	case RuleAny:
+		required = 1
+	case RuleQuorum:
+		required = g.Quorum
+	}
+	gs.Status = StatusDown
+	if gs.Up >= required {
+		gs.Status = StatusUp
+	}
+	return gs
+}
+
+// GroupStatus represents the composite health of a group at a point in time
+type GroupStatus struct {
+	ID      int            `json:"id,omitempty"`
+	GroupID int            `json:"group_id"`
+	At      time.Time      `json:"at"`
+	Status  string         `json:"status"`
+	Up      int            `json:"up"`
+	Total   int            `json:"total"`
+	Members []*CheckResult `json:"members,omitempty"`
+}
+
+// MaintenanceWindow represents a planned period during which a site, or every site matching a label selector,
+// is expected to be unavailable. Checks continue to run during a window, but their results are flagged and alerts
+// are suppressed.
+// Without a schedule, the window is a one-off starting at Start. Otherwise the schedule is either
+// a cron expression or an RFC 5545 RRULE that determines when each occurrence begins, with Start
+// marking the earliest occurrence
+type MaintenanceWindow struct {
+	ID       int       `json:"id,omitempty"`
+	SiteID   int       `json:"site_id,omitempty"`
+	Selector string    `json:"selector,omitempty"`
+	Start    time.Time `json:"start"`
+	Duration Period    `json:"duration"`
+	Schedule string    `json:"schedule,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// OK validates a maintenance window request
+func (w *MaintenanceWindow) OK() error {
+	if (w.SiteID < 1 && w.Selector == "") || w.Duration <= 0 {
+		return ErrInvalidWindow
+	}
+	if _, err := ParseSelector(w.Selector); err != nil {
+		return err
+	}
+	if w.Schedule == "" && w.Start.IsZero() {
+		return ErrInvalidWindow
+	}
+	return nil
 }
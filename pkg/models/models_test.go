@@ -0,0 +1,178 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroup_Evaluate(t *testing.T) {
+	up := &CheckResult{SiteID: 1, ResponseCode: 200, MatchedPattern: true}
+	down := &CheckResult{SiteID: 2, ResponseCode: 503}
+	maintenance := &CheckResult{SiteID: 3, ResponseCode: 503, Maintenance: true}
+
+	tests := []struct {
+		name   string
+		group  *Group
+		latest []*CheckResult
+		wantUp int
+		want   string
+	}{
+		{
+			name:   "All members up",
+			group:  &Group{Rule: RuleAll, Sites: []int{1, 3}},
+			latest: []*CheckResult{up, maintenance},
+			wantUp: 2,
+			want:   StatusUp,
+		},
+		{
+			name:   "All with a member down",
+			group:  &Group{Rule: RuleAll, Sites: []int{1, 2}},
+			latest: []*CheckResult{up, down},
+			wantUp: 1,
+			want:   StatusDown,
+		},
+		{
+			name:   "Any with a member up",
+			group:  &Group{Rule: RuleAny, Sites: []int{1, 2}},
+			latest: []*CheckResult{up, down},
+			wantUp: 1,
+			want:   StatusUp,
+		},
+		{
+			name:   "Quorum not met",
+			group:  &Group{Rule: RuleQuorum, Quorum: 2, Sites: []int{1, 2, 4}},
+			latest: []*CheckResult{up, down},
+			wantUp: 1,
+			want:   StatusDown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := tt.group.Evaluate(tt.latest, time.Now())
+			if gs.Status != tt.want || gs.Up != tt.wantUp || gs.Total != len(tt.group.Sites) {
+				t.Errorf("want %s with %d of %d up, got %+v", tt.want, tt.wantUp, len(tt.group.Sites), gs)
+			}
+		})
+	}
+}
+
+func TestValidateRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention Period
+		want      error
+	}{
+		{name: "Forever", retention: 0, want: nil},
+		{name: "Minimum", retention: Period(MinRetention), want: nil},
+		{name: "Month", retention: Period(30 * 24 * time.Hour), want: nil},
+		{name: "Too short", retention: Period(time.Hour), want: ErrInvalidRetention},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateRetention(tt.retention); err != tt.want {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestParseStatsWindow(t *testing.T) {
+	now := time.Date(2021, 3, 2, 3, 20, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		window    string
+		step      string
+		wantFrom  time.Time
+		wantSteps int
+		wantError error
+	}{
+		{name: "Default", wantFrom: time.Date(2021, 3, 1, 4, 0, 0, 0, time.UTC), wantSteps: 24},
+		{name: "Week by the day", window: "168h", step: "24h", wantFrom: time.Date(2021, 2, 24, 0, 0, 0, 0, time.UTC), wantSteps: 7},
+		{name: "Single step", window: "15m", step: "15m", wantFrom: time.Date(2021, 3, 2, 3, 15, 0, 0, time.UTC), wantSteps: 1},
+		{name: "Not a multiple", window: "24h", step: "7h", wantError: ErrInvalidStatsWindow},
+		{name: "Step too short", window: "1h", step: "30s", wantError: ErrInvalidStatsWindow},
+		{name: "Step longer than window", window: "1h", step: "2h", wantError: ErrInvalidStatsWindow},
+		{name: "Too many steps", window: "720h", step: "1m", wantError: ErrInvalidStatsWindow},
+		{name: "Invalid window", window: "a week", wantError: ErrInvalidStatsWindow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseStatsWindow(tt.window, tt.step, now)
+			if err != tt.wantError {
+				t.Fatalf("want %v, got %v", tt.wantError, err)
+			}
+			if err != nil {
+				return
+			}
+			if !w.From.Equal(tt.wantFrom) || w.Buckets() != tt.wantSteps || !w.To.After(now) {
+				t.Errorf("want %d steps from %v, got %d steps from %v to %v", tt.wantSteps, tt.wantFrom, w.Buckets(), w.From, w.To)
+			}
+		})
+	}
+}
+
+func TestStatsWindow_Split(t *testing.T) {
+	now := time.Date(2021, 3, 5, 3, 20, 0, 0, time.UTC)
+	w, err := ParseStatsWindow("96h", "1h", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		retention   time.Duration
+		wantExpired int
+	}{
+		{name: "Forever", retention: 0, wantExpired: 0},
+		{name: "Longer", retention: 7 * 24 * time.Hour, wantExpired: 0},
+		// the step in progress at the cutoff is summarised from the aggregates
+		{name: "Shorter", retention: 72 * time.Hour, wantExpired: 24},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired, retained := w.Split(tt.retention, now)
+			if expired.Buckets() != tt.wantExpired || expired.Buckets()+retained.Buckets() != w.Buckets() {
+				t.Errorf("want %d expired steps out of %d, got %d and %d", tt.wantExpired, w.Buckets(), expired.Buckets(), retained.Buckets())
+			}
+			if !expired.From.Equal(w.From) || !expired.To.Equal(retained.From) || !retained.To.Equal(w.To) {
+				t.Errorf("want contiguous parts of %v to %v, got %+v and %+v", w.From, w.To, expired, retained)
+			}
+		})
+	}
+}
+
+func TestStatsWindow_Summarise(t *testing.T) {
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	w := StatsWindow{From: from, To: from.Add(4 * time.Hour), Step: 2 * time.Hour}
+	rollups := []*Rollup{
+		{Bucket: from.Add(-time.Hour), Checks: 60},
+		{Bucket: from, Checks: 60, Failures: 6, Excluded: 1},
+		{Bucket: from.Add(time.Hour), Checks: 60, Failures: 0},
+		{Bucket: from.Add(4 * time.Hour), Checks: 60},
+	}
+
+	buckets := w.Summarise(rollups, ResolutionHour)
+	if len(buckets) != 2 {
+		t.Fatalf("want 2 steps, got %d", len(buckets))
+	}
+	first, second := buckets[0], buckets[1]
+	if first.Checks != 120 || first.Failures != 6 || first.Excluded != 1 || first.Uptime == nil || *first.Uptime != 95 {
+		t.Errorf("want 120 checks with an uptime of 95%%, got %+v", first)
+	}
+	if second.Checks != 0 || second.Uptime != nil || !second.Bucket.Equal(from.Add(2*time.Hour)) {
+		t.Errorf("want an empty second step, got %+v", second)
+	}
+	if first.Resolution != ResolutionHour || second.Resolution != ResolutionHour {
+		t.Errorf("want steps summarised by the hour, got %q and %q", first.Resolution, second.Resolution)
+	}
+
+	// combining stats leaves out the percentiles, which cannot be combined
+	total := &Stats{Checks: 10, Failures: 10, P50ResponseTime: Period(time.Second)}
+	total.Add(first)
+	if total.Checks != 130 || total.Failures != 16 || total.P50ResponseTime != 0 || total.Uptime == nil {
+		t.Errorf("want 130 checks without percentiles, got %+v", total)
+	}
+}
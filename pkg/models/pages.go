@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidPage = errors.New("pages: invalid page limit or cursor")
+
+// Page limits, pages hold DefaultPageLimit records unless a limit of up to MaxPageLimit is asked for
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 500
+)
+
+// Cursor marks the last record of a page, by the time it was created or checked and its ID. The next page starts
+// with the records that come after it, in the order they are listed
+type Cursor struct {
+	At time.Time
+	ID int
+}
+
+// String encodes the cursor as an opaque token that can be passed in a URL
+func (c *Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.At.UTC().Format(time.RFC3339Nano) + "|" + strconv.Itoa(c.ID)))
+}
+
+// ParseCursor decodes a cursor token
+func ParseCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidPage
+	}
+	parts := strings.SplitN(string(data), "|", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidPage
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, ErrInvalidPage
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, ErrInvalidPage
+	}
+	return &Cursor{At: at, ID: id}, nil
+}
+
+// Page asks for up to Limit records after the cursor, or the first records if there is none
+type Page struct {
+	Limit int
+	After *Cursor
+}
+
+// ParsePage parses the limit and cursor query parameters of a page, where both are optional
+func ParsePage(limit, cursor string) (Page, error) {
+	page := Page{Limit: DefaultPageLimit}
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > MaxPageLimit {
+			return page, ErrInvalidPage
+		}
+		page.Limit = l
+	}
+	if cursor != "" {
+		c, err := ParseCursor(cursor)
+		if err != nil {
+			return page, err
+		}
+		page.After = c
+	}
+	return page, nil
+}
+
+// Size returns the number of records asked for, DefaultPageLimit if not set
+func (p Page) Size() int {
+	if p.Limit < 1 {
+		return DefaultPageLimit
+	}
+	return p.Limit
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePage(t *testing.T) {
+	cursor := &Cursor{At: time.Date(2021, 3, 1, 12, 0, 0, 123456000, time.UTC), ID: 42}
+	tests := []struct {
+		name      string
+		limit     string
+		cursor    string
+		wantLimit int
+		wantAfter *Cursor
+		wantError error
+	}{
+		{name: "Default", wantLimit: DefaultPageLimit},
+		{name: "Limit and cursor", limit: "5", cursor: cursor.String(), wantLimit: 5, wantAfter: cursor},
+		{name: "Limit too large", limit: "501", wantError: ErrInvalidPage},
+		{name: "Invalid limit", limit: "0", wantError: ErrInvalidPage},
+		{name: "Invalid cursor", cursor: "bm90IGEgY3Vyc29y", wantError: ErrInvalidPage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := ParsePage(tt.limit, tt.cursor)
+			if err != tt.wantError {
+				t.Fatalf("want %v, got %v", tt.wantError, err)
+			}
+			if err != nil {
+				return
+			}
+			if page.Limit != tt.wantLimit {
+				t.Errorf("want limit %d, got %d", tt.wantLimit, page.Limit)
+			}
+			if (page.After == nil) != (tt.wantAfter == nil) ||
+				page.After != nil && (!page.After.At.Equal(tt.wantAfter.At) || page.After.ID != tt.wantAfter.ID) {
+				t.Errorf("want cursor %+v, got %+v", tt.wantAfter, page.After)
+			}
+		})
+	}
+}
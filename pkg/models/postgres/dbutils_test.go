@@ -13,7 +13,8 @@ func newTestDB(t *testing.T) (*sql.DB, func()) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	script, err := ioutil.ReadFile("./testdata/setup.sql")
+	// start from an empty database, set up by the migrations
+	script, err := ioutil.ReadFile("./testdata/teardown.sql")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -21,6 +22,13 @@ func newTestDB(t *testing.T) (*sql.DB, func()) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
 	script, err = ioutil.ReadFile("./testdata/testdata.sql")
 	if err != nil {
 		t.Fatal(err)
@@ -3,3 +3,4 @@ package postgres
 import "github.com/lib/pq"
 
 const uniquenessViolation = pq.ErrorCode("23505")
+const foreignKeyViolation = pq.ErrorCode("23503")
@@ -0,0 +1,171 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/lib/pq"
+	"time"
+)
+
+type GroupModel struct {
+	DB *sql.DB
+}
+
+// membersColumn selects the IDs of the member sites of a group, as an array
+const membersColumn = `ARRAY(SELECT site_id FROM group_members m WHERE m.group_id = groups.id ORDER BY site_id)`
+
+// Insert adds a site group along with its members
+func (g *GroupModel) Insert(name, rule string, quorum int, sites []int) (int, error) {
+	tx, err := g.DB.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	stmt := `INSERT INTO groups (name, rule, quorum, created) VALUES ($1, $2, $3, $4) RETURNING id`
+	err = tx.QueryRow(stmt, name, rule, quorum, time.Now()).Scan(&id)
+	if err != nil {
+		return -1, groupError(err)
+	}
+	if err := setMembers(tx, id, sites); err != nil {
+		return -1, err
+	}
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// Update replaces the definition of a site group, including its members
+func (g *GroupModel) Update(id int, name, rule string, quorum int, sites []int) error {
+	tx, err := g.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE groups SET name = $2, rule = $3, quorum = $4 WHERE id = $1`, id, name, rule, quorum)
+	if err != nil {
+		return groupError(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNoRecord
+	}
+	if err := setMembers(tx, id, sites); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setMembers(tx *sql.Tx, groupID int, sites []int) error {
+	if _, err := tx.Exec(`DELETE FROM group_members WHERE group_id = $1`, groupID); err != nil {
+		return err
+	}
+	for _, s := range sites {
+		_, err := tx.Exec(`INSERT INTO group_members (group_id, site_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, groupID, s)
+		if err != nil {
+			return groupError(err)
+		}
+	}
+	return nil
+}
+
+// groupError maps constraint violations to the corresponding model errors
+func groupError(err error) error {
+	if perr, ok := err.(*pq.Error); ok {
+		switch perr.Code {
+		case uniquenessViolation:
+			return models.ErrDuplicateGroup
+		case foreignKeyViolation:
+			return models.ErrInvalidMember
+		}
+	}
+	return err
+}
+
+// Get fetches a site group given its ID
+func (g *GroupModel) Get(id int) (*models.Group, error) {
+	group := &models.Group{}
+	var sites []int64
+	stmt := `SELECT id, name, rule, quorum, created, ` + membersColumn + ` FROM groups WHERE id = $1`
+	err := g.DB.QueryRow(stmt, id).Scan(&group.ID, &group.Name, &group.Rule, &group.Quorum, &group.Created, pq.Array(&sites))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	group.Sites = toInts(sites)
+	return group, nil
+}
+
+// GetAll fetches every site group
+func (g *GroupModel) GetAll() ([]*models.Group, error) {
+	groups := make([]*models.Group, 0)
+	stmt := `SELECT id, name, rule, quorum, created, ` + membersColumn + ` FROM groups ORDER BY id`
+	rows, err := g.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		group := &models.Group{}
+		var sites []int64
+		if err := rows.Scan(&group.ID, &group.Name, &group.Rule, &group.Quorum, &group.Created, pq.Array(&sites)); err != nil {
+			return nil, err
+		}
+		group.Sites = toInts(sites)
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// Delete removes a site group along with its history
+func (g *GroupModel) Delete(id int) error {
+	res, err := g.DB.Exec(`DELETE FROM groups WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// InsertStatus records a change in the composite health of a group
+func (g *GroupModel) InsertStatus(groupID int, at time.Time, status string, up, total int) (int, error) {
+	var id int
+	stmt := `INSERT INTO group_results (group_id, checked_at, status, up, total) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err := g.DB.QueryRow(stmt, groupID, at, status, up, total).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// GetHistory fetches the latest 20 changes in the composite health of a group, ordered by the check timestamp
+func (g *GroupModel) GetHistory(groupID int) ([]*models.GroupStatus, error) {
+	history := make([]*models.GroupStatus, 0)
+	stmt := `SELECT id, group_id, checked_at, status, up, total FROM group_results WHERE group_id = $1 ORDER BY checked_at DESC LIMIT 20`
+	rows, err := g.DB.Query(stmt, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		gs := &models.GroupStatus{}
+		if err := rows.Scan(&gs.ID, &gs.GroupID, &gs.At, &gs.Status, &gs.Up, &gs.Total); err != nil {
+			return nil, err
+		}
+		history = append(history, gs)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"github.com/dnataraj/healthbee/pkg/models"
+	"reflect"
+	"testing"
+)
+
+func TestGroupModel_Insert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	tests := []struct {
+		name       string
+		groupName  string
+		sites      []int
+		wantResult int
+		wantError  error
+	}{
+		{
+			name:       "Valid insert",
+			groupName:  "checkout",
+			sites:      []int{1, 2},
+			wantResult: 2,
+			wantError:  nil,
+		},
+		{
+			name:       "Duplicate name",
+			groupName:  "example",
+			sites:      []int{1},
+			wantResult: -1,
+			wantError:  models.ErrDuplicateGroup,
+		},
+		{
+			name:       "Unknown member",
+			groupName:  "checkout",
+			sites:      []int{1, 9},
+			wantResult: -1,
+			wantError:  models.ErrInvalidMember,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			g := &GroupModel{DB: db}
+			id, err := g.Insert(tt.groupName, models.RuleAll, 0, tt.sites)
+			if err != tt.wantError {
+				t.Errorf("want %v, got %s", tt.wantError, err)
+			}
+			if id != tt.wantResult {
+				t.Errorf("want %d, got %d", tt.wantResult, id)
+			}
+		})
+	}
+}
+
+func TestGroupModel_Get(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	g := &GroupModel{DB: db}
+	group, err := g.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Name != "example" || group.Rule != models.RuleAny || !reflect.DeepEqual(group.Sites, []int{1, 2}) {
+		t.Errorf("want group example with sites [1 2], got %+v", group)
+	}
+	history, err := g.GetHistory(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Status != models.StatusUp {
+		t.Errorf("want a single up status, got %+v", history)
+	}
+	if _, err := g.Get(3); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %s", models.ErrNoRecord, err)
+	}
+}
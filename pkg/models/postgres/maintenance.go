@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"time"
+)
+
+type MaintenanceModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a maintenance window to the maintenance_windows table
+// Windows apply either to a single site, or to all sites matching a label selector (with a zero site ID)
+func (m *MaintenanceModel) Insert(siteID int, selector string, start time.Time, duration models.Period, schedule, reason string) (int, error) {
+	var id int
+	var site sql.NullInt64
+	if siteID > 0 {
+		site = sql.NullInt64{Int64: int64(siteID), Valid: true}
+	}
+	stmt := `INSERT INTO maintenance_windows (site_id, selector, starts_at, duration, schedule, reason, created) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err := m.DB.QueryRow(stmt, site, selector, start, duration.Duration().Seconds(), schedule, reason, time.Now()).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// Get fetches a maintenance window given its ID
+func (m *MaintenanceModel) Get(id int) (*models.MaintenanceWindow, error) {
+	w := &models.MaintenanceWindow{}
+	var d int
+	stmt := `SELECT id, COALESCE(site_id, 0), selector, starts_at, duration, schedule, reason, created FROM maintenance_windows WHERE id = $1`
+	err := m.DB.QueryRow(stmt, id).Scan(&w.ID, &w.SiteID, &w.Selector, &w.Start, &d, &w.Schedule, &w.Reason, &w.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	w.Duration = models.Period(time.Duration(d) * time.Second)
+	return w, nil
+}
+
+// GetAll fetches every maintenance window, so that the monitors can be made aware of them
+func (m *MaintenanceModel) GetAll() ([]*models.MaintenanceWindow, error) {
+	windows := make([]*models.MaintenanceWindow, 0)
+	stmt := `SELECT id, COALESCE(site_id, 0), selector, starts_at, duration, schedule, reason, created FROM maintenance_windows ORDER BY id`
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		w := &models.MaintenanceWindow{}
+		var d int
+		if err := rows.Scan(&w.ID, &w.SiteID, &w.Selector, &w.Start, &d, &w.Schedule, &w.Reason, &w.Created); err != nil {
+			return nil, err
+		}
+		w.Duration = models.Period(time.Duration(d) * time.Second)
+		windows = append(windows, w)
+	}
+	return windows, rows.Err()
+}
+
+// Delete removes a maintenance window
+func (m *MaintenanceModel) Delete(id int) error {
+	res, err := m.DB.Exec(`DELETE FROM maintenance_windows WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"github.com/dnataraj/healthbee/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceModel_Get(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	tests := []struct {
+		name         string
+		id           int
+		wantSite     int
+		wantSchedule string
+		wantDuration models.Period
+		wantError    error
+	}{
+		{
+			name:         "One-off window",
+			id:           1,
+			wantSite:     1,
+			wantSchedule: "",
+			wantDuration: models.Period(time.Hour),
+			wantError:    nil,
+		},
+		{
+			name:         "Recurring window",
+			id:           2,
+			wantSite:     2,
+			wantSchedule: "0 2 * * 0",
+			wantDuration: models.Period(30 * time.Minute),
+			wantError:    nil,
+		},
+		{
+			name:         "Window for labels",
+			id:           3,
+			wantSite:     0,
+			wantSchedule: "",
+			wantDuration: models.Period(10 * time.Minute),
+			wantError:    nil,
+		},
+		{
+			name:      "Missing window",
+			id:        5,
+			wantError: models.ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := &MaintenanceModel{DB: db}
+			w, err := m.Get(tt.id)
+			if err != tt.wantError {
+				t.Errorf("want %v, got %s", tt.wantError, err)
+			}
+			if tt.wantError != nil {
+				return
+			}
+			if w.SiteID != tt.wantSite || w.Schedule != tt.wantSchedule || w.Duration != tt.wantDuration {
+				t.Errorf("want site %d, schedule %q and duration %s, got %+v", tt.wantSite, tt.wantSchedule, tt.wantDuration.Duration(), w)
+			}
+		})
+	}
+}
+
+func TestMaintenanceModel_Delete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := &MaintenanceModel{DB: db}
+	if err := m.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(1); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %s", models.ErrNoRecord, err)
+	}
+	windows, err := m.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 2 {
+		t.Errorf("want 2 windows, got %d", len(windows))
+	}
+}
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models/migrate"
+	"io/fs"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the key of the advisory lock held while migrating, so that HealthBee instances starting
+// together do not apply the same migrations
+const migrationLock = 0x6865616c7468
+
+// Migrations returns the migrations embedded in HealthBee, in order of their versions
+func Migrations() ([]*migrate.Migration, error) {
+	files, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.Load(files)
+}
+
+// NewMigrator returns a migrator applying the embedded migrations to a database, while holding an advisory lock
+func NewMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return &migrate.Migrator{
+		DB:         db,
+		Migrations: migrations,
+		Dialect: migrate.Dialect{
+			Table: `CREATE TABLE IF NOT EXISTS schema_migrations (
+				version INT PRIMARY KEY,
+				name TEXT NOT NULL,
+				applied_at TIMESTAMPTZ NOT NULL
+			)`,
+			Lock:   fmt.Sprintf(`SELECT pg_advisory_lock(%d)`, migrationLock),
+			Unlock: fmt.Sprintf(`SELECT pg_advisory_unlock(%d)`, migrationLock),
+		},
+	}, nil
+}
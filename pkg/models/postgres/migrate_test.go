@@ -0,0 +1,134 @@
+package postgres
+
+import (
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models/migrate"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("want embedded migrations, got none")
+	}
+	for i, mig := range migrations {
+		if mig.Version != i+1 || mig.Name == "" || mig.Up == "" || mig.Down == "" {
+			t.Errorf("want complete migration version %d, got %+v", i+1, mig)
+		}
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations := m.Migrations
+	latest := migrations[len(migrations)-1]
+
+	// the test database is already migrated
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("want no migrations applied, got %d", len(applied))
+	}
+
+	reverted, err := m.Down()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Version != latest.Version {
+		t.Errorf("want version %d reverted, got %d", latest.Version, reverted.Version)
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(migrations) || statuses[len(statuses)-1].Applied != nil {
+		t.Errorf("want version %d pending, got %+v", latest.Version, statuses[len(statuses)-1])
+	}
+
+	applied, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Version != latest.Version {
+		t.Errorf("want version %d applied again, got %d migrations", latest.Version, len(applied))
+	}
+
+	// reverting every migration leaves nothing to revert
+	for range migrations {
+		if _, err := m.Down(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Down(); !errors.Is(err, migrate.ErrNoMigration) {
+		t.Errorf("want %v, got %v", migrate.ErrNoMigration, err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrator_baseline(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+	// start over from the schema as it was first set up by hand, holding a site and its results
+	for _, f := range []string{"./testdata/teardown.sql", "./testdata/baseline.sql"} {
+		script, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(script)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations := m.Migrations
+	if len(applied) != len(migrations) {
+		t.Errorf("want %d migrations applied, got %d", len(migrations), len(applied))
+	}
+
+	// the site and its results are kept, with the columns added since
+	var kind, labels string
+	var paused bool
+	err = db.QueryRow(`SELECT kind, labels, paused FROM sites WHERE id = 1`).Scan(&kind, &labels, &paused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != "http" || labels != "{}" || paused {
+		t.Errorf("want an unpaused HTTP site without labels, got %s site with labels %s, paused %t", kind, labels, paused)
+	}
+	var n int
+	err = db.QueryRow(`SELECT COUNT(*) FROM results WHERE site_id = 1 AND source = '' AND NOT maintenance AND NOT unreachable`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("want 2 results kept, got %d", n)
+	}
+}
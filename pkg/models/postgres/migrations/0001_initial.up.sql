@@ -0,0 +1,29 @@
+-- The schema as it was first set up by hand, before migrations were introduced. Tables and indexes that already exist
+-- are kept, so that such databases are adopted by the migrations as they are
+
+CREATE TABLE IF NOT EXISTS sites (
+    id INT GENERATED ALWAYS AS IDENTITY,
+    site_hash TEXT UNIQUE NOT NULL,
+    url VARCHAR(2000) NOT NULL,
+    period INT NOT NULL,
+    pattern VARCHAR(100) NOT NULL,
+    created TIMESTAMPTZ,
+    PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_site ON sites(id);
+CREATE INDEX IF NOT EXISTS idx_site_hash ON sites (site_hash);
+
+CREATE TABLE IF NOT EXISTS results (
+    id INT GENERATED ALWAYS AS IDENTITY,
+    site_id INT NOT NULL ,
+    checked_at TIMESTAMPTZ,
+    response_time INT,
+    result INT,
+    matched BOOLEAN NOT NULL,
+    CONSTRAINT fk_sites
+        FOREIGN KEY(site_id)
+            REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_site_id ON results(site_id);
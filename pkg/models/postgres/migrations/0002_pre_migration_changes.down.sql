@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS group_results;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS groups;
+DROP TABLE IF EXISTS maintenance_windows;
+ALTER TABLE results DROP COLUMN IF EXISTS source;
+ALTER TABLE results DROP COLUMN IF EXISTS unreachable;
+ALTER TABLE results DROP COLUMN IF EXISTS maintenance;
+DROP TABLE IF EXISTS site_dependencies;
+DROP INDEX IF EXISTS idx_site_labels;
+ALTER TABLE sites DROP COLUMN IF EXISTS labels;
+ALTER TABLE sites DROP COLUMN IF EXISTS token;
+ALTER TABLE sites DROP COLUMN IF EXISTS grace;
+ALTER TABLE sites DROP COLUMN IF EXISTS kind;
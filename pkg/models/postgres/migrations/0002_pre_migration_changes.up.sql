@@ -0,0 +1,65 @@
+-- The changes made by hand to the initial schema before migrations were introduced: heartbeat sites, labels,
+-- dependencies, maintenance windows and groups. Columns, tables and indexes that already exist are kept, so that
+-- databases set up by hand at any point before migrations are adopted as they are
+
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'http';
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS grace INT NOT NULL DEFAULT 0;
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS token VARCHAR(64) UNIQUE;
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_site_labels ON sites USING GIN (labels);
+
+CREATE TABLE IF NOT EXISTS site_dependencies (
+    site_id INT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    parent_id INT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    PRIMARY KEY(site_id, parent_id)
+);
+
+ALTER TABLE results ADD COLUMN IF NOT EXISTS maintenance BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE results ADD COLUMN IF NOT EXISTS unreachable BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE results ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+
+-- named after the constraint created along with the table, so that an existing constraint is kept
+CREATE UNIQUE INDEX IF NOT EXISTS results_site_id_checked_at_source_key ON results(site_id, checked_at, source);
+
+CREATE TABLE IF NOT EXISTS maintenance_windows (
+    id INT GENERATED ALWAYS AS IDENTITY,
+    site_id INT,
+    selector VARCHAR(200) NOT NULL DEFAULT '',
+    starts_at TIMESTAMPTZ NOT NULL,
+    duration INT NOT NULL,
+    schedule VARCHAR(200) NOT NULL DEFAULT '',
+    reason VARCHAR(200) NOT NULL DEFAULT '',
+    created TIMESTAMPTZ,
+    PRIMARY KEY(id),
+    CONSTRAINT fk_sites
+        FOREIGN KEY(site_id)
+            REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS groups (
+    id INT GENERATED ALWAYS AS IDENTITY,
+    name VARCHAR(200) UNIQUE NOT NULL,
+    rule VARCHAR(20) NOT NULL,
+    quorum INT NOT NULL DEFAULT 0,
+    created TIMESTAMPTZ,
+    PRIMARY KEY(id)
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    site_id INT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    PRIMARY KEY(group_id, site_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_results (
+    id INT GENERATED ALWAYS AS IDENTITY,
+    group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    checked_at TIMESTAMPTZ,
+    status VARCHAR(20) NOT NULL,
+    up INT NOT NULL,
+    total INT NOT NULL,
+    PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_id ON group_results(group_id);
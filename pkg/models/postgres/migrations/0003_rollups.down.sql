@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_results_checked_at;
+ALTER TABLE sites DROP COLUMN IF EXISTS retention;
+DROP TABLE IF EXISTS results_daily;
+DROP TABLE IF EXISTS results_hourly;
@@ -0,0 +1,34 @@
+-- Hourly and daily aggregates of the results, so that raw results can be pruned after their retention period.
+-- Response times are in milliseconds, and only cover checks that got a response
+CREATE TABLE results_hourly (
+    site_id INT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    bucket TIMESTAMPTZ NOT NULL,
+    checks INT NOT NULL,
+    failures INT NOT NULL,
+    excluded INT NOT NULL,
+    min_response_time INT,
+    avg_response_time DOUBLE PRECISION,
+    p95_response_time DOUBLE PRECISION,
+    max_response_time INT,
+    status_codes JSONB NOT NULL DEFAULT '{}',
+    PRIMARY KEY(site_id, bucket)
+);
+
+CREATE TABLE results_daily (
+    site_id INT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    bucket TIMESTAMPTZ NOT NULL,
+    checks INT NOT NULL,
+    failures INT NOT NULL,
+    excluded INT NOT NULL,
+    min_response_time INT,
+    avg_response_time DOUBLE PRECISION,
+    p95_response_time DOUBLE PRECISION,
+    max_response_time INT,
+    status_codes JSONB NOT NULL DEFAULT '{}',
+    PRIMARY KEY(site_id, bucket)
+);
+
+-- the retention of raw results for a site in seconds, overriding the default retention if set
+ALTER TABLE sites ADD COLUMN retention INT NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_results_checked_at ON results(checked_at);
@@ -0,0 +1,24 @@
+CREATE TABLE results_unpartitioned (
+    id INT GENERATED ALWAYS AS IDENTITY,
+    site_id INT NOT NULL ,
+    checked_at TIMESTAMPTZ,
+    response_time INT,
+    result INT,
+    matched BOOLEAN NOT NULL,
+    maintenance BOOLEAN NOT NULL DEFAULT false,
+    unreachable BOOLEAN NOT NULL DEFAULT false,
+    source TEXT NOT NULL DEFAULT '',
+    CONSTRAINT fk_sites
+        FOREIGN KEY(site_id)
+            REFERENCES sites(id) ON DELETE CASCADE
+);
+
+INSERT INTO results_unpartitioned OVERRIDING SYSTEM VALUE SELECT * FROM results;
+DROP TABLE results;
+ALTER TABLE results_unpartitioned RENAME TO results;
+ALTER SEQUENCE results_unpartitioned_id_seq RENAME TO results_id_seq;
+SELECT setval('results_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM results;
+
+ALTER TABLE results ADD UNIQUE (site_id, checked_at, source);
+CREATE INDEX idx_site_id ON results(site_id);
+CREATE INDEX idx_results_checked_at ON results(checked_at);
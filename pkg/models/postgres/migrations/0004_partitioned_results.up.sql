@@ -0,0 +1,43 @@
+-- Partitions the results by check time, so that inserts and queries only touch recent partitions and retention can
+-- drop whole partitions. Existing results are copied into daily partitions, and further partitions are created ahead
+-- of time by HealthBee. Results outside of every partition, such as those without a check time, go to the default
+-- partition. Partitions are named results_p<from>_<to> after the (UTC) dates they cover
+SET LOCAL TimeZone = 'UTC';
+
+CREATE TABLE results_partitioned (
+    id INT GENERATED ALWAYS AS IDENTITY,
+    site_id INT NOT NULL ,
+    checked_at TIMESTAMPTZ,
+    response_time INT,
+    result INT,
+    matched BOOLEAN NOT NULL,
+    maintenance BOOLEAN NOT NULL DEFAULT false,
+    unreachable BOOLEAN NOT NULL DEFAULT false,
+    source TEXT NOT NULL DEFAULT '',
+    CONSTRAINT fk_sites
+        FOREIGN KEY(site_id)
+            REFERENCES sites(id) ON DELETE CASCADE
+) PARTITION BY RANGE (checked_at);
+
+CREATE TABLE results_default PARTITION OF results_partitioned DEFAULT;
+
+DO $$
+DECLARE
+    day TIMESTAMPTZ;
+BEGIN
+    FOR day IN SELECT generate_series(date_trunc('day', COALESCE(MIN(checked_at), now())),
+            date_trunc('day', now()) + INTERVAL '7 days', INTERVAL '1 day') FROM results LOOP
+        EXECUTE format('CREATE TABLE %I PARTITION OF results_partitioned FOR VALUES FROM (%L) TO (%L)',
+            'results_p' || to_char(day, 'YYYYMMDD') || '_' || to_char(day + INTERVAL '1 day', 'YYYYMMDD'),
+            day, day + INTERVAL '1 day');
+    END LOOP;
+END $$;
+
+INSERT INTO results_partitioned OVERRIDING SYSTEM VALUE SELECT * FROM results;
+DROP TABLE results;
+ALTER TABLE results_partitioned RENAME TO results;
+ALTER SEQUENCE results_partitioned_id_seq RENAME TO results_id_seq;
+SELECT setval('results_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM results;
+
+ALTER TABLE results ADD UNIQUE (site_id, checked_at, source);
+CREATE INDEX idx_results_site_checked_at ON results(site_id, checked_at);
@@ -0,0 +1,2 @@
+-- Paused sites stay registered, with their results, but are not monitored until they are resumed
+ALTER TABLE sites ADD COLUMN paused BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,230 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Intervals that results are partitioned by, weeks start on Monday
+const (
+	PartitionDay  = "day"
+	PartitionWeek = "week"
+)
+
+// partitionLock is the key of the advisory lock held while partitions are created or dropped, so that HealthBee
+// instances do not race each other
+const partitionLock = 0x6865616c7470
+
+var partitionName = regexp.MustCompile(`^results_p(\d{8})_(\d{8})$`)
+
+// Partition is a partition of the results table, holding the results checked from From until To (in UTC)
+type Partition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+type PartitionModel struct {
+	DB *sql.DB
+}
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// ValidatePartitionInterval checks that results can be partitioned by the given interval
+func ValidatePartitionInterval(interval string) error {
+	if interval != PartitionDay && interval != PartitionWeek {
+		return fmt.Errorf("partitions: unknown interval %q", interval)
+	}
+	return nil
+}
+
+// periodStart truncates a time to the start of its day or week in UTC
+func periodStart(interval string, t time.Time) time.Time {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if interval == PartitionWeek {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	}
+	return start
+}
+
+// periodEnd returns the end of the day or week starting at the given time
+func periodEnd(interval string, start time.Time) time.Time {
+	if interval == PartitionWeek {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// List returns the partitions of the results table in order, apart from the default partition
+func (m *PartitionModel) List() ([]*Partition, error) {
+	return listPartitions(m.DB)
+}
+
+func listPartitions(q querier) ([]*Partition, error) {
+	stmt := `SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'results'::regclass`
+	rows, err := q.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	partitions := make([]*Partition, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		m := partitionName.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		p := &Partition{Name: name}
+		if p.From, err = time.Parse("20060102", m[1]); err != nil {
+			return nil, err
+		}
+		if p.To, err = time.Parse("20060102", m[2]); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].From.Before(partitions[j].From)
+	})
+	return partitions, nil
+}
+
+// Create creates the partitions for the current day or week and the given number of periods ahead of it, and returns
+// the partitions created. New partitions start where the latest partition ends, so that the interval can be changed,
+// and partitions missing since then, for example while HealthBee was down, are created as well. Results stored in the
+// default partition while partitions were missing are moved into the new ones
+func (m *PartitionModel) Create(interval string, now time.Time, ahead int) ([]*Partition, error) {
+	if err := ValidatePartitionInterval(interval); err != nil {
+		return nil, err
+	}
+	created := make([]*Partition, 0)
+	err := m.locked(func(tx *sql.Tx) error {
+		existing, err := listPartitions(tx)
+		if err != nil {
+			return err
+		}
+		latest := time.Time{}
+		if len(existing) > 0 {
+			latest = existing[len(existing)-1].To
+		}
+		start := periodStart(interval, now)
+		until := start
+		for i := 0; i <= ahead; i++ {
+			until = periodEnd(interval, until)
+		}
+		if !latest.IsZero() && latest.Before(start) {
+			start = periodStart(interval, latest)
+		}
+		for start.Before(until) {
+			end := periodEnd(interval, start)
+			from := start
+			if from.Before(latest) {
+				from = latest
+			}
+			if from.Before(end) {
+				p := &Partition{
+					Name: fmt.Sprintf("results_p%s_%s", from.Format("20060102"), end.Format("20060102")),
+					From: from,
+					To:   end,
+				}
+				if err := createPartition(tx, p); err != nil {
+					return fmt.Errorf("partitions: creating %s failed with: %s", p.Name, err)
+				}
+				created = append(created, p)
+			}
+			start = end
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// createPartition creates a partition and moves the results it covers out of the default partition, before
+// attaching it to the results table
+func createPartition(tx *sql.Tx, p *Partition) error {
+	name := pq.QuoteIdentifier(p.Name)
+	if _, err := tx.Exec(`CREATE TABLE ` + name + ` (LIKE results INCLUDING DEFAULTS INCLUDING CONSTRAINTS)`); err != nil {
+		return err
+	}
+	stmt := `WITH moved AS (DELETE FROM results_default WHERE checked_at >= $1 AND checked_at < $2 RETURNING *)
+		INSERT INTO ` + name + ` SELECT * FROM moved`
+	if _, err := tx.Exec(stmt, p.From, p.To); err != nil {
+		return err
+	}
+	stmt = fmt.Sprintf(`ALTER TABLE results ATTACH PARTITION %s FOR VALUES FROM (%s) TO (%s)`, name,
+		pq.QuoteLiteral(p.From.Format(time.RFC3339)), pq.QuoteLiteral(p.To.Format(time.RFC3339)))
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+// Drop drops the partitions that only hold results past their retention, and returns the partitions dropped.
+// Partitions are kept for as long as the longest retention of any site, and are never dropped without a default
+// retention, since sites without a retention of their own keep their results forever
+func (m *PartitionModel) Drop(retention time.Duration, now time.Time) ([]*Partition, error) {
+	if retention == 0 {
+		return nil, nil
+	}
+	dropped := make([]*Partition, 0)
+	err := m.locked(func(tx *sql.Tx) error {
+		var longest int
+		if err := tx.QueryRow(`SELECT COALESCE(MAX(retention), 0) FROM sites`).Scan(&longest); err != nil {
+			return err
+		}
+		if r := time.Duration(longest) * time.Second; r > retention {
+			retention = r
+		}
+		partitions, err := listPartitions(tx)
+		if err != nil {
+			return err
+		}
+		before := now.Add(-retention)
+		for _, p := range partitions {
+			if p.To.After(before) {
+				break
+			}
+			if _, err := tx.Exec(`DROP TABLE ` + pq.QuoteIdentifier(p.Name)); err != nil {
+				return fmt.Errorf("partitions: dropping %s failed with: %s", p.Name, err)
+			}
+			dropped = append(dropped, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dropped, nil
+}
+
+// locked runs fn in a transaction holding the partition lock
+func (m *PartitionModel) locked(fn func(tx *sql.Tx) error) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, partitionLock); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"github.com/dnataraj/healthbee/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	// a Wednesday
+	at := time.Date(2021, 3, 3, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		interval  string
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "Day",
+			interval:  PartitionDay,
+			wantStart: time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "Week",
+			interval:  PartitionWeek,
+			wantStart: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2021, 3, 8, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := periodStart(tt.interval, at)
+			if !start.Equal(tt.wantStart) {
+				t.Errorf("want start %v, got %v", tt.wantStart, start)
+			}
+			if end := periodEnd(tt.interval, start); !end.Equal(tt.wantEnd) {
+				t.Errorf("want end %v, got %v", tt.wantEnd, end)
+			}
+		})
+	}
+
+	// a Sunday belongs to the week starting on the Monday before
+	sunday := time.Date(2021, 3, 7, 23, 0, 0, 0, time.UTC)
+	if start := periodStart(PartitionWeek, sunday); !start.Equal(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("want the week starting on 2021-03-01, got %v", start)
+	}
+}
+
+func TestPartitionModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+	m := &PartitionModel{DB: db}
+	results := &ResultModel{DB: db}
+
+	// the migration created daily partitions for the coming week
+	existing, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(existing) == 0 {
+		t.Fatal("want results partitions, got none")
+	}
+	latest := existing[len(existing)-1].To
+	created, err := m.Create(PartitionWeek, time.Now(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range created {
+		if p.From.Before(latest) {
+			t.Errorf("want partitions created from %v, got %s", latest, p.Name)
+		}
+	}
+
+	// a result checked past the partitions is stored in the default partition, until its partition is created along
+	// with the partitions missing before it
+	existing, _ = m.List()
+	latest = existing[len(existing)-1].To
+	at := latest.Add(36 * time.Hour)
+	id, err := results.Insert(1, at, 0, 200, true, false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err = m.Create(PartitionDay, at, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 || !created[0].From.Equal(latest) || !created[1].From.Equal(latest.Add(24*time.Hour)) {
+		t.Fatalf("want the partitions from %v until the partition of %v created, got %+v", latest, at, created)
+	}
+	last := created[len(created)-1]
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM `+last.Name+` WHERE id = $1`, id).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want the result moved to %s, got %d results", last.Name, n)
+	}
+
+	// partitions are kept for the longest retention of any site
+	later := last.To.Add(72 * time.Hour)
+	sites := &SiteModel{DB: db}
+	if err := sites.SetRetention(2, models.Period(60*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := m.Drop(72*time.Hour, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 0 {
+		t.Errorf("want no partitions dropped, got %d", len(dropped))
+	}
+	if err := sites.SetRetention(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	all, _ := m.List()
+	dropped, err = m.Drop(72*time.Hour, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != len(all) {
+		t.Errorf("want %d partitions dropped, got %d", len(all), len(dropped))
+	}
+	if _, err := results.Get(id); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+}
@@ -1,9 +1,13 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/lib/pq"
+	"strings"
 	"time"
 )
 
@@ -12,22 +16,75 @@ type ResultModel struct {
 }
 
 // Insert adds an availability metric to the Results table
-func (r *ResultModel) Insert(siteID int, checkedAt time.Time, responseTime models.Period, code int, matched bool) (int, error) {
+// Results recorded during a maintenance window are flagged, so that they can be excluded from uptime calculations,
+// as are failures caused by a site dependency being down.
+// Results are unique for a site, check time and source, so inserting a result again returns the ID of the existing one
+func (r *ResultModel) Insert(siteID int, checkedAt time.Time, responseTime models.Period, code int, matched, maintenance, unreachable bool, source string) (int, error) {
 	var id int
-	stmt := `INSERT INTO results (site_id, checked_at, response_time, result, matched) VALUES ($1, $2, $3, $4, $5) RETURNING id`
-	err := r.DB.QueryRow(stmt, siteID, checkedAt, responseTime.Duration().Milliseconds(), code, matched).Scan(&id)
+	stmt := `INSERT INTO results (site_id, checked_at, response_time, result, matched, maintenance, unreachable, source) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (site_id, checked_at, source) DO NOTHING RETURNING id`
+	err := r.DB.QueryRow(stmt, siteID, checkedAt, responseTime.Duration().Milliseconds(), code, matched, maintenance, unreachable, source).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		stmt = `SELECT id FROM results WHERE site_id = $1 AND checked_at = $2 AND source = $3`
+		err = r.DB.QueryRow(stmt, siteID, checkedAt, source).Scan(&id)
+	}
 	if err != nil {
-		return -1, nil
+		if perr, ok := err.(*pq.Error); ok && perr.Code == foreignKeyViolation {
+			return -1, models.ErrInvalidResult
+		}
+		return -1, err
 	}
 	return id, nil
 }
 
+// batchSize caps the number of rows in a single multi-row INSERT, keeping well within the parameter limit
+const batchSize = 1000
+
+// InsertBatch adds a batch of availability metrics to the Results table, using multi-row INSERT statements
+// The batch is written in a single transaction, so either all or none of the metrics are added.
+// Metrics that were already added are skipped, so that batches can safely be redelivered, while metrics of unknown
+// sites fail the batch with models.ErrInvalidResult
+func (r *ResultModel) InsertBatch(results []*models.CheckResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(results); start += batchSize {
+		end := start + batchSize
+		if end > len(results) {
+			end = len(results)
+		}
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, 8*(end-start))
+		for i, res := range results[start:end] {
+			n := 8 * i
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+			args = append(args, res.SiteID, res.At, res.ResponseTime.Duration().Milliseconds(), res.ResponseCode,
+				res.MatchedPattern, res.Maintenance, res.Unreachable, res.Source)
+		}
+		stmt := `INSERT INTO results (site_id, checked_at, response_time, result, matched, maintenance, unreachable, source) VALUES ` +
+			strings.Join(values, ", ") + ` ON CONFLICT (site_id, checked_at, source) DO NOTHING`
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			if perr, ok := err.(*pq.Error); ok && perr.Code == foreignKeyViolation {
+				return models.ErrInvalidResult
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // Get fetches an availability metric from the Results table given a metric ID
 func (r *ResultModel) Get(id int) (*models.CheckResult, error) {
 	res := &models.CheckResult{}
 	var rt int
-	stmt := `SELECT id, site_id, checked_at, response_time, result, matched FROM results WHERE id = $1`
-	err := r.DB.QueryRow(stmt, id).Scan(&res.ID, &res.SiteID, &res.At, &rt, &res.ResponseCode, &res.MatchedPattern)
+	stmt := `SELECT id, site_id, checked_at, response_time, result, matched, maintenance, unreachable, source FROM results WHERE id = $1`
+	err := r.DB.QueryRow(stmt, id).Scan(&res.ID, &res.SiteID, &res.At, &rt, &res.ResponseCode, &res.MatchedPattern, &res.Maintenance, &res.Unreachable, &res.Source)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, models.ErrNoRecord
@@ -38,12 +95,182 @@ func (r *ResultModel) Get(id int) (*models.CheckResult, error) {
 	return res, nil
 }
 
-// GetResultsForSite fetches the latest 20 site availability metrics for a given Site ID
-// Results are ordered by the check timestamp.
-func (r *ResultModel) GetResultsForSite(siteID int) ([]*models.CheckResult, error) {
+// GetResultsForSite fetches a page of site availability metrics for a given Site ID, latest first.
+// The cursor of the next page is returned if there are more metrics, while a site without any metrics is reported
+// with models.ErrNoRecord
+func (r *ResultModel) GetResultsForSite(siteID int, page models.Page) ([]*models.CheckResult, *models.Cursor, error) {
+	metrics, next, err := r.Find(siteID, models.ResultFilter{}, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(metrics) == 0 && page.After == nil {
+		// We did not find the site
+		return nil, nil, models.ErrNoRecord
+	}
+	return metrics, next, nil
+}
+
+// failureConditions select each kind of failed check, following how models.CheckResult reports the status of a check
+var failureConditions = map[string]string{
+	models.FailureAny:         `(unreachable OR NOT (COALESCE(result, 0) BETWEEN 200 AND 399 AND matched))`,
+	models.FailureError:       `(NOT unreachable AND COALESCE(result, 0) <= 0)`,
+	models.FailureStatus:      `(NOT unreachable AND result > 0 AND (result < 200 OR result >= 400))`,
+	models.FailureContent:     `(NOT unreachable AND result BETWEEN 200 AND 399 AND NOT matched)`,
+	models.FailureUnreachable: `unreachable`,
+}
+
+// filterConditions returns the conditions selecting the results of a site that match the filter, along with their
+// arguments, numbered from $1
+func filterConditions(siteID int, filter models.ResultFilter) ([]string, []interface{}, error) {
+	conditions := []string{`site_id = $1`}
+	args := []interface{}{siteID}
+	where := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(cond, "?", fmt.Sprintf("$%d", len(args))))
+	}
+	if !filter.From.IsZero() {
+		where(`checked_at >= ?`, filter.From)
+	}
+	if !filter.To.IsZero() {
+		where(`checked_at < ?`, filter.To)
+	}
+	switch {
+	case len(filter.Codes) > 0 && len(filter.Classes) > 0:
+		args = append(args, pq.Array(toInt64s(filter.Codes)), pq.Array(toInt64s(filter.Classes)))
+		conditions = append(conditions, fmt.Sprintf(`(result = ANY($%d) OR result / 100 = ANY($%d))`, len(args)-1, len(args)))
+	case len(filter.Codes) > 0:
+		where(`result = ANY(?)`, pq.Array(toInt64s(filter.Codes)))
+	case len(filter.Classes) > 0:
+		where(`result / 100 = ANY(?)`, pq.Array(toInt64s(filter.Classes)))
+	}
+	if filter.Matched != nil {
+		where(`matched = ?`, *filter.Matched)
+	}
+	if filter.Failure != "" {
+		cond, ok := failureConditions[filter.Failure]
+		if !ok {
+			return nil, nil, models.ErrInvalidFilter
+		}
+		conditions = append(conditions, cond)
+	}
+	if filter.MinResponseTime > 0 {
+		where(`response_time >= ?`, filter.MinResponseTime.Duration().Milliseconds())
+	}
+	return conditions, args, nil
+}
+
+// Find fetches a page of the availability metrics of a site that match the filter, in the order the filter asks for.
+// The cursor of the next page is returned if there are more metrics, while no matching metrics is not an error
+func (r *ResultModel) Find(siteID int, filter models.ResultFilter, page models.Page) ([]*models.CheckResult, *models.Cursor, error) {
+	conditions, args, err := filterConditions(siteID, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	order, after := "DESC", "<"
+	if filter.Ascending {
+		order, after = "ASC", ">"
+	}
+	if page.After != nil {
+		args = append(args, page.After.At, page.After.ID)
+		conditions = append(conditions, fmt.Sprintf(`(checked_at, id) %s ($%d, $%d)`, after, len(args)-1, len(args)))
+	}
+	stmt := `SELECT id, site_id, checked_at, response_time, result, matched, maintenance, unreachable, source FROM results WHERE ` +
+		strings.Join(conditions, " AND ") + fmt.Sprintf(` ORDER BY checked_at %s, id %s LIMIT %d`, order, order, page.Size()+1)
+	rows, err := r.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	metrics := make([]*models.CheckResult, 0)
+	for rows.Next() {
+		res := &models.CheckResult{}
+		var rt int
+		if err := rows.Scan(&res.ID, &res.SiteID, &res.At, &rt, &res.ResponseCode, &res.MatchedPattern, &res.Maintenance, &res.Unreachable, &res.Source); err != nil {
+			return nil, nil, err
+		}
+		res.ResponseTime = models.Period(time.Duration(rt) * time.Millisecond)
+		metrics = append(metrics, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(metrics) > page.Size() {
+		metrics = metrics[:page.Size()]
+		last := metrics[len(metrics)-1]
+		return metrics, &models.Cursor{At: last.At, ID: last.ID}, nil
+	}
+	return metrics, nil, nil
+}
+
+// exportFetchSize is the number of results fetched from the export cursor at a time
+const exportFetchSize = 1000
+
+// Export streams the availability metrics of a site that match the filter to fn, in the order the filter asks for.
+// Metrics are read through a cursor a batch at a time, so that exports of any size are not held in memory, and the
+// export stops at the first error returned by fn
+func (r *ResultModel) Export(siteID int, filter models.ResultFilter, fn func(res *models.CheckResult) error) error {
+	conditions, args, err := filterConditions(siteID, filter)
+	if err != nil {
+		return err
+	}
+	order := "DESC"
+	if filter.Ascending {
+		order = "ASC"
+	}
+	tx, err := r.DB.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	// the cursor is closed along with the transaction
+	defer tx.Rollback()
+
+	stmt := `DECLARE results_export NO SCROLL CURSOR FOR
+		SELECT id, site_id, checked_at, response_time, result, matched, maintenance, unreachable, source FROM results WHERE ` +
+		strings.Join(conditions, " AND ") + ` ORDER BY checked_at ` + order + `, id ` + order
+	if _, err := tx.Exec(stmt, args...); err != nil {
+		return err
+	}
+	for {
+		n, err := fetchResults(tx, fn)
+		if err != nil {
+			return err
+		}
+		if n < exportFetchSize {
+			return tx.Commit()
+		}
+	}
+}
+
+// fetchResults fetches the next batch of results from the export cursor, and returns the number of results fetched
+func fetchResults(tx *sql.Tx, fn func(res *models.CheckResult) error) (int, error) {
+	rows, err := tx.Query(fmt.Sprintf(`FETCH %d FROM results_export`, exportFetchSize))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		res := &models.CheckResult{}
+		var rt int
+		if err := rows.Scan(&res.ID, &res.SiteID, &res.At, &rt, &res.ResponseCode, &res.MatchedPattern, &res.Maintenance, &res.Unreachable, &res.Source); err != nil {
+			return n, err
+		}
+		res.ResponseTime = models.Period(time.Duration(rt) * time.Millisecond)
+		if err := fn(res); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// GetLatest fetches the most recent availability metric for each of the given sites
+// Sites without any metrics are left out
+func (r *ResultModel) GetLatest(siteIDs []int) ([]*models.CheckResult, error) {
 	metrics := make([]*models.CheckResult, 0)
-	stmt := `SELECT id, site_id, checked_at, response_time, result, matched FROM results WHERE site_id = $1 ORDER BY checked_at DESC LIMIT 20`
-	rows, err := r.DB.Query(stmt, siteID)
+	stmt := `SELECT DISTINCT ON (site_id) id, site_id, checked_at, response_time, result, matched, maintenance, unreachable, source
+		FROM results WHERE site_id = ANY($1) ORDER BY site_id, checked_at DESC`
+	rows, err := r.DB.Query(stmt, pq.Array(toInt64s(siteIDs)))
 	if err != nil {
 		return nil, err
 	}
@@ -51,21 +278,11 @@ func (r *ResultModel) GetResultsForSite(siteID int) ([]*models.CheckResult, erro
 	for rows.Next() {
 		res := &models.CheckResult{}
 		var rt int
-		if err := rows.Scan(&res.ID, &res.SiteID, &res.At, &rt, &res.ResponseCode, &res.MatchedPattern); err != nil {
-			// It's odd that Scan doesn't return sql.ErrNoRows as described here:
-			// https://pkg.go.dev/database/sql#ErrNoRows
-			if errors.Is(err, sql.ErrNoRows) {
-				return nil, models.ErrNoRecord
-			}
+		if err := rows.Scan(&res.ID, &res.SiteID, &res.At, &rt, &res.ResponseCode, &res.MatchedPattern, &res.Maintenance, &res.Unreachable, &res.Source); err != nil {
 			return nil, err
 		}
 		res.ResponseTime = models.Period(time.Duration(rt) * time.Millisecond)
 		metrics = append(metrics, res)
 	}
-	if len(metrics) == 0 {
-		// We did not find the site
-		return nil, models.ErrNoRecord
-	}
-
-	return metrics, nil
+	return metrics, rows.Err()
 }
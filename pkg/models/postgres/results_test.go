@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"github.com/dnataraj/healthbee/pkg/models"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -69,6 +70,8 @@ func TestResultModel_Insert(t *testing.T) {
 		responseTime models.Period
 		responseCode int
 		matched      bool
+		maintenance  bool
+		unreachable  bool
 		wantResult   int
 		wantError    error
 	}{
@@ -82,6 +85,28 @@ func TestResultModel_Insert(t *testing.T) {
 			wantResult:   4,
 			wantError:    nil,
 		},
+		{
+			name:         "Valid insert during maintenance",
+			siteID:       1,
+			at:           at,
+			responseTime: models.Period(300 * time.Millisecond),
+			responseCode: 503,
+			matched:      false,
+			maintenance:  true,
+			wantResult:   4,
+			wantError:    nil,
+		},
+		{
+			name:         "Valid insert while unreachable",
+			siteID:       2,
+			at:           at,
+			responseTime: models.Period(-1),
+			responseCode: -1,
+			matched:      false,
+			unreachable:  true,
+			wantResult:   4,
+			wantError:    nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -90,7 +115,7 @@ func TestResultModel_Insert(t *testing.T) {
 			defer teardown()
 
 			r := &ResultModel{DB: db}
-			id, err := r.Insert(tt.siteID, tt.at, tt.responseTime, tt.responseCode, tt.matched)
+			id, err := r.Insert(tt.siteID, tt.at, tt.responseTime, tt.responseCode, tt.matched, tt.maintenance, tt.unreachable, "test")
 			if err != tt.wantError {
 				t.Errorf("want %v, got %s", tt.wantError, err)
 			}
@@ -101,6 +126,98 @@ func TestResultModel_Insert(t *testing.T) {
 	}
 }
 
+func TestResultModel_Insert_duplicate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	at := time.Now().UTC()
+	id, err := r.Insert(1, at, models.Period(300*time.Millisecond), 200, true, false, false, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a redelivered result is not added again
+	dup, err := r.Insert(1, at, models.Period(300*time.Millisecond), 200, true, false, false, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dup != id {
+		t.Errorf("want %d, got %d", id, dup)
+	}
+	// while the same check from another source is
+	other, err := r.Insert(1, at, models.Period(300*time.Millisecond), 200, true, false, false, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == id {
+		t.Errorf("want a new result, got %d", other)
+	}
+	// errors are reported, such as for an unknown site
+	if _, err := r.Insert(10, at, models.Period(300*time.Millisecond), 200, true, false, false, "test"); err != models.ErrInvalidResult {
+		t.Errorf("want %v, got %v", models.ErrInvalidResult, err)
+	}
+}
+
+func TestResultModel_InsertBatch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	at := time.Now().UTC()
+	batch := make([]*models.CheckResult, 0, 1500)
+	for i := 0; i < 1500; i++ {
+		batch = append(batch, &models.CheckResult{
+			SiteID:         1 + i%2,
+			At:             at.Add(time.Duration(i) * time.Second),
+			ResponseTime:   models.Period(300 * time.Millisecond),
+			ResponseCode:   200,
+			MatchedPattern: true,
+		})
+	}
+	if err := r.InsertBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM results`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	// the 3 results from the test data, along with the batch spanning two statements
+	if n != 1503 {
+		t.Errorf("want 1503 metrics, got %d", n)
+	}
+
+	// redelivering the batch does not add it again
+	if err := r.InsertBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT count(*) FROM results`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1503 {
+		t.Errorf("want 1503 metrics, got %d", n)
+	}
+
+	// a batch with an unknown site is rejected as a whole
+	err := r.InsertBatch([]*models.CheckResult{{SiteID: 1, At: at.Add(-time.Hour)}, {SiteID: 10, At: at}})
+	if err != models.ErrInvalidResult {
+		t.Errorf("want %v, got %v", models.ErrInvalidResult, err)
+	}
+	if err := db.QueryRow(`SELECT count(*) FROM results`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1503 {
+		t.Errorf("want 1503 metrics, got %d", n)
+	}
+}
+
 func TestResultModel_GetResultsForSite(t *testing.T) {
 	if testing.Short() {
 		t.Skip("postgres: skipping integration test")
@@ -111,7 +228,7 @@ func TestResultModel_GetResultsForSite(t *testing.T) {
 		defer teardown()
 
 		r := ResultModel{DB: db}
-		results, err := r.GetResultsForSite(2)
+		results, _, err := r.GetResultsForSite(2, models.Page{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -129,6 +246,149 @@ func TestResultModel_GetResultsForSite(t *testing.T) {
 	})
 }
 
+func TestResultModel_GetResultsForSite_pages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	// results checked at the same time are ordered by their IDs
+	at := time.Now().UTC().Truncate(time.Second)
+	for _, source := range []string{"a", "b", "c"} {
+		if _, err := r.Insert(1, at, models.Period(100*time.Millisecond), 200, true, false, false, source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results, next, err := r.GetResultsForSite(1, models.Page{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || next == nil {
+		t.Fatalf("want a page of 2 metrics and a next page, got %d metrics", len(results))
+	}
+	rest, next, err := r.GetResultsForSite(1, models.Page{Limit: 2, After: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 2 || next != nil {
+		t.Errorf("want a last page of 2 metrics, got %d metrics", len(rest))
+	}
+	if results[1].ID == rest[0].ID {
+		t.Errorf("want pages without overlap, got metric %d twice", rest[0].ID)
+	}
+}
+
+func TestResultModel_Find(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	// checks an hour from now, after the results of the test data
+	at := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+	checks := []struct {
+		rt          time.Duration
+		code        int
+		matched     bool
+		unreachable bool
+	}{
+		{100 * time.Millisecond, 200, true, false},  // up
+		{-time.Millisecond, -1, false, false},       // the site could not be fetched
+		{300 * time.Millisecond, 503, false, false}, // an error status code
+		{2 * time.Second, 200, false, false},        // the pattern did not match
+		{-time.Millisecond, -1, false, true},        // a parent site was down
+		{3 * time.Second, 404, false, false},        // an error status code
+	}
+	ids := make([]int, len(checks))
+	for i, c := range checks {
+		id, err := r.Insert(1, at.Add(time.Duration(i)*time.Minute), models.Period(c.rt), c.code, c.matched, false, c.unreachable, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+
+	matched := true
+	tests := []struct {
+		name    string
+		filter  models.ResultFilter
+		wantIDs []int
+	}{
+		{name: "Time range", filter: models.ResultFilter{From: at.Add(time.Minute), To: at.Add(3 * time.Minute)}, wantIDs: []int{ids[2], ids[1]}},
+		{name: "Ascending", filter: models.ResultFilter{From: at, Ascending: true}, wantIDs: ids},
+		{name: "Status codes", filter: models.ResultFilter{From: at, Codes: []int{404}, Classes: []int{5}}, wantIDs: []int{ids[5], ids[2]}},
+		{name: "Matched", filter: models.ResultFilter{From: at, Matched: &matched}, wantIDs: []int{ids[0]}},
+		{name: "Any failure", filter: models.ResultFilter{From: at, Failure: models.FailureAny}, wantIDs: []int{ids[5], ids[4], ids[3], ids[2], ids[1]}},
+		{name: "Errors", filter: models.ResultFilter{From: at, Failure: models.FailureError}, wantIDs: []int{ids[1]}},
+		{name: "Error status codes", filter: models.ResultFilter{From: at, Failure: models.FailureStatus}, wantIDs: []int{ids[5], ids[2]}},
+		{name: "Content mismatches", filter: models.ResultFilter{From: at, Failure: models.FailureContent}, wantIDs: []int{ids[3]}},
+		{name: "Unreachable", filter: models.ResultFilter{From: at, Failure: models.FailureUnreachable}, wantIDs: []int{ids[4]}},
+		{name: "Slow", filter: models.ResultFilter{From: at, MinResponseTime: models.Period(time.Second)}, wantIDs: []int{ids[5], ids[3]}},
+		{name: "No matches", filter: models.ResultFilter{From: at.Add(time.Hour)}, wantIDs: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, next, err := r.Find(1, tt.filter, models.Page{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]int, 0)
+			for _, res := range results {
+				got = append(got, res.ID)
+			}
+			if !reflect.DeepEqual(got, tt.wantIDs) || next != nil {
+				t.Errorf("want %v, got %v (next %v)", tt.wantIDs, got, next)
+			}
+		})
+	}
+
+	// pages follow the order of the filter
+	got := make([]int, 0)
+	page := models.Page{Limit: 4}
+	for {
+		results, next, err := r.Find(1, models.ResultFilter{From: at, Ascending: true}, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range results {
+			got = append(got, res.ID)
+		}
+		if next == nil {
+			break
+		}
+		page.After = next
+	}
+	if !reflect.DeepEqual(got, ids) {
+		t.Errorf("want %v, got %v", ids, got)
+	}
+}
+
+func TestResultModel_GetLatest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := ResultModel{DB: db}
+	results, err := r.GetLatest([]int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one result for each site that has been checked
+	if len(results) != 2 {
+		t.Errorf("want 2 metrics, got %d", len(results))
+	}
+}
+
 func equals(r1, r2 *models.CheckResult) bool {
 	if r1 == nil || r2 == nil {
 		return r1 == r2
@@ -137,8 +397,47 @@ func equals(r1, r2 *models.CheckResult) bool {
 		r1.ResponseTime != r2.ResponseTime ||
 		r1.ResponseCode != r2.ResponseCode ||
 		r1.SiteID != r2.SiteID ||
-		r1.MatchedPattern != r2.MatchedPattern {
+		r1.MatchedPattern != r2.MatchedPattern ||
+		r1.Maintenance != r2.Maintenance ||
+		r1.Unreachable != r2.Unreachable {
 		return false
 	}
 	return true
 }
+
+func TestResultModel_Export(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	// more results than are fetched from the cursor at a time
+	at := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+	batch := make([]*models.CheckResult, 0, exportFetchSize+10)
+	for i := 0; i < exportFetchSize+10; i++ {
+		batch = append(batch, &models.CheckResult{SiteID: 1, At: at.Add(time.Duration(i) * time.Second), ResponseTime: models.Period(time.Millisecond), ResponseCode: 200, MatchedPattern: true})
+	}
+	if err := r.InsertBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	var last time.Time
+	err := r.Export(1, models.ResultFilter{From: at, Ascending: true}, func(res *models.CheckResult) error {
+		if res.At.Before(last) {
+			t.Errorf("want results in order, got %v after %v", res.At, last)
+		}
+		last = res.At
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(batch) {
+		t.Errorf("want %d results exported, got %d", len(batch), n)
+	}
+}
@@ -0,0 +1,230 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"time"
+)
+
+type RollupModel struct {
+	DB *sql.DB
+}
+
+// rollupTables maps each resolution to the table holding its aggregates
+var rollupTables = map[string]string{
+	models.ResolutionHour: "results_hourly",
+	models.ResolutionDay:  "results_daily",
+}
+
+// rollupLookback is how far before the latest aggregate results are rolled up again, so that results published
+// late, such as those spooled during an outage, are still counted. It must stay well within models.MinRetention
+var rollupLookback = map[string]time.Duration{
+	models.ResolutionHour: 24 * time.Hour,
+	models.ResolutionDay:  24 * time.Hour,
+}
+
+// bucketOf truncates check times to the start of their hour or day in UTC
+func bucketOf(resolution string) string {
+	return fmt.Sprintf(`date_trunc('%s', checked_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'`, resolution)
+}
+
+// aggregateQuery aggregates the results of sites matching the where clause, checked between the $1 and $2 times,
+// into buckets of the given resolution
+func aggregateQuery(resolution, where string) string {
+	return `WITH r AS (
+			SELECT site_id, ` + bucketOf(resolution) + ` AS bucket, response_time, COALESCE(result, 0) AS result,
+				maintenance OR unreachable AS excluded,
+				NOT (COALESCE(result, 0) BETWEEN 200 AND 399 AND matched) AS failed
+			FROM results WHERE checked_at >= $1 AND checked_at < $2 ` + where + `
+		), codes AS (
+			SELECT site_id, bucket, jsonb_object_agg(result::text, n) AS status_codes
+			FROM (SELECT site_id, bucket, result, COUNT(*) AS n FROM r GROUP BY site_id, bucket, result) c
+			GROUP BY site_id, bucket
+		)
+		SELECT r.site_id, r.bucket, COUNT(*),
+			COUNT(*) FILTER (WHERE failed AND NOT excluded),
+			COUNT(*) FILTER (WHERE excluded),
+			MIN(response_time) FILTER (WHERE result > 0),
+			AVG(response_time) FILTER (WHERE result > 0),
+			percentile_cont(0.95) WITHIN GROUP (ORDER BY response_time) FILTER (WHERE result > 0),
+			MAX(response_time) FILTER (WHERE result > 0),
+			codes.status_codes
+		FROM r JOIN codes ON r.site_id = codes.site_id AND r.bucket = codes.bucket
+		GROUP BY r.site_id, r.bucket, codes.status_codes`
+}
+
+const rollupColumns = `site_id, bucket, checks, failures, excluded, min_response_time, avg_response_time,
+	p95_response_time, max_response_time, status_codes`
+
+// Rollup aggregates the results checked before the given time into hourly or daily aggregates, and returns the
+// number of aggregates written. Only complete hours or days are rolled up, starting a while before the latest
+// aggregate, so aggregates are updated with results that were stored late
+func (m *RollupModel) Rollup(resolution string, until time.Time) (int, error) {
+	table, ok := rollupTables[resolution]
+	if !ok {
+		return 0, fmt.Errorf("rollups: unknown resolution %q", resolution)
+	}
+	var latest sql.NullTime
+	if err := m.DB.QueryRow(`SELECT MAX(bucket) FROM ` + table).Scan(&latest); err != nil {
+		return 0, err
+	}
+	from := time.Time{}
+	if latest.Valid {
+		from = latest.Time.Add(-rollupLookback[resolution])
+	}
+	until = until.UTC().Truncate(time.Hour)
+	if resolution == models.ResolutionDay {
+		until = time.Date(until.Year(), until.Month(), until.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	stmt := `INSERT INTO ` + table + ` (` + rollupColumns + `) ` + aggregateQuery(resolution, "") + `
+		ON CONFLICT (site_id, bucket) DO UPDATE SET checks = EXCLUDED.checks, failures = EXCLUDED.failures,
+			excluded = EXCLUDED.excluded, min_response_time = EXCLUDED.min_response_time,
+			avg_response_time = EXCLUDED.avg_response_time, p95_response_time = EXCLUDED.p95_response_time,
+			max_response_time = EXCLUDED.max_response_time, status_codes = EXCLUDED.status_codes`
+	res, err := m.DB.Exec(stmt, from, until)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Prune deletes the raw results checked longer than their retention period before the given time, and returns
+// the number of results deleted. The retention of a site overrides the default retention, and results are kept
+// forever if neither is set. Partitions past the longest retention of any site can be dropped as a whole beforehand,
+// which leaves fewer results to delete here
+func (m *RollupModel) Prune(retention time.Duration, now time.Time) (int, error) {
+	stmt := `DELETE FROM results r USING sites s
+		WHERE r.site_id = s.id AND COALESCE(NULLIF(s.retention, 0), $2) > 0
+			AND r.checked_at < $1 - make_interval(secs => COALESCE(NULLIF(s.retention, 0), $2))`
+	res, err := m.DB.Exec(stmt, now, int(retention.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// PruneRollups deletes the aggregates of the given resolution for buckets before the given time, and returns the
+// number of aggregates deleted
+func (m *RollupModel) PruneRollups(resolution string, before time.Time) (int, error) {
+	table, ok := rollupTables[resolution]
+	if !ok {
+		return 0, fmt.Errorf("rollups: unknown resolution %q", resolution)
+	}
+	res, err := m.DB.Exec(`DELETE FROM `+table+` WHERE bucket < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Get fetches the hourly or daily aggregates of a site for the buckets between from and to, in order
+func (m *RollupModel) Get(siteID int, resolution string, from, to time.Time) ([]*models.Rollup, error) {
+	table, ok := rollupTables[resolution]
+	if !ok {
+		return nil, fmt.Errorf("rollups: unknown resolution %q", resolution)
+	}
+	stmt := `SELECT ` + rollupColumns + ` FROM ` + table + `
+		WHERE site_id = $3 AND bucket >= $1 AND bucket < $2 ORDER BY bucket`
+	return scanRollups(m.DB.Query(stmt, from, to, siteID))
+}
+
+// Aggregate aggregates the raw results of a site checked between from and to into hourly or daily buckets,
+// in order, as they would be rolled up
+func (m *RollupModel) Aggregate(siteID int, resolution string, from, to time.Time) ([]*models.Rollup, error) {
+	if _, ok := rollupTables[resolution]; !ok {
+		return nil, fmt.Errorf("rollups: unknown resolution %q", resolution)
+	}
+	stmt := aggregateQuery(resolution, "AND site_id = $3") + ` ORDER BY r.bucket`
+	return scanRollups(m.DB.Query(stmt, from, to, siteID))
+}
+
+// Stats summarises the raw results of a site for each step of the window, in order, followed by a summary of the
+// whole window. Steps without any results are included with no checks, so that gaps in monitoring are visible
+func (m *RollupModel) Stats(siteID int, window models.StatsWindow) ([]*models.Stats, *models.Stats, error) {
+	stmt := `WITH r AS (
+			SELECT floor(extract(epoch FROM checked_at - $2::timestamptz) / $4::float8)::int AS n, response_time,
+				COALESCE(result, 0) > 0 AS responded,
+				maintenance OR unreachable AS excluded,
+				NOT (COALESCE(result, 0) BETWEEN 200 AND 399 AND matched) AS failed
+			FROM results WHERE site_id = $1 AND checked_at >= $2 AND checked_at < $3
+		), s AS (
+			SELECT b.n, COUNT(r.n) FILTER (WHERE NOT excluded) AS checks,
+				COUNT(r.n) FILTER (WHERE failed AND NOT excluded) AS failures,
+				COUNT(r.n) FILTER (WHERE excluded) AS excluded,
+				percentile_cont(0.5) WITHIN GROUP (ORDER BY response_time) FILTER (WHERE responded AND NOT excluded) AS p50,
+				percentile_cont(0.9) WITHIN GROUP (ORDER BY response_time) FILTER (WHERE responded AND NOT excluded) AS p90,
+				percentile_cont(0.99) WITHIN GROUP (ORDER BY response_time) FILTER (WHERE responded AND NOT excluded) AS p99
+			FROM generate_series(0, $5::int - 1) AS b(n) LEFT JOIN r ON r.n = b.n
+			GROUP BY GROUPING SETS ((b.n), ())
+		)
+		SELECT n, checks, failures, excluded, 100.0 * (checks - failures) / NULLIF(checks, 0), p50, p90, p99
+		FROM s ORDER BY n NULLS LAST`
+	rows, err := m.DB.Query(stmt, siteID, window.From, window.To, window.Step.Seconds(), window.Buckets())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	buckets := make([]*models.Stats, 0, window.Buckets())
+	var total *models.Stats
+	for rows.Next() {
+		st := &models.Stats{}
+		var n sql.NullInt64
+		var uptime, p50, p90, p99 sql.NullFloat64
+		if err := rows.Scan(&n, &st.Checks, &st.Failures, &st.Excluded, &uptime, &p50, &p90, &p99); err != nil {
+			return nil, nil, err
+		}
+		if uptime.Valid {
+			st.Uptime = &uptime.Float64
+		}
+		st.P50ResponseTime, st.P90ResponseTime, st.P99ResponseTime = millis(p50), millis(p90), millis(p99)
+		if !n.Valid {
+			// the grand total of the grouping sets covers the whole window
+			st.Bucket = window.From
+			total = st
+			continue
+		}
+		st.Bucket = window.From.Add(time.Duration(n.Int64) * window.Step)
+		buckets = append(buckets, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return buckets, total, nil
+}
+
+func scanRollups(rows *sql.Rows, err error) ([]*models.Rollup, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rollups := make([]*models.Rollup, 0)
+	for rows.Next() {
+		r := &models.Rollup{}
+		var min, avg, p95, max sql.NullFloat64
+		var codes []byte
+		if err := rows.Scan(&r.SiteID, &r.Bucket, &r.Checks, &r.Failures, &r.Excluded, &min, &avg, &p95, &max, &codes); err != nil {
+			return nil, err
+		}
+		r.MinResponseTime, r.AvgResponseTime = millis(min), millis(avg)
+		r.P95ResponseTime, r.MaxResponseTime = millis(p95), millis(max)
+		if err := json.Unmarshal(codes, &r.StatusCodes); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, rows.Err()
+}
+
+// millis converts a response time in milliseconds, zero if there were no responses
+func millis(ms sql.NullFloat64) models.Period {
+	if !ms.Valid {
+		return 0
+	}
+	return models.Period(time.Duration(ms.Float64 * float64(time.Millisecond)))
+}
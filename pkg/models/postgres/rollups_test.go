@@ -0,0 +1,250 @@
+package postgres
+
+import (
+	"github.com/dnataraj/healthbee/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestRollupModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+	results := &ResultModel{DB: db}
+	rollups := &RollupModel{DB: db}
+
+	// results for site 1 within a complete hour, five days ago
+	hour := time.Now().UTC().Add(-5 * 24 * time.Hour).Truncate(time.Hour)
+	checks := []struct {
+		code        int
+		ms          int
+		matched     bool
+		unreachable bool
+	}{
+		{200, 100, true, false},
+		{200, 300, true, false},
+		{503, 500, false, false},
+		{0, 0, false, true},
+	}
+	for i, c := range checks {
+		at := hour.Add(time.Duration(i) * time.Minute)
+		rt := models.Period(time.Duration(c.ms) * time.Millisecond)
+		if _, err := results.Insert(1, at, rt, c.code, c.matched, false, c.unreachable, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := rollups.Rollup(models.ResolutionHour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatal("want hourly aggregates written, got none")
+	}
+	hourly, err := rollups.Get(1, models.ResolutionHour, hour, hour.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hourly) != 1 {
+		t.Fatalf("want 1 hourly aggregate, got %d", len(hourly))
+	}
+	r := hourly[0]
+	if r.Checks != 4 || r.Failures != 1 || r.Excluded != 1 {
+		t.Errorf("want 4 checks, 1 failure and 1 excluded, got %d, %d and %d", r.Checks, r.Failures, r.Excluded)
+	}
+	if r.MinResponseTime != models.Period(100*time.Millisecond) || r.MaxResponseTime != models.Period(500*time.Millisecond) {
+		t.Errorf("want response times between 100ms and 500ms, got %v and %v", r.MinResponseTime, r.MaxResponseTime)
+	}
+	if r.AvgResponseTime != models.Period(300*time.Millisecond) {
+		t.Errorf("want an average response time of 300ms, got %v", r.AvgResponseTime)
+	}
+	if r.StatusCodes["200"] != 2 || r.StatusCodes["503"] != 1 || r.StatusCodes["0"] != 1 {
+		t.Errorf("want status codes counted, got %v", r.StatusCodes)
+	}
+
+	// aggregating the raw results gives the same aggregate
+	raw, err := rollups.Aggregate(1, models.ResolutionHour, hour, hour.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 1 || raw[0].Checks != r.Checks || raw[0].Failures != r.Failures {
+		t.Errorf("want raw results aggregated as %+v, got %+v", r, raw)
+	}
+
+	// rolling up again updates the same aggregates
+	if _, err := rollups.Rollup(models.ResolutionHour, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rollups.Rollup(models.ResolutionDay, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(hour.Year(), hour.Month(), hour.Day(), 0, 0, 0, 0, time.UTC)
+	daily, err := rollups.Get(1, models.ResolutionDay, day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(daily) != 1 || daily[0].Checks != 4 {
+		t.Errorf("want 1 daily aggregate of 4 checks, got %+v", daily)
+	}
+
+	// site 2 keeps its results for a week, while site 1 follows the default of 3 days
+	sites := &SiteModel{DB: db}
+	if err := sites.SetRetention(2, models.Period(7*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := results.Insert(2, hour, 0, 200, true, false, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	n, err = rollups.Prune(72*time.Hour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(checks) {
+		t.Errorf("want %d results pruned, got %d", len(checks), n)
+	}
+	if n, _ := rollups.Prune(0, time.Now()); n != 0 {
+		t.Errorf("want no results pruned without a retention, got %d", n)
+	}
+	// the aggregates outlive the results
+	hourly, _ = rollups.Get(1, models.ResolutionHour, hour, hour.Add(time.Hour))
+	if len(hourly) != 1 {
+		t.Errorf("want the hourly aggregate kept, got %d", len(hourly))
+	}
+
+	n, err = rollups.PruneRollups(models.ResolutionHour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Error("want hourly aggregates pruned, got none")
+	}
+	if _, err := rollups.Rollup("minute", time.Now()); err == nil {
+		t.Error("want an error for an unknown resolution, got none")
+	}
+}
+
+func TestRollupModel_Prune(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	const day = 24 * time.Hour
+	tests := []struct {
+		name      string
+		retention time.Duration
+		// overrides are the retentions of sites 1 and 2
+		overrides [2]time.Duration
+		// want are the numbers of results of sites 1 and 2 that are left, out of three
+		want [2]int
+	}{
+		{name: "Without a default retention", retention: 0, overrides: [2]time.Duration{3 * day, 0}, want: [2]int{1, 3}},
+		{name: "Default longer than overrides", retention: 7 * day, overrides: [2]time.Duration{3 * day, 0}, want: [2]int{1, 2}},
+		{name: "Default shorter than overrides", retention: 3 * day, overrides: [2]time.Duration{7 * day, 0}, want: [2]int{2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+			results := &ResultModel{DB: db}
+			rollups := &RollupModel{DB: db}
+			sites := &SiteModel{DB: db}
+
+			// results of an hour, four days and ten days ago, apart from those of the test data
+			now := time.Now()
+			if _, err := db.Exec(`DELETE FROM results`); err != nil {
+				t.Fatal(err)
+			}
+			for i, override := range tt.overrides {
+				if err := sites.SetRetention(i+1, models.Period(override)); err != nil {
+					t.Fatal(err)
+				}
+				for _, age := range []time.Duration{time.Hour, 4 * day, 10 * day} {
+					if _, err := results.Insert(i+1, now.Add(-age), 0, 200, true, false, false, ""); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if _, err := rollups.Prune(tt.retention, now); err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range tt.want {
+				var n int
+				if err := db.QueryRow(`SELECT COUNT(*) FROM results WHERE site_id = $1`, i+1).Scan(&n); err != nil {
+					t.Fatal(err)
+				}
+				if n != want {
+					t.Errorf("want %d results left for site %d, got %d", want, i+1, n)
+				}
+			}
+		})
+	}
+}
+
+func TestRollupModel_Stats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+	results := &ResultModel{DB: db}
+	rollups := &RollupModel{DB: db}
+
+	// results for site 1 in the first of three hours, two days ago
+	window, err := models.ParseStatsWindow("3h", "1h", time.Now().Add(-48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		code        int
+		ms          int
+		matched     bool
+		maintenance bool
+		unreachable bool
+	}{
+		{200, 100, true, false, false},
+		{200, 200, true, false, false},
+		{200, 300, true, false, false},
+		{503, 400, false, false, false},
+		{503, 2000, false, true, false},
+		{-1, -1, false, false, true},
+	}
+	for i, c := range checks {
+		at := window.From.Add(time.Duration(i) * time.Minute)
+		rt := models.Period(time.Duration(c.ms) * time.Millisecond)
+		if _, err := results.Insert(1, at, rt, c.code, c.matched, c.maintenance, c.unreachable, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buckets, total, err := rollups.Stats(1, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 3 || total == nil {
+		t.Fatalf("want 3 buckets and a total, got %d buckets and %v", len(buckets), total)
+	}
+	first := buckets[0]
+	if !first.Bucket.Equal(window.From) || first.Checks != 4 || first.Failures != 1 || first.Excluded != 2 {
+		t.Errorf("want 4 checks, 1 failure and 2 excluded from %v, got %+v", window.From, first)
+	}
+	if first.Uptime == nil || *first.Uptime != 75 {
+		t.Errorf("want an uptime of 75%%, got %v", first.Uptime)
+	}
+	// the maintenance check is left out of the percentiles
+	if first.P50ResponseTime != models.Period(250*time.Millisecond) || first.P99ResponseTime > models.Period(400*time.Millisecond) {
+		t.Errorf("want a median of 250ms and p99 of up to 400ms, got %v and %v", first.P50ResponseTime, first.P99ResponseTime)
+	}
+	// steps without results are included, without an uptime
+	if buckets[1].Checks != 0 || buckets[1].Uptime != nil || !buckets[2].Bucket.Equal(window.From.Add(2*time.Hour)) {
+		t.Errorf("want empty buckets for the later hours, got %+v and %+v", buckets[1], buckets[2])
+	}
+	if total.Checks != first.Checks || total.Failures != first.Failures || total.P50ResponseTime != first.P50ResponseTime {
+		t.Errorf("want the total to match the only bucket with results, got %+v", total)
+	}
+}
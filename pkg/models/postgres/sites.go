@@ -2,9 +2,12 @@ package postgres
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/dnataraj/healthbee/pkg/models"
 	"github.com/lib/pq"
+	"strings"
 	"time"
 )
 
@@ -12,6 +15,36 @@ type SiteModel struct {
 	DB *sql.DB
 }
 
+// siteColumns selects a site along with the IDs of the sites it depends on, as an array, and its labels
+const siteColumns = `id, kind, url, period, grace, COALESCE(token, ''), pattern, created, labels, retention, paused,
+	ARRAY(SELECT parent_id FROM site_dependencies d WHERE d.site_id = sites.id ORDER BY parent_id)`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSite(row scanner) (*models.Site, error) {
+	site := &models.Site{}
+	// We handle the interval separately here to maintain its unit (i.e. seconds)
+	var p, g, rt int
+	var labels []byte
+	var parents []int64
+	if err := row.Scan(&site.ID, &site.Type, &site.URL, &p, &g, &site.Token, &site.Pattern, &site.Created, &labels, &rt, &site.Paused, pq.Array(&parents)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(labels, &site.Labels); err != nil {
+		return nil, err
+	}
+	if len(site.Labels) == 0 {
+		site.Labels = nil
+	}
+	site.Interval = models.Period(time.Duration(p) * time.Second)
+	site.Grace = models.Period(time.Duration(g) * time.Second)
+	site.Retention = models.Period(time.Duration(rt) * time.Second)
+	site.Parents = toInts(parents)
+	return site, nil
+}
+
 // Insert adds an entry to the Sites table
 func (s *SiteModel) Insert(URL string, interval models.Period, pattern string) (int, error) {
 	var siteID int
@@ -28,42 +61,361 @@ func (s *SiteModel) Insert(URL string, interval models.Period, pattern string) (
 	return siteID, nil
 }
 
+// cycleCheck follows the parents of the given sites, and reports whether any of them leads back to the site itself
+const cycleCheck = `WITH RECURSIVE ancestors (site_id, parent_id) AS (
+		SELECT site_id, parent_id FROM site_dependencies WHERE site_id = ANY($1::int[])
+		UNION
+		SELECT a.site_id, d.parent_id FROM ancestors a JOIN site_dependencies d ON d.site_id = a.parent_id
+	)
+	SELECT EXISTS (SELECT 1 FROM ancestors WHERE site_id = parent_id)`
+
+// InsertBatch adds a batch of sites to the Sites table, along with their labels, retention and whether they are
+// paused, using multi-row INSERT statements in a single transaction. The ID and creation time of every site that is
+// added are set, while sites whose URL is already registered are skipped and keep an ID of 0.
+// The parents of the sites are added in the same transaction, and unknown parents fail the batch with
+// models.ErrInvalidDependency. New sites may still depend on themselves or on each other by their IDs, so parents
+// forming a cycle fail the batch with models.ErrDependencyCycle
+func (s *SiteModel) InsertBatch(sites []*models.Site) error {
+	if len(sites) == 0 {
+		return nil
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := make(map[string]int)
+	created := time.Now()
+	for start := 0; start < len(sites); start += batchSize {
+		end := start + batchSize
+		if end > len(sites) {
+			end = len(sites)
+		}
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, 10*(end-start))
+		for i, site := range sites[start:end] {
+			kind := site.Type
+			if kind == "" {
+				kind = models.SiteHTTP
+			}
+			labels := site.Labels
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			data, err := json.Marshal(labels)
+			if err != nil {
+				return err
+			}
+			n := 10 * i
+			values = append(values, fmt.Sprintf("(md5($%d), $%d, $%d, $%d, $%d, NULLIF($%d, ''), $%d, $%d, $%d, $%d, $%d)",
+				n+1, n+2, n+1, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10))
+			args = append(args, site.URL, kind, site.Interval.Duration().Seconds(), site.Grace.Duration().Seconds(), site.Token,
+				site.Pattern, string(data), int(site.Retention.Duration().Seconds()), site.Paused, created)
+		}
+		stmt := `INSERT INTO sites (site_hash, kind, url, period, grace, token, pattern, labels, retention, paused, created) VALUES ` +
+			strings.Join(values, ", ") + ` ON CONFLICT DO NOTHING RETURNING id, url`
+		rows, err := tx.Query(stmt, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			var url string
+			if err := rows.Scan(&id, &url); err != nil {
+				rows.Close()
+				return err
+			}
+			ids[url] = id
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	added := make([]int, len(sites))
+	var children, parents []int64
+	for i, site := range sites {
+		// a URL repeated in the batch is only added once
+		if id, ok := ids[site.URL]; ok {
+			added[i] = id
+			delete(ids, site.URL)
+			for _, p := range site.Parents {
+				children, parents = append(children, int64(id)), append(parents, int64(p))
+			}
+		}
+	}
+	if len(parents) > 0 {
+		stmt := `INSERT INTO site_dependencies (site_id, parent_id) SELECT * FROM unnest($1::int[], $2::int[]) ON CONFLICT DO NOTHING`
+		if _, err := tx.Exec(stmt, pq.Array(children), pq.Array(parents)); err != nil {
+			if perr, ok := err.(*pq.Error); ok && perr.Code == foreignKeyViolation {
+				return models.ErrInvalidDependency
+			}
+			return err
+		}
+		var cycle bool
+		if err := tx.QueryRow(cycleCheck, pq.Array(children)).Scan(&cycle); err != nil {
+			return err
+		}
+		if cycle {
+			return models.ErrDependencyCycle
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for i, site := range sites {
+		if added[i] != 0 {
+			site.ID, site.Created = added[i], created
+		}
+	}
+	return nil
+}
+
+// InsertHeartbeat adds a heartbeat site to the Sites table
+// Heartbeat sites are identified by their token, and their URL is the path they are expected to ping
+func (s *SiteModel) InsertHeartbeat(token string, interval, grace models.Period) (int, error) {
+	var siteID int
+	stmt := `INSERT INTO sites (site_hash, kind, url, period, grace, token, pattern, created) VALUES (md5($1), $2, $1, $3, $4, $5, '', $6) RETURNING id`
+	err := s.DB.QueryRow(stmt, "/heartbeat/"+token, models.SiteHeartbeat, interval.Duration().Seconds(), grace.Duration().Seconds(), token, time.Now()).Scan(&siteID)
+	if err != nil {
+		if perr, ok := err.(*pq.Error); ok {
+			if perr.Code == uniquenessViolation {
+				return -1, models.ErrDuplicateSite
+			}
+		}
+		return -1, err
+	}
+	return siteID, nil
+}
+
+// GetByToken fetches a heartbeat site given its token
+func (s *SiteModel) GetByToken(token string) (*models.Site, error) {
+	stmt := `SELECT ` + siteColumns + ` FROM sites WHERE token = $1`
+	site, err := scanSite(s.DB.QueryRow(stmt, token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	return site, nil
+}
+
 // Get fetches a registered Site from the Site table
 func (s *SiteModel) Get(id int) (*models.Site, error) {
-	site := &models.Site{}
-	// We handle the interval separately here to maintain its unit (i.e. seconds)
-	var p int
-	stmt := `SELECT id, url, period, pattern, created FROM sites WHERE id = $1`
-	err := s.DB.QueryRow(stmt, id).Scan(&site.ID, &site.URL, &p, &site.Pattern, &site.Created)
+	stmt := `SELECT ` + siteColumns + ` FROM sites WHERE id = $1`
+	site, err := scanSite(s.DB.QueryRow(stmt, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, models.ErrNoRecord
 		}
 		return nil, err
 	}
-	site.Interval = models.Period(time.Duration(p) * time.Second)
 	return site, nil
 }
 
-// GetAll fetches the latest 20 registered sites from the site table
-func (s *SiteModel) GetAll() ([]*models.Site, error) {
+// GetAll fetches a page of registered sites matching the label selector from the site table, newest first.
+// The cursor of the next page is returned if there are more sites
+func (s *SiteModel) GetAll(sel models.Selector, page models.Page) ([]*models.Site, *models.Cursor, error) {
 	sites := make([]*models.Site, 0)
-	stmt := `SELECT id, url, period, pattern, created FROM sites ORDER BY created DESC LIMIT 20`
-	rows, err := s.DB.Query(stmt)
+	where, args := selectorClause(sel)
+	if page.After != nil {
+		n := len(args) + 1
+		keyset := fmt.Sprintf("(created, id) < ($%d, $%d)", n, n+1)
+		if where == "" {
+			where = "WHERE " + keyset
+		} else {
+			where += " AND " + keyset
+		}
+		args = append(args, page.After.At, page.After.ID)
+	}
+	stmt := fmt.Sprintf(`SELECT `+siteColumns+` FROM sites %s ORDER BY created DESC, id DESC LIMIT %d`, where, page.Size()+1)
+	rows, err := s.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer rows.Close()
+	for rows.Next() {
+		site, err := scanSite(rows)
+		if err != nil {
+			// For now, we'll simple return on any failure rather than serve partials
+			return nil, nil, err
+		}
+		sites = append(sites, site)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(sites) > page.Size() {
+		sites = sites[:page.Size()]
+		last := sites[len(sites)-1]
+		return sites, &models.Cursor{At: last.Created, ID: last.ID}, nil
+	}
+	return sites, nil, nil
+}
+
+// selectorClause translates a label selector into a WHERE clause over the labels column, along with its arguments
+func selectorClause(sel models.Selector) (string, []interface{}) {
+	if len(sel) == 0 {
+		return "", nil
+	}
+	conditions := make([]string, 0, len(sel))
+	args := make([]interface{}, 0, len(sel))
+	for _, r := range sel {
+		n := len(args) + 1
+		switch r.Operator {
+		case models.OpEquals:
+			conditions = append(conditions, fmt.Sprintf("labels @> $%d::jsonb", n))
+			args = append(args, labelJSON(r.Key, r.Value))
+		case models.OpNotEquals:
+			conditions = append(conditions, fmt.Sprintf("NOT labels @> $%d::jsonb", n))
+			args = append(args, labelJSON(r.Key, r.Value))
+		case models.OpExists:
+			conditions = append(conditions, fmt.Sprintf("labels ? $%d", n))
+			args = append(args, r.Key)
+		case models.OpNotExists:
+			conditions = append(conditions, fmt.Sprintf("NOT labels ? $%d", n))
+			args = append(args, r.Key)
+		}
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func labelJSON(key, value string) string {
+	data, _ := json.Marshal(map[string]string{key: value})
+	return string(data)
+}
+
+// SetLabels replaces the labels of a site
+func (s *SiteModel) SetLabels(siteID int, labels map[string]string) error {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.Exec(`UPDATE sites SET labels = $2 WHERE id = $1`, siteID, string(data))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// SetRetention sets how long the raw results of a site are kept for, or resets it to the default retention if zero
+func (s *SiteModel) SetRetention(siteID int, retention models.Period) error {
+	res, err := s.DB.Exec(`UPDATE sites SET retention = $2 WHERE id = $1`, siteID, int(retention.Duration().Seconds()))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// Update changes the monitoring interval and pattern of a site
+func (s *SiteModel) Update(siteID int, interval models.Period, pattern string) error {
+	return s.update(`UPDATE sites SET period = $2, pattern = $3 WHERE id = $1`, siteID, interval.Duration().Seconds(), pattern)
+}
+
+// SetPaused pauses or resumes the monitoring of a site, paused sites are kept along with their results
+func (s *SiteModel) SetPaused(siteID int, paused bool) error {
+	return s.update(`UPDATE sites SET paused = $2 WHERE id = $1`, siteID, paused)
+}
+
+// Delete removes a site, along with its results, aggregates and dependencies
+func (s *SiteModel) Delete(siteID int) error {
+	return s.update(`DELETE FROM sites WHERE id = $1`, siteID)
+}
+
+// update changes a single site, and fails with models.ErrNoRecord if the site is not found
+func (s *SiteModel) update(stmt string, args ...interface{}) error {
+	res, err := s.DB.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// SetParents replaces the sites that a given site depends on
+// Unknown parent sites result in a models.ErrInvalidDependency, cycle detection is left to the caller
+func (s *SiteModel) SetParents(siteID int, parents []int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM site_dependencies WHERE site_id = $1`, siteID); err != nil {
+		return err
+	}
+	for _, p := range parents {
+		_, err := tx.Exec(`INSERT INTO site_dependencies (site_id, parent_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, siteID, p)
+		if err != nil {
+			if perr, ok := err.(*pq.Error); ok && perr.Code == foreignKeyViolation {
+				return models.ErrInvalidDependency
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetDependencies fetches the complete dependency graph, as a map of site IDs to the sites they depend on
+func (s *SiteModel) GetDependencies() (map[int][]int, error) {
+	deps := make(map[int][]int)
+	rows, err := s.DB.Query(`SELECT site_id, parent_id FROM site_dependencies ORDER BY site_id, parent_id`)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		site := &models.Site{}
-		var p int
-		if err := rows.Scan(&site.ID, &site.URL, &p, &site.Pattern, &site.Created); err != nil {
-			// For now, we'll simple return on any failure rather than serve partials
+		var siteID, parentID int
+		if err := rows.Scan(&siteID, &parentID); err != nil {
 			return nil, err
 		}
-		site.Interval = models.Period(time.Duration(p) * time.Second)
-		sites = append(sites, site)
+		deps[siteID] = append(deps[siteID], parentID)
+	}
+	return deps, rows.Err()
+}
+
+func toInts(ids []int64) []int {
+	if len(ids) == 0 {
+		return nil
+	}
+	res := make([]int, len(ids))
+	for i, id := range ids {
+		res[i] = int(id)
 	}
+	return res
+}
 
-	return sites, nil
+func toInt64s(ids []int) []int64 {
+	res := make([]int64, len(ids))
+	for i, id := range ids {
+		res[i] = int64(id)
+	}
+	return res
 }
@@ -1,6 +1,3 @@
-DROP TABLE IF EXISTS sites CASCADE;
-DROP TABLE IF EXISTS results;
-
 CREATE TABLE sites (
     id INT GENERATED ALWAYS AS IDENTITY,
     site_hash TEXT UNIQUE NOT NULL,
@@ -26,4 +23,12 @@ CREATE TABLE results (
             REFERENCES sites(id) ON DELETE CASCADE
 );
 
-CREATE INDEX idx_site_id ON results(site_id)
\ No newline at end of file
+CREATE INDEX idx_site_id ON results(site_id);
+
+INSERT INTO sites(site_hash, url, period, pattern, created)
+    VALUES (md5('https://www.example.com'), 'https://www.example.com', 5, 'content', CURRENT_TIMESTAMP);
+
+INSERT INTO results(site_id, checked_at, response_time, result, matched)
+    VALUES (1, CURRENT_TIMESTAMP, 600, 200, true);
+INSERT INTO results(site_id, checked_at, response_time, result, matched)
+    VALUES (1, CURRENT_TIMESTAMP - INTERVAL '1 day', 200, 200, true);
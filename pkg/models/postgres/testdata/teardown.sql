@@ -1,2 +1,10 @@
 DROP TABLE IF EXISTS sites CASCADE;
-DROP TABLE IF EXISTS results;
\ No newline at end of file
+DROP TABLE IF EXISTS results;
+DROP TABLE IF EXISTS maintenance_windows;
+DROP TABLE IF EXISTS site_dependencies;
+DROP TABLE IF EXISTS groups CASCADE;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS group_results;
+DROP TABLE IF EXISTS schema_migrations;
+DROP TABLE IF EXISTS results_hourly;
+DROP TABLE IF EXISTS results_daily;
\ No newline at end of file
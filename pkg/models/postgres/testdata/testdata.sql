@@ -1,11 +1,26 @@
 INSERT INTO sites(site_hash, url, period, pattern, created)
     VALUES (md5('https://www.example.com'), 'https://www.example.com', 5, 'content', CURRENT_TIMESTAMP);
-INSERT INTO sites(site_hash, url, period, pattern, created)
-    VALUES (md5('https://www.example.org'), 'https://www.example.org', 3, 'content', CURRENT_TIMESTAMP);
+INSERT INTO sites(site_hash, url, period, pattern, labels, created)
+    VALUES (md5('https://www.example.org'), 'https://www.example.org', 3, 'content', '{"team": "payments", "env": "prod"}', CURRENT_TIMESTAMP);
+
+INSERT INTO site_dependencies(site_id, parent_id) VALUES (2, 1);
 
 INSERT INTO results(site_id, checked_at, response_time, result, matched)
     VALUES (1, CURRENT_TIMESTAMP, 600, 200, true);
 INSERT INTO results(site_id, checked_at, response_time, result, matched)
     VALUES (2, CURRENT_TIMESTAMP, 1200, 400, false);
 INSERT INTO results(site_id, checked_at, response_time, result, matched)
-    VALUES (2, CURRENT_TIMESTAMP, 200, 400, false);
+    VALUES (2, CURRENT_TIMESTAMP - INTERVAL '1 minute', 200, 400, false);
+
+
+INSERT INTO maintenance_windows(site_id, starts_at, duration, schedule, reason, created)
+    VALUES (1, CURRENT_TIMESTAMP, 3600, '', 'planned upgrade', CURRENT_TIMESTAMP);
+INSERT INTO maintenance_windows(site_id, starts_at, duration, schedule, reason, created)
+    VALUES (2, CURRENT_TIMESTAMP, 1800, '0 2 * * 0', 'weekly deploy', CURRENT_TIMESTAMP);
+
+INSERT INTO groups(name, rule, quorum, created) VALUES ('example', 'any', 0, CURRENT_TIMESTAMP);
+INSERT INTO group_members(group_id, site_id) VALUES (1, 1);
+INSERT INTO group_members(group_id, site_id) VALUES (1, 2);
+INSERT INTO group_results(group_id, checked_at, status, up, total) VALUES (1, CURRENT_TIMESTAMP, 'up', 1, 2);
+INSERT INTO maintenance_windows(selector, starts_at, duration, schedule, reason, created)
+    VALUES ('team=payments', CURRENT_TIMESTAMP, 600, '', 'payments release', CURRENT_TIMESTAMP);
\ No newline at end of file
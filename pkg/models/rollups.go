@@ -0,0 +1,42 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidRetention = errors.New("sites: invalid results retention")
+
+// MinRetention is the shortest time raw results can be kept for. Results are rolled up again for a while after
+// they are checked, so that results published late are still counted
+const MinRetention = 72 * time.Hour
+
+// ValidateRetention checks a retention period for raw results, where zero keeps them forever
+func ValidateRetention(retention Period) error {
+	if retention != 0 && retention.Duration() < MinRetention {
+		return ErrInvalidRetention
+	}
+	return nil
+}
+
+// Resolutions of the aggregated results
+const (
+	ResolutionHour = "hour"
+	ResolutionDay  = "day"
+)
+
+// Rollup aggregates the results of a site over an hour or a day.
+// Failures do not include checks made during maintenance or while the site was unreachable, which are counted as
+// excluded instead. Response times only cover the checks that got a response, and status codes are counted by code
+type Rollup struct {
+	SiteID          int            `json:"site_id"`
+	Bucket          time.Time      `json:"bucket"`
+	Checks          int            `json:"checks"`
+	Failures        int            `json:"failures"`
+	Excluded        int            `json:"excluded"`
+	MinResponseTime Period         `json:"min_response_time"`
+	AvgResponseTime Period         `json:"avg_response_time"`
+	P95ResponseTime Period         `json:"p95_response_time"`
+	MaxResponseTime Period         `json:"max_response_time"`
+	StatusCodes     map[string]int `json:"status_codes"`
+}
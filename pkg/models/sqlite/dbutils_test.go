@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestDB(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "healthbee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(filepath.Join(dir, "healthbee.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := ioutil.ReadFile("./testdata/testdata.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestOpen_adopt(t *testing.T) {
+	// the schema of databases created before migrations were introduced, before and after sites could be paused
+	schema := `CREATE TABLE sites (id INTEGER PRIMARY KEY AUTOINCREMENT, site_hash TEXT UNIQUE NOT NULL,
+		kind VARCHAR(20) NOT NULL DEFAULT 'http', url VARCHAR(2000) NOT NULL, period INT NOT NULL,
+		grace INT NOT NULL DEFAULT 0, token VARCHAR(64) UNIQUE, pattern VARCHAR(100) NOT NULL,
+		labels TEXT NOT NULL DEFAULT '{}', retention INT NOT NULL DEFAULT 0, %s created TIMESTAMP)`
+	tests := []struct {
+		name        string
+		schema      string
+		wantApplied int
+	}{
+		{name: "Before paused sites", schema: fmt.Sprintf(schema, ""), wantApplied: 1},
+		{name: "With paused sites", schema: fmt.Sprintf(schema, "paused BOOLEAN NOT NULL DEFAULT false,"), wantApplied: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "healthbee")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "healthbee.db")
+			db, err := sql.Open("sqlite3", "file:"+path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.Exec(tt.schema)
+			db.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db, err = Connect(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			m, err := NewMigrator(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			applied, err := m.Up()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(applied) != tt.wantApplied {
+				t.Errorf("want %d migrations applied, got %d", tt.wantApplied, len(applied))
+			}
+			s := &SiteModel{DB: db}
+			id, err := s.Insert("https://www.example.com", models.Period(time.Second), "")
+			if err == nil {
+				err = s.SetPaused(id, true)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
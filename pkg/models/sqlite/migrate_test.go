@@ -0,0 +1,82 @@
+package sqlite
+
+import (
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models/migrate"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("want embedded migrations, got none")
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := m.Migrations[len(m.Migrations)-1]
+
+	// the test database is already migrated
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("want no migrations applied, got %d", len(applied))
+	}
+
+	reverted, err := m.Down()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Version != latest.Version {
+		t.Errorf("want version %d reverted, got %d", latest.Version, reverted.Version)
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(m.Migrations) || statuses[0].Applied == nil || statuses[len(statuses)-1].Applied != nil {
+		t.Errorf("want only version %d pending, got %+v", latest.Version, statuses)
+	}
+	// rebuilding a table keeps the rows referring to it
+	var sites, dependencies, results int
+	err = db.QueryRow(`SELECT (SELECT COUNT(*) FROM sites), (SELECT COUNT(*) FROM site_dependencies),
+		(SELECT COUNT(*) FROM results)`).Scan(&sites, &dependencies, &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sites != 2 || dependencies != 1 || results != 3 {
+		t.Errorf("want 2 sites, 1 dependency and 3 results kept, got %d, %d and %d", sites, dependencies, results)
+	}
+
+	applied, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Version != latest.Version {
+		t.Errorf("want version %d applied again, got %d migrations", latest.Version, len(applied))
+	}
+
+	// reverting every migration leaves nothing to revert
+	for range m.Migrations {
+		if _, err := m.Down(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Down(); !errors.Is(err, migrate.ErrNoMigration) {
+		t.Errorf("want %v, got %v", migrate.ErrNoMigration, err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+}
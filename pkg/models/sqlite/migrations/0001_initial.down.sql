@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS results;
+DROP TABLE IF EXISTS site_dependencies;
+DROP TABLE IF EXISTS sites;
@@ -0,0 +1,35 @@
+-- The schema of the SQLite store, which covers the sites and their results. Times are stored in UTC
+CREATE TABLE IF NOT EXISTS sites (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_hash TEXT UNIQUE NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'http',
+    url VARCHAR(2000) NOT NULL,
+    period INT NOT NULL,
+    grace INT NOT NULL DEFAULT 0,
+    token VARCHAR(64) UNIQUE,
+    pattern VARCHAR(100) NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    retention INT NOT NULL DEFAULT 0,
+    created TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS site_dependencies (
+    site_id INT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    parent_id INT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    PRIMARY KEY(site_id, parent_id)
+);
+
+CREATE TABLE IF NOT EXISTS results (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    checked_at TIMESTAMP,
+    response_time INT,
+    result INT,
+    matched BOOLEAN NOT NULL,
+    maintenance BOOLEAN NOT NULL DEFAULT false,
+    unreachable BOOLEAN NOT NULL DEFAULT false,
+    source TEXT NOT NULL DEFAULT '',
+    UNIQUE (site_id, checked_at, source)
+);
+
+CREATE INDEX IF NOT EXISTS idx_results_site_checked_at ON results(site_id, checked_at);
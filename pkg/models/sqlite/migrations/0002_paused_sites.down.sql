@@ -0,0 +1,19 @@
+-- SQLite cannot drop columns, so the sites table is rebuilt without the paused column. Foreign keys are not enforced
+-- while migrating, so the results and dependencies of the sites are kept
+CREATE TABLE sites_unpaused (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_hash TEXT UNIQUE NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'http',
+    url VARCHAR(2000) NOT NULL,
+    period INT NOT NULL,
+    grace INT NOT NULL DEFAULT 0,
+    token VARCHAR(64) UNIQUE,
+    pattern VARCHAR(100) NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    retention INT NOT NULL DEFAULT 0,
+    created TIMESTAMP
+);
+INSERT INTO sites_unpaused (id, site_hash, kind, url, period, grace, token, pattern, labels, retention, created)
+    SELECT id, site_hash, kind, url, period, grace, token, pattern, labels, retention, created FROM sites;
+DROP TABLE sites;
+ALTER TABLE sites_unpaused RENAME TO sites;
@@ -0,0 +1,264 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/mattn/go-sqlite3"
+	"strings"
+	"time"
+)
+
+type ResultModel struct {
+	DB *sql.DB
+}
+
+const resultColumns = `id, site_id, checked_at, response_time, result, matched, maintenance, unreachable, source`
+
+const insertResult = `INSERT INTO results (site_id, checked_at, response_time, result, matched, maintenance, unreachable, source)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (site_id, checked_at, source) DO NOTHING`
+
+func scanResult(row scanner) (*models.CheckResult, error) {
+	res := &models.CheckResult{}
+	var rt int
+	if err := row.Scan(&res.ID, &res.SiteID, &res.At, &rt, &res.ResponseCode, &res.MatchedPattern, &res.Maintenance, &res.Unreachable, &res.Source); err != nil {
+		return nil, err
+	}
+	res.ResponseTime = models.Period(time.Duration(rt) * time.Millisecond)
+	return res, nil
+}
+
+// Insert adds an availability metric to the Results table
+// Results are unique for a site, check time and source, so inserting a result again returns the ID of the existing one.
+// Check times are stored in UTC, so that they are compared and ordered consistently
+func (r *ResultModel) Insert(siteID int, checkedAt time.Time, responseTime models.Period, code int, matched, maintenance, unreachable bool, source string) (int, error) {
+	checkedAt = checkedAt.UTC()
+	res, err := r.DB.Exec(insertResult, siteID, checkedAt, responseTime.Duration().Milliseconds(), code, matched, maintenance, unreachable, source)
+	if err != nil {
+		if isConstraint(err, sqlite3.ErrConstraintForeignKey) {
+			return -1, models.ErrInvalidResult
+		}
+		return -1, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		var id int
+		stmt := `SELECT id FROM results WHERE site_id = ? AND checked_at = ? AND source = ?`
+		if err := r.DB.QueryRow(stmt, siteID, checkedAt, source).Scan(&id); err != nil {
+			return -1, err
+		}
+		return id, nil
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// InsertBatch adds a batch of availability metrics to the Results table, in a single transaction, so either all
+// or none of the metrics are added.
+// Metrics that were already added are skipped, so that batches can safely be redelivered, while metrics of unknown
+// sites fail the batch with models.ErrInvalidResult
+func (r *ResultModel) InsertBatch(results []*models.CheckResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(insertResult)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, res := range results {
+		_, err := stmt.Exec(res.SiteID, res.At.UTC(), res.ResponseTime.Duration().Milliseconds(), res.ResponseCode,
+			res.MatchedPattern, res.Maintenance, res.Unreachable, res.Source)
+		if err != nil {
+			if isConstraint(err, sqlite3.ErrConstraintForeignKey) {
+				return models.ErrInvalidResult
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Get fetches an availability metric from the Results table given a metric ID
+func (r *ResultModel) Get(id int) (*models.CheckResult, error) {
+	res, err := scanResult(r.DB.QueryRow(`SELECT `+resultColumns+` FROM results WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetResultsForSite fetches a page of site availability metrics for a given Site ID, latest first.
+// The cursor of the next page is returned if there are more metrics, while a site without any metrics is reported
+// with models.ErrNoRecord
+func (r *ResultModel) GetResultsForSite(siteID int, page models.Page) ([]*models.CheckResult, *models.Cursor, error) {
+	metrics, next, err := r.Find(siteID, models.ResultFilter{}, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(metrics) == 0 && page.After == nil {
+		// We did not find the site
+		return nil, nil, models.ErrNoRecord
+	}
+	return metrics, next, nil
+}
+
+// failureConditions select each kind of failed check, following how models.CheckResult reports the status of a check
+var failureConditions = map[string]string{
+	models.FailureAny:         `(unreachable OR NOT (COALESCE(result, 0) BETWEEN 200 AND 399 AND matched))`,
+	models.FailureError:       `(NOT unreachable AND COALESCE(result, 0) <= 0)`,
+	models.FailureStatus:      `(NOT unreachable AND result > 0 AND (result < 200 OR result >= 400))`,
+	models.FailureContent:     `(NOT unreachable AND result BETWEEN 200 AND 399 AND NOT matched)`,
+	models.FailureUnreachable: `unreachable`,
+}
+
+// filterConditions returns the conditions selecting the results of a site that match the filter, along with their
+// arguments
+func filterConditions(siteID int, filter models.ResultFilter) ([]string, []interface{}, error) {
+	conditions := []string{`site_id = ?`}
+	args := []interface{}{siteID}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, `checked_at >= ?`)
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, `checked_at < ?`)
+		args = append(args, filter.To.UTC())
+	}
+	if len(filter.Codes) > 0 || len(filter.Classes) > 0 {
+		codes := make([]string, 0, 2)
+		if len(filter.Codes) > 0 {
+			codes = append(codes, `result IN (?`+strings.Repeat(", ?", len(filter.Codes)-1)+`)`)
+			for _, c := range filter.Codes {
+				args = append(args, c)
+			}
+		}
+		if len(filter.Classes) > 0 {
+			codes = append(codes, `result / 100 IN (?`+strings.Repeat(", ?", len(filter.Classes)-1)+`)`)
+			for _, c := range filter.Classes {
+				args = append(args, c)
+			}
+		}
+		conditions = append(conditions, `(`+strings.Join(codes, " OR ")+`)`)
+	}
+	if filter.Matched != nil {
+		conditions = append(conditions, `matched = ?`)
+		args = append(args, *filter.Matched)
+	}
+	if filter.Failure != "" {
+		cond, ok := failureConditions[filter.Failure]
+		if !ok {
+			return nil, nil, models.ErrInvalidFilter
+		}
+		conditions = append(conditions, cond)
+	}
+	if filter.MinResponseTime > 0 {
+		conditions = append(conditions, `response_time >= ?`)
+		args = append(args, filter.MinResponseTime.Duration().Milliseconds())
+	}
+	return conditions, args, nil
+}
+
+// Find fetches a page of the availability metrics of a site that match the filter, in the order the filter asks for.
+// The cursor of the next page is returned if there are more metrics, while no matching metrics is not an error
+func (r *ResultModel) Find(siteID int, filter models.ResultFilter, page models.Page) ([]*models.CheckResult, *models.Cursor, error) {
+	conditions, args, err := filterConditions(siteID, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	order, after := "DESC", "<"
+	if filter.Ascending {
+		order, after = "ASC", ">"
+	}
+	if page.After != nil {
+		conditions = append(conditions, `(checked_at, id) `+after+` (?, ?)`)
+		args = append(args, page.After.At.UTC(), page.After.ID)
+	}
+	stmt := `SELECT ` + resultColumns + ` FROM results WHERE ` + strings.Join(conditions, " AND ") +
+		` ORDER BY checked_at ` + order + `, id ` + order + ` LIMIT ?`
+	metrics, err := scanResults(r.DB.Query(stmt, append(args, page.Size()+1)...))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(metrics) > page.Size() {
+		metrics = metrics[:page.Size()]
+		last := metrics[len(metrics)-1]
+		return metrics, &models.Cursor{At: last.At, ID: last.ID}, nil
+	}
+	return metrics, nil, nil
+}
+
+// Export streams the availability metrics of a site that match the filter to fn, in the order the filter asks for.
+// Metrics are stepped through as they are read, so that exports of any size are not held in memory, and the export
+// stops at the first error returned by fn
+func (r *ResultModel) Export(siteID int, filter models.ResultFilter, fn func(res *models.CheckResult) error) error {
+	conditions, args, err := filterConditions(siteID, filter)
+	if err != nil {
+		return err
+	}
+	order := "DESC"
+	if filter.Ascending {
+		order = "ASC"
+	}
+	stmt := `SELECT ` + resultColumns + ` FROM results WHERE ` + strings.Join(conditions, " AND ") +
+		` ORDER BY checked_at ` + order + `, id ` + order
+	rows, err := r.DB.Query(stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		res, err := scanResult(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(res); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetLatest fetches the most recent availability metric for each of the given sites
+// Sites without any metrics are left out
+func (r *ResultModel) GetLatest(siteIDs []int) ([]*models.CheckResult, error) {
+	if len(siteIDs) == 0 {
+		return make([]*models.CheckResult, 0), nil
+	}
+	args := make([]interface{}, len(siteIDs))
+	for i, id := range siteIDs {
+		args[i] = id
+	}
+	stmt := `SELECT ` + resultColumns + ` FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY site_id ORDER BY checked_at DESC) AS n
+			FROM results WHERE site_id IN (?` + strings.Repeat(", ?", len(siteIDs)-1) + `)
+		) WHERE n = 1 ORDER BY site_id`
+	return scanResults(r.DB.Query(stmt, args...))
+}
+
+func scanResults(rows *sql.Rows, err error) ([]*models.CheckResult, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	metrics := make([]*models.CheckResult, 0)
+	for rows.Next() {
+		res, err := scanResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, res)
+	}
+	return metrics, rows.Err()
+}
@@ -0,0 +1,286 @@
+package sqlite
+
+import (
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResultModel_Insert(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	// check times are compared in UTC
+	at := time.Now().In(time.FixedZone("CET", 3600))
+	id, err := r.Insert(1, at, models.Period(300*time.Millisecond), 200, true, false, false, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 4 {
+		t.Errorf("want %d, got %d", 4, id)
+	}
+	res, err := r.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.At.Equal(at) || res.ResponseTime != models.Period(300*time.Millisecond) || !res.MatchedPattern {
+		t.Errorf("want the result checked at %v, got %+v", at, res)
+	}
+
+	// a redelivered result is not added again
+	dup, err := r.Insert(1, at.UTC(), models.Period(300*time.Millisecond), 200, true, false, false, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dup != id {
+		t.Errorf("want %d, got %d", id, dup)
+	}
+	// while the same check from another source is
+	other, err := r.Insert(1, at, models.Period(300*time.Millisecond), 200, true, false, false, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == id {
+		t.Errorf("want a new result, got %d", other)
+	}
+	if _, err := r.Insert(10, at, models.Period(300*time.Millisecond), 200, true, false, false, "test"); err != models.ErrInvalidResult {
+		t.Errorf("want %v, got %v", models.ErrInvalidResult, err)
+	}
+	if _, err := r.Get(10); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+}
+
+func TestResultModel_InsertBatch(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	at := time.Now().UTC()
+	batch := make([]*models.CheckResult, 0, 1500)
+	for i := 0; i < 1500; i++ {
+		batch = append(batch, &models.CheckResult{
+			SiteID:         1 + i%2,
+			At:             at.Add(time.Duration(i) * time.Second),
+			ResponseTime:   models.Period(300 * time.Millisecond),
+			ResponseCode:   200,
+			MatchedPattern: true,
+		})
+	}
+	count := func() int {
+		var n int
+		if err := db.QueryRow(`SELECT count(*) FROM results`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if err := r.InsertBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1503 {
+		t.Errorf("want 1503 metrics, got %d", n)
+	}
+
+	// redelivering the batch does not add it again
+	if err := r.InsertBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1503 {
+		t.Errorf("want 1503 metrics, got %d", n)
+	}
+
+	// a batch with an unknown site is rejected as a whole
+	err := r.InsertBatch([]*models.CheckResult{{SiteID: 1, At: at.Add(-time.Hour)}, {SiteID: 10, At: at}})
+	if err != models.ErrInvalidResult {
+		t.Errorf("want %v, got %v", models.ErrInvalidResult, err)
+	}
+	if n := count(); n != 1503 {
+		t.Errorf("want 1503 metrics, got %d", n)
+	}
+}
+
+func TestResultModel_GetResultsForSite(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	results, _, err := r.GetResultsForSite(2, models.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("want 2 metrics, got %d", len(results))
+	}
+	// the latest result comes first
+	if results[0].ResponseTime.Duration().Milliseconds() != 1200 {
+		t.Errorf("want response time 1200, got %d", results[0].ResponseTime.Duration().Milliseconds())
+	}
+	if _, _, err := r.GetResultsForSite(3, models.Page{}); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+}
+
+func TestResultModel_GetLatest(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	results, err := r.GetLatest([]int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one result for each site that has been checked
+	if len(results) != 2 || results[1].SiteID != 2 || results[1].ResponseTime.Duration().Milliseconds() != 1200 {
+		t.Errorf("want the latest result of sites 1 and 2, got %d metrics", len(results))
+	}
+	if results, err := r.GetLatest(nil); err != nil || len(results) != 0 {
+		t.Errorf("want no metrics, got %d (%v)", len(results), err)
+	}
+}
+
+func TestResultModel_GetResultsForSite_pages(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	// results checked at the same time are ordered by their IDs
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, source := range []string{"a", "b", "c"} {
+		if _, err := r.Insert(2, at, models.Period(100*time.Millisecond), 200, true, false, false, source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := make([]int, 0)
+	page := models.Page{Limit: 2}
+	for {
+		results, next, err := r.GetResultsForSite(2, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range results {
+			ids = append(ids, res.ID)
+		}
+		if next == nil {
+			break
+		}
+		page.After = next
+	}
+	if want := []int{6, 5, 4, 2, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("want %v, got %v", want, ids)
+	}
+}
+
+func TestResultModel_Find(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	at := time.Date(2021, 3, 2, 3, 0, 0, 0, time.UTC)
+	checks := []struct {
+		rt          time.Duration
+		code        int
+		matched     bool
+		unreachable bool
+	}{
+		{100 * time.Millisecond, 200, true, false},  // 4: up
+		{-time.Millisecond, -1, false, false},       // 5: the site could not be fetched
+		{300 * time.Millisecond, 503, false, false}, // 6: an error status code
+		{2 * time.Second, 200, false, false},        // 7: the pattern did not match
+		{-time.Millisecond, -1, false, true},        // 8: a parent site was down
+		{3 * time.Second, 404, false, false},        // 9: an error status code
+	}
+	for i, c := range checks {
+		if _, err := r.Insert(1, at.Add(time.Duration(i)*time.Minute), models.Period(c.rt), c.code, c.matched, false, c.unreachable, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matched := true
+	tests := []struct {
+		name    string
+		filter  models.ResultFilter
+		wantIDs []int
+	}{
+		{name: "All", wantIDs: []int{9, 8, 7, 6, 5, 4, 1}},
+		{name: "Time range", filter: models.ResultFilter{From: at.Add(time.Minute), To: at.Add(3 * time.Minute)}, wantIDs: []int{6, 5}},
+		{name: "Ascending", filter: models.ResultFilter{From: at, Ascending: true}, wantIDs: []int{4, 5, 6, 7, 8, 9}},
+		{name: "Status codes", filter: models.ResultFilter{Codes: []int{404}, Classes: []int{5}}, wantIDs: []int{9, 6}},
+		{name: "Status class", filter: models.ResultFilter{Classes: []int{2}}, wantIDs: []int{7, 4, 1}},
+		{name: "Matched", filter: models.ResultFilter{Matched: &matched}, wantIDs: []int{4, 1}},
+		{name: "Any failure", filter: models.ResultFilter{From: at, Failure: models.FailureAny}, wantIDs: []int{9, 8, 7, 6, 5}},
+		{name: "Errors", filter: models.ResultFilter{Failure: models.FailureError}, wantIDs: []int{5}},
+		{name: "Error status codes", filter: models.ResultFilter{From: at, Failure: models.FailureStatus}, wantIDs: []int{9, 6}},
+		{name: "Content mismatches", filter: models.ResultFilter{Failure: models.FailureContent}, wantIDs: []int{7}},
+		{name: "Unreachable", filter: models.ResultFilter{Failure: models.FailureUnreachable}, wantIDs: []int{8}},
+		{name: "Slow", filter: models.ResultFilter{MinResponseTime: models.Period(time.Second)}, wantIDs: []int{9, 7}},
+		{name: "No matches", filter: models.ResultFilter{To: at.Add(-24 * time.Hour)}, wantIDs: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, next, err := r.Find(1, tt.filter, models.Page{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]int, 0)
+			for _, res := range results {
+				ids = append(ids, res.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) || next != nil {
+				t.Errorf("want %v, got %v (next %v)", tt.wantIDs, ids, next)
+			}
+		})
+	}
+
+	// pages follow the order of the filter
+	ids := make([]int, 0)
+	page := models.Page{Limit: 2}
+	for {
+		results, next, err := r.Find(1, models.ResultFilter{Ascending: true}, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range results {
+			ids = append(ids, res.ID)
+		}
+		if next == nil {
+			break
+		}
+		page.After = next
+	}
+	if want := []int{1, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("want %v, got %v", want, ids)
+	}
+}
+
+func TestResultModel_Export(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	r := &ResultModel{DB: db}
+	ids := make([]int, 0)
+	err := r.Export(2, models.ResultFilter{Ascending: true}, func(res *models.CheckResult) error {
+		ids = append(ids, res.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 2}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("want %v, got %v", want, ids)
+	}
+
+	// the export stops at the first error
+	stop := errors.New("stop")
+	n := 0
+	err = r.Export(2, models.ResultFilter{}, func(res *models.CheckResult) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("want the export stopped after 1 result with %v, got %d results and %v", stop, n, err)
+	}
+}
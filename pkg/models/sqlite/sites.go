@@ -0,0 +1,341 @@
+package sqlite
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/mattn/go-sqlite3"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SiteModel struct {
+	DB *sql.DB
+}
+
+// siteColumns selects a site along with the IDs of the sites it depends on, as a comma separated list, and its labels
+const siteColumns = `id, kind, url, period, grace, COALESCE(token, ''), pattern, created, labels, retention, paused,
+	(SELECT COALESCE(group_concat(parent_id), '') FROM site_dependencies d WHERE d.site_id = sites.id)`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSite(row scanner) (*models.Site, error) {
+	site := &models.Site{}
+	// We handle the interval separately here to maintain its unit (i.e. seconds)
+	var p, g, rt int
+	var labels, parents string
+	if err := row.Scan(&site.ID, &site.Type, &site.URL, &p, &g, &site.Token, &site.Pattern, &site.Created, &labels, &rt, &site.Paused, &parents); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(labels), &site.Labels); err != nil {
+		return nil, err
+	}
+	if len(site.Labels) == 0 {
+		site.Labels = nil
+	}
+	site.Interval = models.Period(time.Duration(p) * time.Second)
+	site.Grace = models.Period(time.Duration(g) * time.Second)
+	site.Retention = models.Period(time.Duration(rt) * time.Second)
+	if parents != "" {
+		for _, id := range strings.Split(parents, ",") {
+			parent, err := strconv.Atoi(id)
+			if err != nil {
+				return nil, err
+			}
+			site.Parents = append(site.Parents, parent)
+		}
+		sort.Ints(site.Parents)
+	}
+	return site, nil
+}
+
+// siteHash identifies a site by its URL, so that sites are only registered once
+func siteHash(URL string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(URL)))
+}
+
+// Insert adds an entry to the Sites table
+func (s *SiteModel) Insert(URL string, interval models.Period, pattern string) (int, error) {
+	stmt := `INSERT INTO sites (site_hash, url, period, pattern, created) VALUES (?, ?, ?, ?, ?)`
+	return s.insert(stmt, siteHash(URL), URL, interval.Duration().Seconds(), pattern, time.Now().UTC())
+}
+
+// cycleCheck returns a query following the parents of n sites, which reports whether any of them leads back to the
+// site itself
+func cycleCheck(n int) string {
+	return `WITH RECURSIVE ancestors (site_id, parent_id) AS (
+		SELECT site_id, parent_id FROM site_dependencies WHERE site_id IN (?` + strings.Repeat(", ?", n-1) + `)
+		UNION
+		SELECT a.site_id, d.parent_id FROM ancestors a JOIN site_dependencies d ON d.site_id = a.parent_id
+	)
+	SELECT EXISTS (SELECT 1 FROM ancestors WHERE site_id = parent_id)`
+}
+
+// InsertBatch adds a batch of sites to the Sites table, along with their labels, retention and whether they are
+// paused, in a single transaction. The ID and creation time of every site that is added are set, while sites whose
+// URL is already registered are skipped and keep an ID of 0. The parents of the sites are added in the same
+// transaction, and unknown parents fail the batch with models.ErrInvalidDependency. New sites may still depend on
+// themselves or on each other by their IDs, so parents forming a cycle fail the batch with models.ErrDependencyCycle
+func (s *SiteModel) InsertBatch(sites []*models.Site) error {
+	if len(sites) == 0 {
+		return nil
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO sites (site_hash, kind, url, period, grace, token, pattern, labels, retention, paused, created)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	ids := make([]int, len(sites))
+	created := time.Now().UTC()
+	for i, site := range sites {
+		kind := site.Type
+		if kind == "" {
+			kind = models.SiteHTTP
+		}
+		labels := site.Labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		data, err := json.Marshal(labels)
+		if err != nil {
+			return err
+		}
+		res, err := stmt.Exec(siteHash(site.URL), kind, site.URL, site.Interval.Duration().Seconds(), site.Grace.Duration().Seconds(),
+			site.Token, site.Pattern, string(data), int(site.Retention.Duration().Seconds()), site.Paused, created)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		ids[i] = int(id)
+	}
+	// the parents are added once all the sites are, as the sites of the batch may depend on each other
+	var children []interface{}
+	for i, site := range sites {
+		if ids[i] == 0 || len(site.Parents) == 0 {
+			continue
+		}
+		children = append(children, ids[i])
+		for _, p := range site.Parents {
+			_, err := tx.Exec(`INSERT INTO site_dependencies (site_id, parent_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, ids[i], p)
+			if err != nil {
+				if isConstraint(err, sqlite3.ErrConstraintForeignKey) {
+					return models.ErrInvalidDependency
+				}
+				return err
+			}
+		}
+	}
+	if len(children) > 0 {
+		var cycle bool
+		if err := tx.QueryRow(cycleCheck(len(children)), children...).Scan(&cycle); err != nil {
+			return err
+		}
+		if cycle {
+			return models.ErrDependencyCycle
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for i, site := range sites {
+		if ids[i] != 0 {
+			site.ID, site.Created = ids[i], created
+		}
+	}
+	return nil
+}
+
+// InsertHeartbeat adds a heartbeat site to the Sites table
+// Heartbeat sites are identified by their token, and their URL is the path they are expected to ping
+func (s *SiteModel) InsertHeartbeat(token string, interval, grace models.Period) (int, error) {
+	URL := "/heartbeat/" + token
+	stmt := `INSERT INTO sites (site_hash, kind, url, period, grace, token, pattern, created) VALUES (?, ?, ?, ?, ?, ?, '', ?)`
+	return s.insert(stmt, siteHash(URL), models.SiteHeartbeat, URL, interval.Duration().Seconds(), grace.Duration().Seconds(), token, time.Now().UTC())
+}
+
+func (s *SiteModel) insert(stmt string, args ...interface{}) (int, error) {
+	res, err := s.DB.Exec(stmt, args...)
+	if err != nil {
+		if isConstraint(err, sqlite3.ErrConstraintUnique) {
+			return -1, models.ErrDuplicateSite
+		}
+		return -1, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// GetByToken fetches a heartbeat site given its token
+func (s *SiteModel) GetByToken(token string) (*models.Site, error) {
+	stmt := `SELECT ` + siteColumns + ` FROM sites WHERE token = ?`
+	site, err := scanSite(s.DB.QueryRow(stmt, token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	return site, nil
+}
+
+// Get fetches a registered Site from the Site table
+func (s *SiteModel) Get(id int) (*models.Site, error) {
+	stmt := `SELECT ` + siteColumns + ` FROM sites WHERE id = ?`
+	site, err := scanSite(s.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	return site, nil
+}
+
+// GetAll fetches a page of registered sites matching the label selector from the site table, newest first.
+// The cursor of the next page is returned if there are more sites.
+// Labels are stored as JSON text, so the selector is applied to the sites as they are read
+func (s *SiteModel) GetAll(sel models.Selector, page models.Page) ([]*models.Site, *models.Cursor, error) {
+	sites := make([]*models.Site, 0)
+	stmt := `SELECT ` + siteColumns + ` FROM sites`
+	args := make([]interface{}, 0)
+	if page.After != nil {
+		stmt += ` WHERE (created, id) < (?, ?)`
+		args = append(args, page.After.At.UTC(), page.After.ID)
+	}
+	rows, err := s.DB.Query(stmt+` ORDER BY created DESC, id DESC`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() && len(sites) <= page.Size() {
+		site, err := scanSite(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sel.Matches(site.Labels) {
+			sites = append(sites, site)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(sites) > page.Size() {
+		sites = sites[:page.Size()]
+		last := sites[len(sites)-1]
+		return sites, &models.Cursor{At: last.Created, ID: last.ID}, nil
+	}
+	return sites, nil, nil
+}
+
+// SetLabels replaces the labels of a site
+func (s *SiteModel) SetLabels(siteID int, labels map[string]string) error {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	return s.update(`UPDATE sites SET labels = ? WHERE id = ?`, string(data), siteID)
+}
+
+// SetRetention sets how long the raw results of a site are kept for, or resets it to the default retention if zero
+func (s *SiteModel) SetRetention(siteID int, retention models.Period) error {
+	return s.update(`UPDATE sites SET retention = ? WHERE id = ?`, int(retention.Duration().Seconds()), siteID)
+}
+
+// Update changes the monitoring interval and pattern of a site
+func (s *SiteModel) Update(siteID int, interval models.Period, pattern string) error {
+	return s.update(`UPDATE sites SET period = ?, pattern = ? WHERE id = ?`, interval.Duration().Seconds(), pattern, siteID)
+}
+
+// SetPaused pauses or resumes the monitoring of a site, paused sites are kept along with their results
+func (s *SiteModel) SetPaused(siteID int, paused bool) error {
+	return s.update(`UPDATE sites SET paused = ? WHERE id = ?`, paused, siteID)
+}
+
+// Delete removes a site, along with its results and dependencies
+func (s *SiteModel) Delete(siteID int) error {
+	return s.update(`DELETE FROM sites WHERE id = ?`, siteID)
+}
+
+// update updates a single site, and fails with models.ErrNoRecord if the site is not found
+func (s *SiteModel) update(stmt string, args ...interface{}) error {
+	res, err := s.DB.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// SetParents replaces the sites that a given site depends on
+// Unknown parent sites result in a models.ErrInvalidDependency, cycle detection is left to the caller
+func (s *SiteModel) SetParents(siteID int, parents []int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM site_dependencies WHERE site_id = ?`, siteID); err != nil {
+		return err
+	}
+	for _, p := range parents {
+		_, err := tx.Exec(`INSERT INTO site_dependencies (site_id, parent_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, siteID, p)
+		if err != nil {
+			if isConstraint(err, sqlite3.ErrConstraintForeignKey) {
+				return models.ErrInvalidDependency
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetDependencies fetches the complete dependency graph, as a map of site IDs to the sites they depend on
+func (s *SiteModel) GetDependencies() (map[int][]int, error) {
+	deps := make(map[int][]int)
+	rows, err := s.DB.Query(`SELECT site_id, parent_id FROM site_dependencies ORDER BY site_id, parent_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var siteID, parentID int
+		if err := rows.Scan(&siteID, &parentID); err != nil {
+			return nil, err
+		}
+		deps[siteID] = append(deps[siteID], parentID)
+	}
+	return deps, rows.Err()
+}
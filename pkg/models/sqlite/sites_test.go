@@ -0,0 +1,390 @@
+package sqlite
+
+import (
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSiteModel_Get(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	s := &SiteModel{DB: db}
+	site, err := s.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &models.Site{
+		ID:       2,
+		Type:     models.SiteHTTP,
+		URL:      "https://www.example.org",
+		Interval: models.Period(3 * time.Second),
+		Pattern:  "content",
+		Parents:  []int{1},
+		Labels:   map[string]string{"team": "payments", "env": "prod"},
+		Created:  time.Date(2021, 3, 1, 11, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(site, want) {
+		t.Errorf("want %+v, got %+v", want, site)
+	}
+	if _, err := s.Get(6); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+}
+
+func TestSiteModel_Insert(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	s := &SiteModel{DB: db}
+	id, err := s.Insert("http://site1/test", models.Period(5*time.Second), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 3 {
+		t.Errorf("want %d, got %d", 3, id)
+	}
+	id, err = s.Insert("http://site1/test", models.Period(5*time.Second), "test")
+	if err != models.ErrDuplicateSite {
+		t.Errorf("want %v, got %v", models.ErrDuplicateSite, err)
+	}
+	if id != -1 {
+		t.Errorf("want %d, got %d", -1, id)
+	}
+}
+
+func TestSiteModel_InsertBatch(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	s := &SiteModel{DB: db}
+	if _, err := s.Insert("http://site2/test", models.Period(5*time.Second), "test"); err != nil {
+		t.Fatal(err)
+	}
+	sites := []*models.Site{
+		{URL: "http://site1/test", Interval: models.Period(5 * time.Second), Pattern: "test", Labels: map[string]string{"team": "web"}, Retention: models.Period(72 * time.Hour), Paused: true, Parents: []int{1, 2}},
+		{URL: "http://site2/test", Interval: models.Period(5 * time.Second)},
+		{Type: models.SiteHeartbeat, URL: "/heartbeat/abc", Token: "abc", Interval: models.Period(time.Hour), Grace: models.Period(5 * time.Minute)},
+		{URL: "http://site1/test", Interval: models.Period(10 * time.Second)},
+	}
+	if err := s.InsertBatch(sites); err != nil {
+		t.Fatal(err)
+	}
+	if sites[0].ID == 0 || sites[2].ID == 0 || sites[0].ID == sites[2].ID || sites[0].Created.IsZero() {
+		t.Fatalf("want new IDs for the new sites, got %d and %d", sites[0].ID, sites[2].ID)
+	}
+	if sites[1].ID != 0 || sites[3].ID != 0 {
+		t.Errorf("want the registered and repeated sites skipped, got IDs %d and %d", sites[1].ID, sites[3].ID)
+	}
+
+	site, err := s.Get(sites[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site.Type != models.SiteHTTP || site.Labels["team"] != "web" || site.Retention != models.Period(72*time.Hour) || !site.Paused {
+		t.Errorf("want a paused HTTP site with labels and retention, got %+v", site)
+	}
+	if !reflect.DeepEqual(site.Parents, []int{1, 2}) {
+		t.Errorf("want parents %v, got %v", []int{1, 2}, site.Parents)
+	}
+	hb, err := s.GetByToken("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hb.ID != sites[2].ID || hb.Type != models.SiteHeartbeat || hb.Grace != models.Period(5*time.Minute) || hb.Paused {
+		t.Errorf("want the heartbeat site, got %+v", hb)
+	}
+	if err := s.InsertBatch(nil); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+
+	orphan := &models.Site{URL: "http://site3/test", Interval: models.Period(5 * time.Second), Parents: []int{99}}
+	if err := s.InsertBatch([]*models.Site{orphan}); err != models.ErrInvalidDependency {
+		t.Errorf("want %v, got %v", models.ErrInvalidDependency, err)
+	}
+	if orphan.ID != 0 {
+		t.Errorf("want the site with an unknown parent not to be added, got ID %d", orphan.ID)
+	}
+}
+
+func TestSiteModel_InsertBatch_cycles(t *testing.T) {
+	tests := []struct {
+		name string
+		// parents of the sites in the batch, by their offset from the ID of the last registered site
+		parents [][]int
+	}{
+		{name: "Self parent", parents: [][]int{{1}}},
+		{name: "Mutual parents", parents: [][]int{{2}, {1}}},
+		{name: "Transitive parents", parents: [][]int{{0, 2}, {3}, {1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			s := &SiteModel{DB: db}
+			last, err := s.Insert("http://site0/test", models.Period(5*time.Second), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sites := make([]*models.Site, len(tt.parents))
+			for i, offsets := range tt.parents {
+				sites[i] = &models.Site{URL: fmt.Sprintf("http://site%d/test", i+1), Interval: models.Period(5 * time.Second)}
+				for _, offset := range offsets {
+					sites[i].Parents = append(sites[i].Parents, last+offset)
+				}
+			}
+			if err := s.InsertBatch(sites); err != models.ErrDependencyCycle {
+				t.Fatalf("want %v, got %v", models.ErrDependencyCycle, err)
+			}
+			for i, site := range sites {
+				if site.ID != 0 {
+					t.Errorf("want no site added, got ID %d", site.ID)
+				}
+				if _, err := s.Get(last + i + 1); err != models.ErrNoRecord {
+					t.Errorf("want %v for site %d, got %v", models.ErrNoRecord, last+i+1, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSiteModel_InsertHeartbeat(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	s := &SiteModel{DB: db}
+	id, err := s.InsertHeartbeat("abc123", models.Period(24*time.Hour), models.Period(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	site, err := s.GetByToken("abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site.ID != id || site.Type != models.SiteHeartbeat || site.URL != "/heartbeat/abc123" || site.Grace != models.Period(time.Hour) {
+		t.Errorf("want heartbeat site %d, got %+v", id, site)
+	}
+	if _, err := s.InsertHeartbeat("abc123", models.Period(time.Hour), 0); err != models.ErrDuplicateSite {
+		t.Errorf("want %v, got %v", models.ErrDuplicateSite, err)
+	}
+	if _, err := s.GetByToken("unknown"); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+}
+
+func TestSiteModel_SetParents(t *testing.T) {
+	tests := []struct {
+		name        string
+		siteID      int
+		parents     []int
+		wantParents []int
+		wantError   error
+	}{
+		{name: "Replace parents", siteID: 1, parents: []int{2}, wantParents: []int{2}},
+		{name: "Clear parents", siteID: 2, parents: nil, wantParents: nil},
+		{name: "Unknown parent", siteID: 2, parents: []int{1, 7}, wantParents: []int{1}, wantError: models.ErrInvalidDependency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			s := &SiteModel{DB: db}
+			if err := s.SetParents(tt.siteID, tt.parents); err != tt.wantError {
+				t.Errorf("want %v, got %v", tt.wantError, err)
+			}
+			site, err := s.Get(tt.siteID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(site.Parents, tt.wantParents) {
+				t.Errorf("want %v, got %v", tt.wantParents, site.Parents)
+			}
+		})
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+	deps, err := (&SiteModel{DB: db}).GetDependencies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int][]int{2: {1}}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("want %v, got %v", want, deps)
+	}
+}
+
+func TestSiteModel_GetAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		wantIDs  []int
+	}{
+		{name: "All sites", selector: "", wantIDs: []int{2, 1}},
+		{name: "Matching label", selector: "team=payments", wantIDs: []int{2}},
+		{name: "Excluded label", selector: "team=payments,env!=prod", wantIDs: []int{}},
+		{name: "Missing label", selector: "!team", wantIDs: []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			sel, err := models.ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &SiteModel{DB: db}
+			sites, _, err := s.GetAll(sel, models.Page{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]int, 0)
+			for _, site := range sites {
+				ids = append(ids, site.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("want %v, got %v", tt.wantIDs, ids)
+			}
+		})
+	}
+}
+
+func TestSiteModel_SetLabels(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	s := &SiteModel{DB: db}
+	labels := map[string]string{"team": "search"}
+	if err := s.SetLabels(1, labels); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRetention(1, models.Period(72*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	site, err := s.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(site.Labels, labels) || site.Retention != models.Period(72*time.Hour) {
+		t.Errorf("want labels %v and a retention of 72h, got %+v", labels, site)
+	}
+	if err := s.SetLabels(9, labels); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+	if err := s.SetRetention(9, 0); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+}
+
+func TestSiteModel_GetAll_pages(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	s := &SiteModel{DB: db}
+	for i := 0; i < 5; i++ {
+		if _, err := s.Insert(fmt.Sprintf("http://site%d/test", i), models.Period(5*time.Second), "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// every site is listed once, newest first
+	ids := make([]int, 0)
+	page := models.Page{Limit: 2}
+	for pages := 1; ; pages++ {
+		sites, next, err := s.GetAll(nil, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, site := range sites {
+			ids = append(ids, site.ID)
+		}
+		if next == nil {
+			if pages != 4 {
+				t.Errorf("want 4 pages, got %d", pages)
+			}
+			break
+		}
+		page.After = next
+	}
+	if want := []int{7, 6, 5, 4, 3, 2, 1}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("want %v, got %v", want, ids)
+	}
+
+	// pages of a selection hold only matching sites
+	sel, _ := models.ParseSelector("!team")
+	sites, next, err := s.GetAll(sel, models.Page{Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites) != 5 || next == nil {
+		t.Fatalf("want a page of 5 sites and a next page, got %d sites", len(sites))
+	}
+	sites, next, err = s.GetAll(sel, models.Page{Limit: 5, After: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites) != 1 || sites[0].ID != 1 || next != nil {
+		t.Errorf("want a last page with site 1, got %d sites", len(sites))
+	}
+}
+
+func TestSiteModel_Update(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	s := &SiteModel{DB: db}
+	if err := s.Update(1, models.Period(time.Minute), "welcome"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetPaused(1, true); err != nil {
+		t.Fatal(err)
+	}
+	site, err := s.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site.Interval != models.Period(time.Minute) || site.Pattern != "welcome" || !site.Paused {
+		t.Errorf("want a paused site checked every minute for welcome, got %+v", site)
+	}
+	if err := s.Update(9, models.Period(time.Minute), ""); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+	if err := s.SetPaused(9, false); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+}
+
+func TestSiteModel_Delete(t *testing.T) {
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	// site 2 depends on site 1, and both have results
+	s := &SiteModel{DB: db}
+	if err := s.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(1); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+	deps, err := s.GetDependencies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 0 {
+		t.Errorf("want the dependencies on the site removed, got %v", deps)
+	}
+	r := &ResultModel{DB: db}
+	if _, _, err := r.GetResultsForSite(1, models.Page{}); err != models.ErrNoRecord {
+		t.Errorf("want the results of the site removed, got %v", err)
+	}
+	if err := s.Delete(1); err != models.ErrNoRecord {
+		t.Errorf("want %v, got %v", models.ErrNoRecord, err)
+	}
+}
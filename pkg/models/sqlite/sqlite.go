@@ -0,0 +1,88 @@
+// Package sqlite implements the site and result stores on SQLite, for single node deployments that cannot run
+// PostgreSQL, such as edge locations and development machines
+package sqlite
+
+import (
+	"database/sql"
+	"embed"
+	"github.com/dnataraj/healthbee/pkg/models/migrate"
+	"github.com/mattn/go-sqlite3"
+	"io/fs"
+	"net/url"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Open opens the SQLite database at the given path, creating it if needed, and applies the pending migrations
+func Open(path string) (*sql.DB, error) {
+	db, err := Connect(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewMigrator(db)
+	if err == nil {
+		_, err = m.Up()
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Connect opens the SQLite database at the given path, creating it if needed, without migrating it. Foreign keys
+// are enforced, and transactions take the write lock as they begin, waiting for other writers rather than failing
+func Connect(path string) (*sql.DB, error) {
+	params := url.Values{}
+	params.Set("_foreign_keys", "1")
+	params.Set("_busy_timeout", "5000")
+	params.Set("_journal_mode", "WAL")
+	params.Set("_txlock", "immediate")
+	return sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+}
+
+// Migrations returns the migrations embedded in HealthBee for SQLite, in order of their versions
+func Migrations() ([]*migrate.Migration, error) {
+	files, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.Load(files)
+}
+
+// NewMigrator returns a migrator applying the embedded migrations to a database. Foreign keys are not enforced while
+// migrating, so that tables can be rebuilt, and are checked before each migration is committed instead. Databases
+// created before migrations were introduced are adopted at the version matching their schema
+func NewMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return &migrate.Migrator{
+		DB:         db,
+		Migrations: migrations,
+		Dialect: migrate.Dialect{
+			Table: `CREATE TABLE IF NOT EXISTS schema_migrations (
+				version INT PRIMARY KEY,
+				name TEXT NOT NULL,
+				applied_at TIMESTAMP NOT NULL
+			)`,
+			Lock:   `PRAGMA foreign_keys = OFF`,
+			Unlock: `PRAGMA foreign_keys = ON`,
+			Check:  `PRAGMA foreign_key_check`,
+			// the schema was only ever changed by adding the paused column to the sites before migrations
+			Adopted: `SELECT CASE
+				WHEN NOT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'sites') THEN 0
+				WHEN NOT EXISTS (SELECT 1 FROM pragma_table_info('sites') WHERE name = 'paused') THEN 1
+				ELSE 2
+			END`,
+		},
+	}, nil
+}
+
+// isConstraint reports whether an error is the violation of the given constraint
+func isConstraint(err error, code sqlite3.ErrNoExtended) bool {
+	serr, ok := err.(sqlite3.Error)
+	return ok && serr.ExtendedCode == code
+}
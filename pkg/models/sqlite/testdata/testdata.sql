@@ -0,0 +1,13 @@
+INSERT INTO sites(site_hash, url, period, pattern, created)
+    VALUES ('e87ece1c1a6a4f8c6d4b1d1f6a5b2c8e', 'https://www.example.com', 5, 'content', '2021-03-01 10:00:00+00:00');
+INSERT INTO sites(site_hash, url, period, pattern, labels, created)
+    VALUES ('3e1c3a6c9c3b4f1e8c2c3e2b0a2d1f3c', 'https://www.example.org', 3, 'content', '{"team": "payments", "env": "prod"}', '2021-03-01 11:00:00+00:00');
+
+INSERT INTO site_dependencies(site_id, parent_id) VALUES (2, 1);
+
+INSERT INTO results(site_id, checked_at, response_time, result, matched)
+    VALUES (1, '2021-03-01 12:00:00+00:00', 600, 200, true);
+INSERT INTO results(site_id, checked_at, response_time, result, matched)
+    VALUES (2, '2021-03-01 12:00:00+00:00', 1200, 400, false);
+INSERT INTO results(site_id, checked_at, response_time, result, matched)
+    VALUES (2, '2021-03-01 11:59:00+00:00', 200, 400, false);
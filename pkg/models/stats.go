@@ -0,0 +1,115 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidStatsWindow = errors.New("stats: invalid window or step")
+
+// Defaults and limits of statistics windows, which are split into at most MaxStatsBuckets steps
+const (
+	DefaultStatsWindow = 24 * time.Hour
+	DefaultStatsStep   = time.Hour
+	MaxStatsBuckets    = 1000
+)
+
+// StatsWindow covers the time from From up to To, in buckets of Step
+type StatsWindow struct {
+	From time.Time
+	To   time.Time
+	Step time.Duration
+}
+
+// ParseStatsWindow parses the window and step query parameters of the statistics of a site, such as 24h and 1h,
+// where both are optional. The window must be a multiple of the step, and ends with the bucket that holds the
+// current time, so that buckets start at round times in UTC
+func ParseStatsWindow(window, step string, now time.Time) (StatsWindow, error) {
+	w, s := DefaultStatsWindow, DefaultStatsStep
+	var err error
+	if window != "" {
+		if w, err = time.ParseDuration(window); err != nil {
+			return StatsWindow{}, ErrInvalidStatsWindow
+		}
+	}
+	if step != "" {
+		if s, err = time.ParseDuration(step); err != nil {
+			return StatsWindow{}, ErrInvalidStatsWindow
+		}
+	}
+	if s < time.Minute || s%time.Minute != 0 || w < s || w%s != 0 || w/s > MaxStatsBuckets {
+		return StatsWindow{}, ErrInvalidStatsWindow
+	}
+	to := now.UTC().Truncate(s).Add(s)
+	return StatsWindow{From: to.Add(-w), To: to, Step: s}, nil
+}
+
+// Split splits the window at the first step whose raw results are all kept with the given retention, where results
+// are kept forever without a retention. Either part can be empty, with no steps
+func (w StatsWindow) Split(retention time.Duration, now time.Time) (expired, retained StatsWindow) {
+	at := w.From
+	if cutoff := now.Add(-retention); retention > 0 && cutoff.After(w.From) {
+		steps := (cutoff.Sub(w.From) + w.Step - 1) / w.Step
+		at = w.From.Add(steps * w.Step)
+		if at.After(w.To) {
+			at = w.To
+		}
+	}
+	return StatsWindow{From: w.From, To: at, Step: w.Step}, StatsWindow{From: at, To: w.To, Step: w.Step}
+}
+
+// Summarise summarises the aggregates of a site into the steps of the window, counting each aggregate in the step
+// that its bucket starts in. Aggregates cannot be split, so with steps shorter than the aggregates, the checks of
+// an aggregate are all counted in one step. Response time percentiles are left out, as they cannot be computed
+// from the aggregates
+func (w StatsWindow) Summarise(rollups []*Rollup, resolution string) []*Stats {
+	buckets := make([]*Stats, w.Buckets())
+	for i := range buckets {
+		buckets[i] = &Stats{Bucket: w.From.Add(time.Duration(i) * w.Step), Resolution: resolution}
+	}
+	for _, r := range rollups {
+		if r.Bucket.Before(w.From) || !r.Bucket.Before(w.To) {
+			continue
+		}
+		b := buckets[r.Bucket.Sub(w.From)/w.Step]
+		b.Add(&Stats{Checks: r.Checks, Failures: r.Failures, Excluded: r.Excluded})
+	}
+	return buckets
+}
+
+// Buckets returns the number of steps in the window
+func (w StatsWindow) Buckets() int {
+	return int(w.To.Sub(w.From) / w.Step)
+}
+
+// Stats summarises the results of a site over a bucket of a statistics window, or the whole window.
+// Checks made during maintenance or while the site was unreachable are excluded from everything but the Excluded
+// count. Uptime is the percentage of checks that did not fail, and is left out if there were no checks, while the
+// response time percentiles only cover the checks that got a response. Stats summarised from the hourly or daily
+// aggregates, once the raw results are past their retention, have the resolution of the aggregates set and no
+// response time percentiles
+type Stats struct {
+	Bucket          time.Time `json:"bucket"`
+	Resolution      string    `json:"resolution,omitempty"`
+	Checks          int       `json:"checks"`
+	Failures        int       `json:"failures"`
+	Excluded        int       `json:"excluded"`
+	Uptime          *float64  `json:"uptime"`
+	P50ResponseTime Period    `json:"p50_response_time"`
+	P90ResponseTime Period    `json:"p90_response_time"`
+	P99ResponseTime Period    `json:"p99_response_time"`
+}
+
+// Add counts the checks of other in s, and updates the uptime. Percentiles cannot be combined, so the response
+// time percentiles are left out
+func (s *Stats) Add(other *Stats) {
+	s.Checks += other.Checks
+	s.Failures += other.Failures
+	s.Excluded += other.Excluded
+	s.P50ResponseTime, s.P90ResponseTime, s.P99ResponseTime = 0, 0, 0
+	s.Uptime = nil
+	if s.Checks > 0 {
+		uptime := 100 * float64(s.Checks-s.Failures) / float64(s.Checks)
+		s.Uptime = &uptime
+	}
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// SiteStore stores the registered sites and their dependencies, as implemented by postgres.SiteModel and
+// sqlite.SiteModel.
+// Registering a site that is already registered fails with ErrDuplicateSite, or is skipped in a batch of sites,
+// depending on an unknown site fails with ErrInvalidDependency, and sites that are not found are reported with
+// ErrNoRecord.
+// Sites are listed a page at a time, newest first, along with the cursor of the next page if there are more sites
+type SiteStore interface {
+	Insert(URL string, interval Period, pattern string) (int, error)
+	InsertHeartbeat(token string, interval, grace Period) (int, error)
+	InsertBatch(sites []*Site) error
+	Get(id int) (*Site, error)
+	GetByToken(token string) (*Site, error)
+	GetAll(sel Selector, page Page) ([]*Site, *Cursor, error)
+	SetLabels(siteID int, labels map[string]string) error
+	SetParents(siteID int, parents []int) error
+	SetRetention(siteID int, retention Period) error
+	Update(siteID int, interval Period, pattern string) error
+	SetPaused(siteID int, paused bool) error
+	Delete(siteID int) error
+	GetDependencies() (map[int][]int, error)
+}
+
+// ResultStore stores the check results of sites, as implemented by postgres.ResultModel and sqlite.ResultModel.
+// Results are unique for a site, check time and source, so storing a result again is not an error. Results of
+// unknown sites fail with ErrInvalidResult. Results are listed a page at a time like sites, latest first, or found
+// with a ResultFilter, in the order it asks for. Exports stream every result matching a filter, however many there are
+type ResultStore interface {
+	Insert(siteID int, checkedAt time.Time, responseTime Period, code int, matched, maintenance, unreachable bool, source string) (int, error)
+	InsertBatch(results []*CheckResult) error
+	Get(id int) (*CheckResult, error)
+	GetResultsForSite(siteID int, page Page) ([]*CheckResult, *Cursor, error)
+	Find(siteID int, filter ResultFilter, page Page) ([]*CheckResult, *Cursor, error)
+	Export(siteID int, filter ResultFilter, fn func(res *CheckResult) error) error
+	GetLatest(siteIDs []int) ([]*CheckResult, error)
+}
@@ -2,16 +2,13 @@ package pkg
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/dnataraj/healthbee/pkg/models"
-	"github.com/segmentio/kafka-go"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
-	"strconv"
 	"sync"
 	"time"
 )
@@ -21,26 +18,61 @@ var infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 var warnLog = log.New(os.Stderr, "WARN\t", log.Ldate|log.Ltime)
 
 // Monitor represents the availability check for each site
+// The optional maintenance schedule, dependency graph and notifier are used to flag results and raise alerts
+// when the site changes state, while the optional site metrics expose the results to Prometheus.
+// State changes are also published as site events, if an event publisher is set
 type Monitor struct {
-	Site    *models.Site
-	Context context.Context
-	Cancel  context.CancelFunc
-	writer  *kafka.Writer
+	Site         *models.Site
+	Context      context.Context
+	Cancel       context.CancelFunc
+	Schedule     *Schedule
+	Dependencies *Dependencies
+	Notifier     Notifier
+	Events       EventPublisher
+	Metrics      *SiteMetrics
+	// Source identifies this HealthBee instance in the results it publishes
+	Source    string
+	publisher Publisher
+	status    string
+	pings     chan time.Time
+	// labels can be changed while the monitor is running, so they are kept apart from the site
+	mu     sync.RWMutex
+	labels map[string]string
 }
 
-func NewMonitor(s *models.Site, w *kafka.Writer) *Monitor {
+// NewMonitor creates a monitor for a site, publishing its check results with the given publisher
+func NewMonitor(s *models.Site, p Publisher) *Monitor {
 	m := &Monitor{}
 	m.Site = s
 	m.Context, m.Cancel = context.WithCancel(context.Background())
-	m.writer = w
+	m.publisher = p
+	m.labels = s.Labels
+	m.pings = make(chan time.Time, 1)
 	return m
 }
 
+// SetLabels updates the labels propagated with the results and alerts of the site
+func (m *Monitor) SetLabels(labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.labels = labels
+}
+
+func (m *Monitor) currentLabels() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.labels
+}
+
 // Start starts the monitor for the site in a goroutine. The wait group operand is incremented and the monitoring
-// results are published to a Kafka topic.
+// results are published with the monitor's publisher, typically to a Kafka topic.
 // Monitor periodicity is achieved with a ticker set to the specified interval for the site and will run until
-// the monitor is cancelled when the HealthBee service ends.
+// the monitor is cancelled when the HealthBee service ends. Heartbeat sites are watched for pings instead.
 func (m *Monitor) Start(wg *sync.WaitGroup) {
+	if m.Site.Type == models.SiteHeartbeat {
+		m.watch(wg)
+		return
+	}
 	wg.Add(1)
 	go func(wg *sync.WaitGroup) {
 		defer wg.Done()
@@ -58,12 +90,10 @@ func (m *Monitor) Start(wg *sync.WaitGroup) {
 				if err != nil {
 					warnLog.Printf("monitor: site[%d] check failed at %s, with: %s", m.Site.ID, at.Format(time.Stamp), err.Error())
 				}
-				// publish the metrics to kafka
-				infoLog.Printf("monitor: site[%d] publishing metrics to kafka: %+v", m.Site.ID, res)
-				err = m.publishResult(res)
-				if err != nil {
-					warnLog.Printf("monitor: site[%d] check failed at %s, with: %s", m.Site.ID, at.Format(time.Stamp), err.Error())
+				if res == nil {
+					continue
 				}
+				m.process(res)
 			case <-m.Context.Done():
 				infoLog.Printf("monitor: monitoring halted for site [%d]", m.Site.ID)
 				return
@@ -72,11 +102,29 @@ func (m *Monitor) Start(wg *sync.WaitGroup) {
 	}(wg)
 }
 
+// process flags, evaluates and publishes a check result
+func (m *Monitor) process(res *models.CheckResult) {
+	res.Labels = m.currentLabels()
+	res.Source = m.Source
+	res.Maintenance = m.Schedule.InMaintenance(m.Site.ID, res.Labels, res.At)
+	m.evaluate(res)
+	m.Metrics.Observe(m.Site, res.Labels, res)
+	infoLog.Printf("monitor: site[%d] publishing metrics: %+v", m.Site.ID, res)
+	err := m.publisher.Publish(m.Context, res)
+	if err != nil {
+		warnLog.Printf("monitor: site[%d] check failed at %s, with: %s", m.Site.ID, res.At.Format(time.Stamp), err.Error())
+	}
+}
+
 // getResult checks site availability associated with this monitor instance
 // The passed in time denotes when the check took place
 // The checks basically record the response and also if a particular pattern is present
 // in the returned content
 func (m *Monitor) getResult(at time.Time) (*models.CheckResult, error) {
+	matcher, err := regexp.Compile(m.Site.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("pattern failed with: %s", err)
+	}
 	req, err := http.NewRequest("GET", m.Site.URL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("request failed with: %s", err)
@@ -98,7 +146,6 @@ func (m *Monitor) getResult(at time.Time) (*models.CheckResult, error) {
 	// read the body and check if pattern exists
 	// assumption that content search is required even for non 200 responses
 	data, err := ioutil.ReadAll(resp.Body)
-	matcher := regexp.MustCompile(m.Site.Pattern)
 	found := matcher.MatchString(string(data))
 
 	return &models.CheckResult{
@@ -110,20 +157,53 @@ func (m *Monitor) getResult(at time.Time) (*models.CheckResult, error) {
 	}, nil
 }
 
-// publishResult marshals a site availability check result and publishes
-// this to a Kafka topic.
-// The key used while publishing is the Site ID
-func (m *Monitor) publishResult(res *models.CheckResult) error {
-	data, err := json.Marshal(res)
-	if err != nil {
-		return fmt.Errorf("publish failed with: %s", err)
+// evaluate compares a check result with the last known state of the site, and raises an alert when it changes.
+// Failures while a parent site is down are flagged as unreachable, and are not alerted on.
+// Results recorded during maintenance or while unreachable are ignored, so that a site still down once the window
+// is over or its parent has recovered is reported then
+func (m *Monitor) evaluate(res *models.CheckResult) {
+	if res.Status() == models.StatusDown {
+		if parent, blocked := m.Dependencies.Blocked(m.Site.ID); blocked {
+			infoLog.Printf("monitor: site[%d] unreachable due to dependency on site [%d]", m.Site.ID, parent)
+			res.Unreachable = true
+		}
+	}
+	m.Dependencies.Record(m.Site.ID, res.Status())
+	if res.Maintenance || res.Unreachable {
+		return
+	}
+	status, previous := res.Status(), m.status
+	m.status = status
+	// the first check after the monitor starts only reports failures
+	if status == previous || (previous == "" && status == models.StatusUp) {
+		return
+	}
+	if m.Events != nil {
+		err := m.Events.PublishEvent(m.Context, &SiteEvent{
+			Type:     EventStateChanged,
+			SiteID:   m.Site.ID,
+			At:       res.At,
+			Labels:   res.Labels,
+			Status:   status,
+			Previous: previous,
+			Source:   m.Source,
+		})
+		if err != nil {
+			warnLog.Printf("monitor: site[%d] unable to publish state change, with: %s", m.Site.ID, err.Error())
+		}
+	}
+	if m.Notifier == nil {
+		return
 	}
-	err = m.writer.WriteMessages(m.Context, kafka.Message{
-		Key:   []byte(strconv.Itoa(m.Site.ID)),
-		Value: data,
+	err := m.Notifier.Notify(m.Context, &Alert{
+		SiteID: m.Site.ID,
+		URL:    m.Site.URL,
+		Labels: res.Labels,
+		Status: status,
+		At:     res.At,
+		Result: res,
 	})
 	if err != nil {
-		return fmt.Errorf("publish failed with: %s", err)
+		warnLog.Printf("monitor: site[%d] unable to raise alert, with: %s", m.Site.ID, err.Error())
 	}
-	return nil
 }
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"github.com/dnataraj/healthbee/pkg/models"
 	"sync"
@@ -43,10 +44,11 @@ func TestMonitor_Start(t *testing.T) {
 	defer teardown(t)
 
 	wg := sync.WaitGroup{}
-	m1 := NewMonitor(site1, w)
+	p := &KafkaPublisher{Writer: w}
+	m1 := NewMonitor(site1, p)
 	m1.Start(&wg)
 
-	m2 := NewMonitor(site2, w)
+	m2 := NewMonitor(site2, p)
 	m2.Start(&wg)
 
 	//TODO: Create a consumer and read a couple of messages for verification
@@ -120,7 +122,7 @@ func TestMonitor_getResult(t *testing.T) {
 			defer teardown()
 
 			// Start monitoring
-			m := NewMonitor(tt.site, nil)
+			m := NewMonitor(tt.site, NewMemoryPublisher(1))
 			defer m.Cancel()
 			res, err := m.getResult(tt.checkedAt)
 			if err != tt.wantError {
@@ -139,3 +141,72 @@ func TestMonitor_getResult(t *testing.T) {
 		})
 	}
 }
+
+func TestMonitor_getResult_invalidPattern(t *testing.T) {
+	m := NewMonitor(&models.Site{ID: 1, URL: TestHTTPServer, Interval: models.Period(time.Minute), Pattern: "("}, NewMemoryPublisher(1))
+	defer m.Cancel()
+	if res, err := m.getResult(time.Now().UTC()); err == nil || res != nil {
+		t.Errorf("want an error for an invalid pattern, got %+v and %v", res, err)
+	}
+}
+
+// recorder is a Notifier that keeps the alerts raised by a monitor
+type recorder struct {
+	alerts []*Alert
+}
+
+func (r *recorder) Notify(_ context.Context, a *Alert) error {
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+// collector is a Notifier that passes on the alerts raised by a monitor
+type collector chan *Alert
+
+func (c collector) Notify(_ context.Context, a *Alert) error {
+	c <- a
+	return nil
+}
+
+// Test that heartbeat sites are reported down once they stop pinging
+func TestMonitor_watch(t *testing.T) {
+	site := &models.Site{
+		ID:       3,
+		Type:     models.SiteHeartbeat,
+		URL:      "/heartbeat/test",
+		Interval: models.Period(200 * time.Millisecond),
+		Grace:    models.Period(100 * time.Millisecond),
+	}
+	alerts := make(collector, 2)
+	p := NewMemoryPublisher(10)
+	m := NewMonitor(site, p)
+	m.Notifier = alerts
+
+	wg := sync.WaitGroup{}
+	m.Start(&wg)
+	defer wg.Wait()
+	defer m.Cancel()
+
+	m.Ping(time.Now())
+	if res := <-p.Results(); res.Status() != models.StatusUp {
+		t.Errorf("want an up result for the ping, got %+v", res)
+	}
+	select {
+	case a := <-alerts:
+		if a.Status != models.StatusDown || a.SiteID != site.ID {
+			t.Errorf("want site %d down, got %+v", site.ID, a)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("want an alert once the heartbeat is overdue")
+	}
+
+	m.Ping(time.Now())
+	select {
+	case a := <-alerts:
+		if a.Status != models.StatusUp {
+			t.Errorf("want site %d up, got %+v", site.ID, a)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("want an alert once the heartbeat recovers")
+	}
+}
@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/segmentio/kafka-go"
+	"strconv"
+	"time"
+)
+
+// Publisher delivers the results of site availability checks, to be stored for analysis
+type Publisher interface {
+	Publish(ctx context.Context, res *models.CheckResult) error
+}
+
+// BatchPublisher is a Publisher that can deliver several check results at once
+type BatchPublisher interface {
+	Publisher
+	PublishBatch(ctx context.Context, results []*models.CheckResult) error
+}
+
+// publishBatch publishes the check results in a single batch if the publisher supports it, or one at a time
+// otherwise
+func publishBatch(ctx context.Context, p Publisher, results []*models.CheckResult) error {
+	if bp, ok := p.(BatchPublisher); ok {
+		return bp.PublishBatch(ctx, results)
+	}
+	for _, res := range results {
+		if err := p.Publish(ctx, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KafkaPublisher publishes check results to a Kafka topic, from which they are consumed by the auditors.
+// Results are encoded with the codec, JSON if not set, and framed with the schema ID if it was registered
+// with a schema registry
+type KafkaPublisher struct {
+	Writer   *kafka.Writer
+	Codec    Codec
+	SchemaID int
+}
+
+// Publish encodes a check result and publishes it to the Kafka topic of the writer.
+// The key used while publishing is the Site ID, and site labels are added as label.<key> headers
+// so that consumers can route results without decoding them
+func (p *KafkaPublisher) Publish(ctx context.Context, res *models.CheckResult) error {
+	return p.PublishBatch(ctx, []*models.CheckResult{res})
+}
+
+// PublishBatch encodes the check results and publishes them with a single write, returning once all of them
+// have been acknowledged
+func (p *KafkaPublisher) PublishBatch(ctx context.Context, results []*models.CheckResult) error {
+	c := p.Codec
+	if c == nil {
+		c = JSONCodec{}
+	}
+	msgs := make([]kafka.Message, 0, len(results))
+	for _, res := range results {
+		data, headers, err := EncodeResult(c, p.SchemaID, res)
+		if err != nil {
+			return fmt.Errorf("publish failed with: %s", err)
+		}
+		for _, k := range models.LabelKeys(res.Labels) {
+			headers = append(headers, kafka.Header{Key: "label." + k, Value: []byte(res.Labels[k])})
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:     []byte(strconv.Itoa(res.SiteID)),
+			Value:   data,
+			Headers: headers,
+		})
+	}
+	if err := p.Writer.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("publish failed with: %s", err)
+	}
+	return nil
+}
+
+// MemoryPublisher hands check results over on a channel, for use within a single process and in tests
+type MemoryPublisher struct {
+	results chan *models.CheckResult
+}
+
+// NewMemoryPublisher creates an in-memory publisher, buffering up to size results
+func NewMemoryPublisher(size int) *MemoryPublisher {
+	return &MemoryPublisher{results: make(chan *models.CheckResult, size)}
+}
+
+// Publish queues a check result, blocking while the buffer is full
+func (p *MemoryPublisher) Publish(ctx context.Context, res *models.CheckResult) error {
+	select {
+	case p.results <- res:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("publish failed with: %s", ctx.Err())
+	}
+}
+
+// Results returns the channel on which published results are delivered
+func (p *MemoryPublisher) Results() <-chan *models.CheckResult {
+	return p.results
+}
+
+// DirectPublisher writes check results straight to a sink such as the results store, so that small deployments
+// can run HealthBee without a Kafka cluster
+type DirectPublisher struct {
+	Sink Sink
+}
+
+func (p *DirectPublisher) Publish(ctx context.Context, res *models.CheckResult) error {
+	return p.PublishBatch(ctx, []*models.CheckResult{res})
+}
+
+// PublishBatch stores the check results in a single batch. If the batch holds results that can never be stored,
+// such as results of a site deleted while they were queued, the results are stored one at a time instead, dropping
+// the invalid ones like the Auditor does
+func (p *DirectPublisher) PublishBatch(_ context.Context, results []*models.CheckResult) error {
+	err := p.Sink.InsertBatch(results)
+	if errors.Is(err, models.ErrInvalidResult) {
+		err = p.insertEach(results)
+	}
+	if err != nil {
+		return fmt.Errorf("publish failed with: %w", err)
+	}
+	return nil
+}
+
+// insertEach stores the check results one at a time, logging and dropping the results that can never be stored
+func (p *DirectPublisher) insertEach(results []*models.CheckResult) error {
+	for _, res := range results {
+		err := p.Sink.InsertBatch([]*models.CheckResult{res})
+		if errors.Is(err, models.ErrInvalidResult) {
+			warnLog.Printf("publish: site[%d] dropping result at %s: %s", res.SiteID, res.At.Format(time.Stamp), err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySink is a Sink that keeps the results it is given, and the size of each batch
+// Batches fail with err when set, and results of the invalid site are rejected
+type memorySink struct {
+	sync.Mutex
+	results []*models.CheckResult
+	batches []int
+	err     error
+	invalid int
+}
+
+func (s *memorySink) InsertBatch(results []*models.CheckResult) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	for _, res := range results {
+		if s.invalid != 0 && res.SiteID == s.invalid {
+			return models.ErrInvalidResult
+		}
+	}
+	s.results = append(s.results, results...)
+	s.batches = append(s.batches, len(results))
+	return nil
+}
+
+func TestDirectPublisher_Publish(t *testing.T) {
+	store := &memorySink{}
+	p := &DirectPublisher{Sink: store}
+	res := &models.CheckResult{SiteID: 1, At: time.Now().UTC(), ResponseCode: 200, MatchedPattern: true, Maintenance: true}
+	if err := p.Publish(context.Background(), res); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.results) != 1 || store.results[0].SiteID != 1 || !store.results[0].Maintenance {
+		t.Errorf("want the published result stored, got %+v", store.results)
+	}
+}
+
+func TestMemoryPublisher_Publish(t *testing.T) {
+	p := NewMemoryPublisher(1)
+	if err := p.Publish(context.Background(), &models.CheckResult{SiteID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the buffer is full, so publishing blocks until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Publish(ctx, &models.CheckResult{SiteID: 2}); err == nil {
+		t.Errorf("want error publishing to a full buffer, got nil")
+	}
+	if res := <-p.Results(); res.SiteID != 1 {
+		t.Errorf("want result for site 1, got %d", res.SiteID)
+	}
+}
+
+func TestDirectPublisher_PublishBatch(t *testing.T) {
+	store := &memorySink{invalid: 2}
+	p := &DirectPublisher{Sink: store}
+	results := []*models.CheckResult{{SiteID: 1}, {SiteID: 2}, {SiteID: 3}}
+	if err := p.PublishBatch(context.Background(), results); err != nil {
+		t.Fatalf("want the invalid result dropped, got %s", err)
+	}
+	if ids := siteIDs(store.results); len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Errorf("want results for sites 1 and 3 stored, got %v", ids)
+	}
+
+	failure := errors.New("database is locked")
+	store.err = failure
+	if err := p.PublishBatch(context.Background(), results); !errors.Is(err, failure) {
+		t.Errorf("want %q, got %v", failure, err)
+	}
+}
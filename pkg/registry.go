@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// registryContentType is the media type of the Confluent schema registry API
+const registryContentType = "application/vnd.schemaregistry.v1+json"
+
+// SchemaRegistry is a client for a Confluent compatible schema registry, with which the schema of the result
+// messages is registered so that other consumers can look it up by the ID framed in each message
+type SchemaRegistry struct {
+	URL string
+}
+
+// registryError is the error response of the schema registry
+type registryError struct {
+	Code    int    `json:"error_code"`
+	Message string `json:"message"`
+}
+
+// Register registers the schema of a codec under a subject, such as Metrics-value for the values of the
+// Metrics topic, and returns its ID. Registering a schema that is already registered returns the existing ID
+func (r *SchemaRegistry) Register(subject string, c Codec) (int, error) {
+	body := map[string]string{"schema": c.Schema()}
+	// the registry assumes Avro when no type is given, which older registries expect
+	if c.SchemaType() != "AVRO" {
+		body["schemaType"] = c.SchemaType()
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	endpoint := fmt.Sprintf("%s/subjects/%s/versions", strings.TrimSuffix(r.URL, "/"), url.PathEscape(subject))
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", registryContentType)
+	req.Header.Set("Accept", registryContentType)
+
+	var out struct {
+		ID int `json:"id"`
+	}
+	if err := r.do(req, &out); err != nil {
+		return 0, err
+	}
+	return out.ID, nil
+}
+
+// Schema fetches a registered schema given its ID
+func (r *SchemaRegistry) Schema(id int) (string, error) {
+	endpoint := fmt.Sprintf("%s/schemas/ids/%d", strings.TrimSuffix(r.URL, "/"), id)
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", registryContentType)
+
+	var out struct {
+		Schema string `json:"schema"`
+	}
+	if err := r.do(req, &out); err != nil {
+		return "", err
+	}
+	return out.Schema, nil
+}
+
+func (r *SchemaRegistry) do(req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("schema registry failed with: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e := &registryError{}
+		if err := json.NewDecoder(resp.Body).Decode(e); err != nil || e.Message == "" {
+			return fmt.Errorf("schema registry failed with: %s", resp.Status)
+		}
+		return fmt.Errorf("schema registry failed with: %s (%d)", e.Message, e.Code)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// registryStub is a minimal in-memory schema registry, assigning IDs to schemas in the order they are registered
+type registryStub struct {
+	sync.Mutex
+	schemas []string
+	types   []string
+}
+
+func (s *registryStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	w.Header().Set("Content-Type", registryContentType)
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/subjects/") && strings.HasSuffix(r.URL.Path, "/versions"):
+		body := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"error_code": 42201, "message": "Invalid schema"}`)
+			return
+		}
+		for i, schema := range s.schemas {
+			if schema == body["schema"] {
+				fmt.Fprintf(w, `{"id": %d}`, i+1)
+				return
+			}
+		}
+		s.schemas = append(s.schemas, body["schema"])
+		s.types = append(s.types, body["schemaType"])
+		fmt.Fprintf(w, `{"id": %d}`, len(s.schemas))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/schemas/ids/"):
+		var id int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/schemas/ids/"), "%d", &id)
+		if id < 1 || id > len(s.schemas) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error_code": 40403, "message": "Schema not found"}`)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"schema": s.schemas[id-1]})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSchemaRegistry_Register(t *testing.T) {
+	stub := &registryStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	r := &SchemaRegistry{URL: srv.URL}
+	avro, _ := NewCodec("avro")
+	tests := []struct {
+		name     string
+		codec    Codec
+		wantID   int
+		wantType string
+	}{
+		{name: "Avro schema", codec: avro, wantID: 1, wantType: ""},
+		{name: "Protobuf schema", codec: ProtobufCodec{}, wantID: 2, wantType: "PROTOBUF"},
+		{name: "Registered schema", codec: avro, wantID: 1, wantType: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := r.Register("Metrics-value", tt.codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.wantID {
+				t.Errorf("want %d, got %d", tt.wantID, id)
+			}
+			if stub.types[id-1] != tt.wantType {
+				t.Errorf("want schema type %q, got %q", tt.wantType, stub.types[id-1])
+			}
+		})
+	}
+
+	schema, err := r.Schema(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema != protoSchema {
+		t.Errorf("want %q, got %q", protoSchema, schema)
+	}
+	if _, err := r.Schema(10); err == nil || !strings.Contains(err.Error(), "Schema not found") {
+		t.Errorf("want schema not found error, got %v", err)
+	}
+}
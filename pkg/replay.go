@@ -0,0 +1,287 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Position is a point in the results topic that a replay starts or ends at, either an offset that applies to
+// every partition or a point in time. The zero Position is the start of the topic when replaying from it, and
+// the end of the topic when replaying to it
+type Position struct {
+	Offset int64
+	Time   time.Time
+	offset bool
+}
+
+// ParsePosition parses an offset, or a time as an RFC 3339 timestamp or a date. An empty string is the zero
+// Position
+func ParsePosition(s string) (Position, error) {
+	if s == "" {
+		return Position{}, nil
+	}
+	if o, err := strconv.ParseInt(s, 10, 64); err == nil && o >= 0 {
+		return Position{Offset: o, offset: true}, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return Position{Time: t}, nil
+		}
+	}
+	return Position{}, fmt.Errorf("replay: invalid position %q, expecting an offset, a RFC 3339 timestamp or a date", s)
+}
+
+func (p Position) String() string {
+	switch {
+	case p.offset:
+		return "offset " + strconv.FormatInt(p.Offset, 10)
+	case !p.Time.IsZero():
+		return p.Time.Format(time.RFC3339)
+	}
+	return "-"
+}
+
+// resolve returns the offset of the position within a partition holding the offsets first up to last,
+// or def for the zero Position
+func (p Position) resolve(conn *kafka.Conn, first, last, def int64) (int64, error) {
+	o := def
+	switch {
+	case p.offset:
+		o = p.Offset
+	case !p.Time.IsZero():
+		var err error
+		o, err = conn.ReadOffset(p.Time)
+		if err != nil {
+			return 0, err
+		}
+		// there are no messages at or after the time
+		if o < 0 {
+			o = last
+		}
+	}
+	if o < first {
+		return first, nil
+	}
+	if o > last {
+		return last, nil
+	}
+	return o, nil
+}
+
+// Replay rebuilds the results store from the results topic, after data loss or to backfill a new schema.
+// The results between From and To are consumed through a separate consumer group, so that the auditors are
+// not disturbed, and stored through the auditor path. Results that are already stored are not stored twice.
+// Malformed messages are dropped rather than dead lettered again
+type Replay struct {
+	Brokers   []string
+	Dialer    *kafka.Dialer
+	Topic     string
+	GroupID   string
+	From, To  Position
+	Sink      Sink
+	FlushSize int
+	Retries   int
+}
+
+// NewReplay creates a replay of the whole results topic into the sink, in batches of 1000 results
+func NewReplay(brokers []string, dialer *kafka.Dialer, sink Sink) *Replay {
+	return &Replay{
+		Brokers:   brokers,
+		Dialer:    dialer,
+		Topic:     "Metrics",
+		GroupID:   "healthbee-replay",
+		Sink:      sink,
+		FlushSize: 1000,
+		Retries:   5,
+	}
+}
+
+// Run replays the results, returning the number of results stored once every partition has been replayed up
+// to the end of the range. A replay that fails is resumed from the offsets last committed, up to Retries times
+func (r *Replay) Run(ctx context.Context) (int, error) {
+	starts, ends, err := r.ranges(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("replay: unable to read offsets: %s", err)
+	}
+	if err := r.seek(ctx, starts); err != nil {
+		return 0, fmt.Errorf("replay: unable to set offsets of group %s: %s", r.GroupID, err)
+	}
+
+	sink := &countingSink{Sink: r.Sink}
+	a := NewAuditor(r.Brokers, r.Dialer, sink)
+	a.Topic, a.GroupID, a.FlushSize = r.Topic, r.GroupID, r.FlushSize
+	pending := make(map[int]bool)
+	for p, start := range starts {
+		if start < ends[p] {
+			pending[p] = true
+		}
+	}
+	delay := a.Backoff
+	for attempt := 0; ; attempt++ {
+		if len(pending) == 0 {
+			return sink.count(), nil
+		}
+		infoLog.Printf("replay: replaying %d partitions of %s from %s to %s", len(pending), r.Topic, r.From, r.To)
+		err := a.work(ctx, 1, &boundedReader{messageReader: a.newReader(), ends: ends, pending: pending})
+		if err == io.EOF {
+			return sink.count(), nil
+		}
+		if ctx.Err() != nil {
+			return sink.count(), ctx.Err()
+		}
+		if attempt >= r.Retries {
+			return sink.count(), fmt.Errorf("replay: giving up after %d attempts: %s", attempt+1, err)
+		}
+		warnLog.Printf("replay: stopped with: %s, resuming in %s", err, delay)
+		if !sleep(ctx, delay) {
+			return sink.count(), ctx.Err()
+		}
+		delay = a.next(delay)
+		if pending, err = r.pending(ctx, ends); err != nil {
+			return sink.count(), fmt.Errorf("replay: unable to read offsets of group %s: %s", r.GroupID, err)
+		}
+	}
+}
+
+// ranges resolves the offsets each partition of the topic is replayed from and to
+func (r *Replay) ranges(ctx context.Context) (map[int]int64, map[int]int64, error) {
+	conn, err := r.Dialer.DialContext(ctx, "tcp", r.Brokers[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	// listing the partitions of all topics, as asking for a missing topic may create it
+	partitions, err := conn.ReadPartitions()
+	conn.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	starts, ends := make(map[int]int64), make(map[int]int64)
+	for _, p := range partitions {
+		if p.Topic != r.Topic {
+			continue
+		}
+		conn, err := r.Dialer.DialLeader(ctx, "tcp", r.Brokers[0], r.Topic, p.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		first, last, err := conn.ReadOffsets()
+		if err == nil {
+			starts[p.ID], err = r.From.resolve(conn, first, last, first)
+		}
+		if err == nil {
+			ends[p.ID], err = r.To.resolve(conn, first, last, last)
+		}
+		conn.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(starts) == 0 {
+		return nil, nil, fmt.Errorf("topic %s does not exist", r.Topic)
+	}
+	return starts, ends, nil
+}
+
+// seek commits the offsets the replay starts from for its consumer group, replacing those of earlier replays
+func (r *Replay) seek(ctx context.Context, starts map[int]int64) error {
+	cg, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:      r.GroupID,
+		Brokers: r.Brokers,
+		Dialer:  r.Dialer,
+		Topics:  []string{r.Topic},
+	})
+	if err != nil {
+		return err
+	}
+	defer cg.Close()
+	gen, err := cg.Next(ctx)
+	if err != nil {
+		return err
+	}
+	return gen.CommitOffsets(map[string]map[int]int64{r.Topic: starts})
+}
+
+// pending returns the partitions that have not been replayed up to the end of the range yet, going by the
+// offsets committed by the consumer group
+func (r *Replay) pending(ctx context.Context, ends map[int]int64) (map[int]bool, error) {
+	ids := make([]int, 0, len(ends))
+	for p := range ends {
+		ids = append(ids, p)
+	}
+	client := &kafka.Client{
+		Addr:      kafka.TCP(r.Brokers...),
+		Transport: &kafka.Transport{TLS: r.Dialer.TLS, SASL: r.Dialer.SASLMechanism},
+	}
+	resp, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: r.GroupID, Topics: map[string][]int{r.Topic: ids}})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	pending := make(map[int]bool)
+	for _, p := range resp.Topics[r.Topic] {
+		if p.Error != nil {
+			return nil, p.Error
+		}
+		if p.CommittedOffset < ends[p.Partition] {
+			pending[p.Partition] = true
+		}
+	}
+	return pending, nil
+}
+
+// boundedReader reads messages up to the end offset of each partition, skipping any messages past it.
+// Once every pending partition has been read up to its end, io.EOF is returned
+type boundedReader struct {
+	messageReader
+	ends    map[int]int64
+	pending map[int]bool
+}
+
+func (r *boundedReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for len(r.pending) > 0 {
+		msg, err := r.messageReader.FetchMessage(ctx)
+		if err != nil {
+			return msg, err
+		}
+		end := r.ends[msg.Partition]
+		if msg.Offset >= end-1 {
+			delete(r.pending, msg.Partition)
+		}
+		if msg.Offset < end {
+			return msg, nil
+		}
+	}
+	return kafka.Message{}, io.EOF
+}
+
+// countingSink counts the results stored by a replay, including those that were already stored
+type countingSink struct {
+	Sink
+	mu sync.Mutex
+	n  int
+}
+
+func (s *countingSink) InsertBatch(results []*models.CheckResult) error {
+	if err := s.Sink.InsertBatch(results); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.n += len(results)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *countingSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"context"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParsePosition(t *testing.T) {
+	tests := []struct {
+		name      string
+		position  string
+		want      Position
+		wantError bool
+	}{
+		{name: "Empty", position: "", want: Position{}},
+		{name: "Offset", position: "1500", want: Position{Offset: 1500, offset: true}},
+		{name: "Timestamp", position: "2021-03-01T10:30:00Z", want: Position{Time: time.Date(2021, 3, 1, 10, 30, 0, 0, time.UTC)}},
+		{name: "Date", position: "2021-03-01", want: Position{Time: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)}},
+		{name: "Negative offset", position: "-5", wantError: true},
+		{name: "Garbage", position: "yesterday", wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParsePosition(tt.position)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("want error %t, got %v", tt.wantError, err)
+			}
+			if p.Offset != tt.want.Offset || p.offset != tt.want.offset || !p.Time.Equal(tt.want.Time) {
+				t.Errorf("want %+v, got %+v", tt.want, p)
+			}
+		})
+	}
+}
+
+func TestBoundedReader(t *testing.T) {
+	r := &fakeReader{messages: make(chan kafka.Message, 10)}
+	for _, msg := range []kafka.Message{
+		{Partition: 0, Offset: 4},
+		{Partition: 1, Offset: 10},
+		{Partition: 0, Offset: 5},
+		// past the end of partition 0
+		{Partition: 0, Offset: 6},
+		{Partition: 1, Offset: 11},
+		{Partition: 1, Offset: 12},
+	} {
+		r.messages <- msg
+	}
+	br := &boundedReader{
+		messageReader: r,
+		ends:          map[int]int64{0: 6, 1: 12},
+		pending:       map[int]bool{0: true, 1: true},
+	}
+
+	var got []int64
+	for {
+		msg, err := br.FetchMessage(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg.Offset)
+	}
+	want := []int64{4, 10, 5, 11}
+	if len(got) != len(want) {
+		t.Fatalf("want offsets %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("want offsets %v, got %v", want, got)
+			break
+		}
+	}
+}
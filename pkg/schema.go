@@ -0,0 +1,444 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/linkedin/goavro/v2"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"strconv"
+	"time"
+)
+
+// SchemaVersion is the version of the result message schema written by the publishers.
+// Messages without a version header predate the schema, and hold a models.CheckResult encoded as JSON
+const SchemaVersion = 1
+
+// Headers describing the encoding of result messages
+const (
+	HeaderSchemaVersion = "schema.version"
+	HeaderContentType   = "content-type"
+	HeaderSchemaID      = "schema.id"
+)
+
+var ErrUnknownSchema = errors.New("schema: unknown result message schema")
+
+// Codec encodes check results in version 1 of the result message schema
+type Codec interface {
+	// ContentType identifies the encoding in the content-type header of messages
+	ContentType() string
+	// SchemaType and Schema describe the schema as registered with a schema registry
+	SchemaType() string
+	Schema() string
+	Marshal(res *models.CheckResult) ([]byte, error)
+	Unmarshal(data []byte) (*models.CheckResult, error)
+}
+
+// NewCodec returns the codec for an encoding, either json, protobuf or avro
+func NewCodec(encoding string) (Codec, error) {
+	switch encoding {
+	case "json":
+		return JSONCodec{}, nil
+	case "protobuf":
+		return ProtobufCodec{}, nil
+	case "avro":
+		return codecs[avroContentType], nil
+	}
+	return nil, fmt.Errorf("schema: unknown encoding %q, expecting json, protobuf or avro", encoding)
+}
+
+// codecs is used to decode messages by their content type
+var codecs = func() map[string]Codec {
+	avro, err := NewAvroCodec()
+	if err != nil {
+		panic(err)
+	}
+	return map[string]Codec{
+		JSONCodec{}.ContentType():     JSONCodec{},
+		ProtobufCodec{}.ContentType(): ProtobufCodec{},
+		avro.ContentType():            avro,
+	}
+}()
+
+// EncodeResult encodes a check result as a Kafka message value, along with the headers describing its schema.
+// With a non-zero schema ID, as given by a schema registry, the value is framed in the Confluent wire format
+func EncodeResult(c Codec, schemaID int, res *models.CheckResult) ([]byte, []kafka.Header, error) {
+	data, err := c.Marshal(res)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := []kafka.Header{
+		{Key: HeaderSchemaVersion, Value: []byte(strconv.Itoa(SchemaVersion))},
+		{Key: HeaderContentType, Value: []byte(c.ContentType())},
+	}
+	if schemaID == 0 {
+		return data, headers, nil
+	}
+	headers = append(headers, kafka.Header{Key: HeaderSchemaID, Value: []byte(strconv.Itoa(schemaID))})
+	// magic byte, schema ID and for protobuf the index of the message within the schema
+	framed := make([]byte, 5, len(data)+6)
+	binary.BigEndian.PutUint32(framed[1:], uint32(schemaID))
+	if c.SchemaType() == "PROTOBUF" {
+		framed = append(framed, 0)
+	}
+	return append(framed, data...), headers, nil
+}
+
+// DecodeResult decodes a check result from a Kafka message, given the schema version and encoding in its headers
+func DecodeResult(msg kafka.Message) (*models.CheckResult, error) {
+	var version, contentType, schemaID string
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case HeaderSchemaVersion:
+			version = string(h.Value)
+		case HeaderContentType:
+			contentType = string(h.Value)
+		case HeaderSchemaID:
+			schemaID = string(h.Value)
+		}
+	}
+	if version == "" {
+		res := &models.CheckResult{}
+		if err := json.Unmarshal(msg.Value, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	if version != strconv.Itoa(SchemaVersion) {
+		return nil, fmt.Errorf("%w: version %s", ErrUnknownSchema, version)
+	}
+	c, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: content type %q", ErrUnknownSchema, contentType)
+	}
+	data := msg.Value
+	if schemaID != "" {
+		if len(data) < 5 || data[0] != 0 {
+			return nil, fmt.Errorf("%w: invalid schema registry framing", ErrUnknownSchema)
+		}
+		data = data[5:]
+		if c.SchemaType() == "PROTOBUF" {
+			if _, n := protowire.ConsumeVarint(data); n > 0 {
+				data = data[n:]
+			}
+		}
+	}
+	return c.Unmarshal(data)
+}
+
+// resultV1 is version 1 of the result message schema, as encoded in JSON.
+// Check times are RFC 3339 timestamps and response times are in milliseconds
+type resultV1 struct {
+	SiteID         int               `json:"site_id"`
+	CheckedAt      time.Time         `json:"checked_at"`
+	ResponseTimeMs int64             `json:"response_time_ms"`
+	ResponseCode   int               `json:"response_code"`
+	Matched        bool              `json:"matched"`
+	Maintenance    bool              `json:"maintenance"`
+	Unreachable    bool              `json:"unreachable"`
+	Source         string            `json:"source"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+func toV1(res *models.CheckResult) *resultV1 {
+	return &resultV1{
+		SiteID:         res.SiteID,
+		CheckedAt:      res.At,
+		ResponseTimeMs: res.ResponseTime.Duration().Milliseconds(),
+		ResponseCode:   res.ResponseCode,
+		Matched:        res.MatchedPattern,
+		Maintenance:    res.Maintenance,
+		Unreachable:    res.Unreachable,
+		Source:         res.Source,
+		Labels:         res.Labels,
+	}
+}
+
+func (r *resultV1) result() *models.CheckResult {
+	return &models.CheckResult{
+		SiteID:         r.SiteID,
+		At:             r.CheckedAt,
+		ResponseTime:   models.Period(time.Duration(r.ResponseTimeMs) * time.Millisecond),
+		ResponseCode:   r.ResponseCode,
+		MatchedPattern: r.Matched,
+		Maintenance:    r.Maintenance,
+		Unreachable:    r.Unreachable,
+		Source:         r.Source,
+		Labels:         r.Labels,
+	}
+}
+
+// JSONCodec encodes check results as JSON
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string { return "application/json" }
+func (JSONCodec) SchemaType() string  { return "JSON" }
+func (JSONCodec) Schema() string      { return jsonSchema }
+
+func (JSONCodec) Marshal(res *models.CheckResult) ([]byte, error) {
+	return json.Marshal(toV1(res))
+}
+
+func (JSONCodec) Unmarshal(data []byte) (*models.CheckResult, error) {
+	r := &resultV1{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	return r.result(), nil
+}
+
+const jsonSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "CheckResult",
+  "type": "object",
+  "properties": {
+    "site_id": {"type": "integer"},
+    "checked_at": {"type": "string", "format": "date-time"},
+    "response_time_ms": {"type": "integer"},
+    "response_code": {"type": "integer"},
+    "matched": {"type": "boolean"},
+    "maintenance": {"type": "boolean"},
+    "unreachable": {"type": "boolean"},
+    "source": {"type": "string"},
+    "labels": {"type": "object", "additionalProperties": {"type": "string"}}
+  },
+  "required": ["site_id", "checked_at", "response_time_ms", "response_code", "matched"]
+}`
+
+// ProtobufCodec encodes check results as the healthbee.v1.CheckResult protocol buffer message
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string { return "application/x-protobuf" }
+func (ProtobufCodec) SchemaType() string  { return "PROTOBUF" }
+func (ProtobufCodec) Schema() string      { return protoSchema }
+
+const protoSchema = `syntax = "proto3";
+package healthbee.v1;
+
+message CheckResult {
+  int64 site_id = 1;
+  int64 checked_at_micros = 2;
+  int64 response_time_ms = 3;
+  int32 response_code = 4;
+  bool matched = 5;
+  bool maintenance = 6;
+  bool unreachable = 7;
+  string source = 8;
+  map<string, string> labels = 9;
+}
+`
+
+func (ProtobufCodec) Marshal(res *models.CheckResult) ([]byte, error) {
+	r := toV1(res)
+	var b []byte
+	b = appendVarint(b, 1, uint64(r.SiteID))
+	b = appendVarint(b, 2, uint64(r.CheckedAt.UnixNano()/int64(time.Microsecond)))
+	b = appendVarint(b, 3, uint64(r.ResponseTimeMs))
+	b = appendVarint(b, 4, uint64(r.ResponseCode))
+	b = appendVarint(b, 5, protowire.EncodeBool(r.Matched))
+	b = appendVarint(b, 6, protowire.EncodeBool(r.Maintenance))
+	b = appendVarint(b, 7, protowire.EncodeBool(r.Unreachable))
+	if r.Source != "" {
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendString(b, r.Source)
+	}
+	for _, k := range models.LabelKeys(r.Labels) {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, r.Labels[k])
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b, nil
+}
+
+// appendVarint appends a varint field, leaving out zero values as proto3 does
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte) (*models.CheckResult, error) {
+	r := &resultV1{CheckedAt: time.Unix(0, 0).UTC()}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch {
+		case typ == protowire.VarintType && num >= 1 && num <= 7:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			switch num {
+			case 1:
+				r.SiteID = int(int64(v))
+			case 2:
+				r.CheckedAt = time.Unix(0, int64(v)*int64(time.Microsecond)).UTC()
+			case 3:
+				r.ResponseTimeMs = int64(v)
+			case 4:
+				r.ResponseCode = int(int32(v))
+			case 5:
+				r.Matched = protowire.DecodeBool(v)
+			case 6:
+				r.Maintenance = protowire.DecodeBool(v)
+			case 7:
+				r.Unreachable = protowire.DecodeBool(v)
+			}
+		case typ == protowire.BytesType && num == 8:
+			v, n := protowire.ConsumeString(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			r.Source = v
+		case typ == protowire.BytesType && num == 9:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			k, val, err := consumeEntry(v)
+			if err != nil {
+				return nil, err
+			}
+			if r.Labels == nil {
+				r.Labels = make(map[string]string)
+			}
+			r.Labels[k] = val
+		default:
+			// fields of later versions are skipped
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+		}
+	}
+	return r.result(), nil
+}
+
+// consumeEntry decodes a map entry of the labels field
+func consumeEntry(data []byte) (string, string, error) {
+	var key, value string
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ != protowire.BytesType || (num != 1 && num != 2) {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return "", "", protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		v, n := protowire.ConsumeString(data)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		data = data[n:]
+		if num == 1 {
+			key = v
+		} else {
+			value = v
+		}
+	}
+	return key, value, nil
+}
+
+const avroContentType = "avro/binary"
+
+const avroSchema = `{
+  "type": "record",
+  "name": "CheckResult",
+  "namespace": "healthbee.v1",
+  "fields": [
+    {"name": "site_id", "type": "long"},
+    {"name": "checked_at", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+    {"name": "response_time_ms", "type": "long"},
+    {"name": "response_code", "type": "int"},
+    {"name": "matched", "type": "boolean"},
+    {"name": "maintenance", "type": "boolean", "default": false},
+    {"name": "unreachable", "type": "boolean", "default": false},
+    {"name": "source", "type": "string", "default": ""},
+    {"name": "labels", "type": {"type": "map", "values": "string"}, "default": {}}
+  ]
+}`
+
+// AvroCodec encodes check results in the Avro binary encoding, with the healthbee.v1.CheckResult record schema
+type AvroCodec struct {
+	codec *goavro.Codec
+}
+
+func NewAvroCodec() (*AvroCodec, error) {
+	c, err := goavro.NewCodec(avroSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &AvroCodec{codec: c}, nil
+}
+
+func (*AvroCodec) ContentType() string { return avroContentType }
+func (*AvroCodec) SchemaType() string  { return "AVRO" }
+func (*AvroCodec) Schema() string      { return avroSchema }
+
+func (a *AvroCodec) Marshal(res *models.CheckResult) ([]byte, error) {
+	r := toV1(res)
+	labels := make(map[string]interface{}, len(r.Labels))
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	return a.codec.BinaryFromNative(nil, map[string]interface{}{
+		"site_id":          int64(r.SiteID),
+		"checked_at":       r.CheckedAt,
+		"response_time_ms": r.ResponseTimeMs,
+		"response_code":    int32(r.ResponseCode),
+		"matched":          r.Matched,
+		"maintenance":      r.Maintenance,
+		"unreachable":      r.Unreachable,
+		"source":           r.Source,
+		"labels":           labels,
+	})
+}
+
+func (a *AvroCodec) Unmarshal(data []byte) (*models.CheckResult, error) {
+	native, _, err := a.codec.NativeFromBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := native.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: expecting an Avro record", ErrUnknownSchema)
+	}
+	r := &resultV1{}
+	r.SiteID = int(rec["site_id"].(int64))
+	r.CheckedAt = rec["checked_at"].(time.Time).UTC()
+	r.ResponseTimeMs = rec["response_time_ms"].(int64)
+	r.ResponseCode = int(rec["response_code"].(int32))
+	r.Matched = rec["matched"].(bool)
+	r.Maintenance = rec["maintenance"].(bool)
+	r.Unreachable = rec["unreachable"].(bool)
+	r.Source = rec["source"].(string)
+	if labels, ok := rec["labels"].(map[string]interface{}); ok && len(labels) > 0 {
+		r.Labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			r.Labels[k], _ = v.(string)
+		}
+	}
+	return r.result(), nil
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/segmentio/kafka-go"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeResult(t *testing.T) {
+	res := &models.CheckResult{
+		SiteID:         2,
+		At:             time.Date(2021, 3, 1, 12, 30, 15, 123456000, time.UTC),
+		ResponseTime:   models.Period(600 * time.Millisecond),
+		ResponseCode:   200,
+		MatchedPattern: true,
+		Maintenance:    true,
+		Source:         "bee-1",
+		Labels:         map[string]string{"team": "payments", "env": "prod"},
+	}
+	down := &models.CheckResult{
+		SiteID:       3,
+		At:           time.Date(2021, 3, 1, 12, 30, 15, 0, time.UTC),
+		ResponseCode: -1,
+		Unreachable:  true,
+	}
+
+	tests := []struct {
+		name     string
+		encoding string
+		schemaID int
+	}{
+		{name: "JSON", encoding: "json"},
+		{name: "Protobuf", encoding: "protobuf"},
+		{name: "Avro", encoding: "avro"},
+		{name: "JSON with schema ID", encoding: "json", schemaID: 7},
+		{name: "Protobuf with schema ID", encoding: "protobuf", schemaID: 7},
+		{name: "Avro with schema ID", encoding: "avro", schemaID: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCodec(tt.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []*models.CheckResult{res, down} {
+				value, headers, err := EncodeResult(c, tt.schemaID, want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.schemaID != 0 && (value[0] != 0 || value[4] != byte(tt.schemaID)) {
+					t.Errorf("want value framed with schema ID %d, got %v", tt.schemaID, value[:5])
+				}
+				got, err := DecodeResult(kafka.Message{Value: value, Headers: headers})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("want %+v, got %+v", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeResult(t *testing.T) {
+	t.Run("Unversioned message", func(t *testing.T) {
+		value := []byte(`{"id":0,"site_id":1,"at":"2021-03-01T12:30:15Z","response_time":"600ms","response_code":200,"matched":true}`)
+		res, err := DecodeResult(kafka.Message{Value: value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.SiteID != 1 || res.ResponseTime != models.Period(600*time.Millisecond) || !res.MatchedPattern {
+			t.Errorf("want result of site 1, got %+v", res)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		headers []kafka.Header
+	}{
+		{
+			name:    "Unknown version",
+			headers: []kafka.Header{{Key: HeaderSchemaVersion, Value: []byte("2")}, {Key: HeaderContentType, Value: []byte("application/json")}},
+		},
+		{
+			name:    "Unknown content type",
+			headers: []kafka.Header{{Key: HeaderSchemaVersion, Value: []byte("1")}, {Key: HeaderContentType, Value: []byte("text/plain")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeResult(kafka.Message{Value: []byte(`{}`), Headers: tt.headers})
+			if !errors.Is(err, ErrUnknownSchema) {
+				t.Errorf("want %v, got %v", ErrUnknownSchema, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,449 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrCorruptSpool = errors.New("spool: corrupt record")
+
+// recordHeader is the length and the CRC-32C checksum of the payload that follow it
+const recordHeader = 8
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// segment is a spool file, holding records in the order they were appended.
+// Records are numbered in the order they were appended, starting with first
+type segment struct {
+	id      int64
+	path    string
+	size    int64
+	records int
+	first   int64
+}
+
+// Spool is a write-ahead log of check results on local disk, buffering results while they cannot be published.
+// Results are appended to segment files that are rotated once they reach SegmentBytes, and are read back in order.
+// Once the spool grows beyond MaxBytes, the oldest segments are evicted.
+// It is safe for concurrent use
+type Spool struct {
+	sync.Mutex
+	MaxBytes     int64
+	SegmentBytes int64
+	dir          string
+	segments     []*segment
+	active       *os.File
+	// head is the position of the next record to read in the oldest segment
+	head        int64
+	headRecords int
+	evicted     int
+}
+
+// OpenSpool opens the spool in the given directory, creating it if needed. Records of earlier runs are kept,
+// apart from any incomplete or corrupt records at the end of a segment, as left behind by a crash.
+// Since read positions are not persisted, records of a partially read segment are read again after a restart
+func OpenSpool(dir string, maxBytes, segmentBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{MaxBytes: maxBytes, SegmentBytes: segmentBytes, dir: dir}
+	for _, f := range files {
+		id, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(f), ".seg"), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg, err := recoverSegment(id, f)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].id < s.segments[j].id
+	})
+	for i := 1; i < len(s.segments); i++ {
+		prev := s.segments[i-1]
+		s.segments[i].first = prev.first + int64(prev.records)
+	}
+	if len(s.segments) == 0 {
+		if err := s.rotate(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	last := s.segments[len(s.segments)-1]
+	s.active, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// recoverSegment counts the records of a segment file, truncating it after the last valid record
+func recoverSegment(id int64, path string) (*segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seg := &segment{id: id, path: path}
+	r := bufio.NewReader(f)
+	for {
+		n, err := skipRecord(r)
+		if err == io.EOF {
+			return seg, nil
+		}
+		if err != nil {
+			warnLog.Printf("spool: truncating segment %s at offset %d: %s", path, seg.size, err)
+			return seg, os.Truncate(path, seg.size)
+		}
+		seg.size += n
+		seg.records++
+	}
+}
+
+// readRecord reads a record, verifying its checksum
+func readRecord(r io.Reader) ([]byte, error) {
+	var h [recordHeader]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrCorruptSpool
+		}
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(h[:4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, ErrCorruptSpool
+	}
+	if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(h[4:]) {
+		return nil, ErrCorruptSpool
+	}
+	return payload, nil
+}
+
+// skipRecord reads a record, returning its size
+func skipRecord(r io.Reader) (int64, error) {
+	payload, err := readRecord(r)
+	if err != nil {
+		return 0, err
+	}
+	return int64(recordHeader + len(payload)), nil
+}
+
+// rotate starts a new segment for appending
+func (s *Spool) rotate() error {
+	var id, first int64 = 1, 0
+	if len(s.segments) > 0 {
+		last := s.segments[len(s.segments)-1]
+		id, first = last.id+1, last.first+int64(last.records)
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d.seg", id))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if s.active != nil {
+		s.active.Close()
+	}
+	s.active = f
+	s.segments = append(s.segments, &segment{id: id, path: path, first: first})
+	return nil
+}
+
+// Append adds a check result to the end of the spool, syncing it to disk
+func (s *Spool) Append(res *models.CheckResult) error {
+	payload, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, recordHeader, recordHeader+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(payload, castagnoli))
+	record = append(record, payload...)
+
+	s.Lock()
+	defer s.Unlock()
+	last := s.segments[len(s.segments)-1]
+	if last.size > 0 && last.size+int64(len(record)) > s.SegmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		last = s.segments[len(s.segments)-1]
+	}
+	if _, err := s.active.Write(record); err != nil {
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	last.size += int64(len(record))
+	last.records++
+	s.evict()
+	return nil
+}
+
+// evict drops the oldest segments while the spool is too large, always keeping the segment being appended to
+func (s *Spool) evict() {
+	for s.bytes() > s.MaxBytes && len(s.segments) > 1 {
+		oldest := s.segments[0]
+		dropped := oldest.records - s.headRecords
+		warnLog.Printf("spool: size limit reached, evicting %d results in segment %s", dropped, oldest.path)
+		if err := os.Remove(oldest.path); err != nil {
+			warnLog.Printf("spool: unable to remove segment %s: %s", oldest.path, err)
+		}
+		s.evicted += dropped
+		s.segments = s.segments[1:]
+		s.head, s.headRecords = 0, 0
+	}
+}
+
+// bytes is the size of the records that are yet to be read
+func (s *Spool) bytes() int64 {
+	var n int64
+	for _, seg := range s.segments {
+		n += seg.size
+	}
+	return n - s.head
+}
+
+// Len returns the number of results that are yet to be read
+func (s *Spool) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.len()
+}
+
+func (s *Spool) len() int {
+	n := 0
+	for _, seg := range s.segments {
+		n += seg.records
+	}
+	return n - s.headRecords
+}
+
+// Peek reads up to n results from the start of the spool, in the order they were appended, without removing them.
+// It also returns the position after the last result read, to pass to Advance once the results are published
+func (s *Spool) Peek(n int) ([]*models.CheckResult, int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	results := make([]*models.CheckResult, 0)
+	offset := s.head
+	for _, seg := range s.segments {
+		if len(results) == n {
+			break
+		}
+		err := s.read(seg, offset, n-len(results), func(payload []byte) error {
+			res := &models.CheckResult{}
+			if err := json.Unmarshal(payload, res); err != nil {
+				return err
+			}
+			results = append(results, res)
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		offset = 0
+	}
+	return results, s.segments[0].first + int64(s.headRecords+len(results)), nil
+}
+
+// read passes up to n records of a segment, starting at the given offset, to f
+func (s *Spool) read(seg *segment, offset int64, n int, f func(payload []byte) error) error {
+	if offset >= seg.size {
+		return nil
+	}
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(io.NewSectionReader(file, offset, seg.size-offset))
+	for i := 0; i < n; i++ {
+		payload, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := f(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Advance removes the results before the given position from the spool, once they have been published.
+// Results that were evicted since they were read are skipped, so that only the results read by Peek are removed.
+// Segments that have been read completely are deleted, apart from the segment being appended to
+func (s *Spool) Advance(next int64) error {
+	s.Lock()
+	defer s.Unlock()
+	for s.len() > 0 {
+		seg := s.segments[0]
+		n := next - seg.first - int64(s.headRecords)
+		if n <= 0 {
+			break
+		}
+		head := s.head
+		err := s.read(seg, s.head, int(n), func(payload []byte) error {
+			s.head += int64(recordHeader + len(payload))
+			s.headRecords++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if s.head < seg.size {
+			if s.head == head {
+				return ErrCorruptSpool
+			}
+			continue
+		}
+		if len(s.segments) == 1 {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+		s.segments = s.segments[1:]
+		s.head, s.headRecords = 0, 0
+	}
+	return nil
+}
+
+// Close closes the segment being appended to
+func (s *Spool) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.active.Close()
+}
+
+var (
+	spoolRecords  = prometheus.NewDesc("healthbee_spool_results", "Number of results in the spool, waiting to be published.", nil, nil)
+	spoolBytes    = prometheus.NewDesc("healthbee_spool_bytes", "Size of the results in the spool.", nil, nil)
+	spoolSegments = prometheus.NewDesc("healthbee_spool_segments", "Number of segment files of the spool.", nil, nil)
+	spoolEvicted  = prometheus.NewDesc("healthbee_spool_evicted_total", "Number of results evicted from the spool as it reached its size limit.", nil, nil)
+)
+
+// Describe sends the descriptors of the spool metrics
+func (s *Spool) Describe(ch chan<- *prometheus.Desc) {
+	ch <- spoolRecords
+	ch <- spoolBytes
+	ch <- spoolSegments
+	ch <- spoolEvicted
+}
+
+// Collect sends the depth of the spool
+func (s *Spool) Collect(ch chan<- prometheus.Metric) {
+	s.Lock()
+	defer s.Unlock()
+	ch <- prometheus.MustNewConstMetric(spoolRecords, prometheus.GaugeValue, float64(s.len()))
+	ch <- prometheus.MustNewConstMetric(spoolBytes, prometheus.GaugeValue, float64(s.bytes()))
+	ch <- prometheus.MustNewConstMetric(spoolSegments, prometheus.GaugeValue, float64(len(s.segments)))
+	ch <- prometheus.MustNewConstMetric(spoolEvicted, prometheus.CounterValue, float64(s.evicted))
+}
+
+// SpoolingPublisher publishes check results with another publisher, spooling them to local disk whenever that fails.
+// Spooled results are replayed in order every RetryInterval, and results published while the spool is not empty
+// are spooled as well, so that results are published in the order they were checked
+type SpoolingPublisher struct {
+	Publisher     Publisher
+	Spool         *Spool
+	RetryInterval time.Duration
+}
+
+// Publish publishes a check result, or spools it if the spool holds results or publishing fails
+func (p *SpoolingPublisher) Publish(ctx context.Context, res *models.CheckResult) error {
+	if p.Spool.Len() == 0 {
+		err := p.Publisher.Publish(ctx, res)
+		if err == nil {
+			return nil
+		}
+		warnLog.Printf("spool: site[%d] spooling result at %s, publish failed with: %s", res.SiteID, res.At.Format(time.Stamp), err)
+	}
+	if err := p.Spool.Append(res); err != nil {
+		return fmt.Errorf("spool failed with: %s", err)
+	}
+	return nil
+}
+
+// PublishBatch publishes the check results in a single batch, or spools all of them if the spool holds results
+// or publishing fails
+func (p *SpoolingPublisher) PublishBatch(ctx context.Context, results []*models.CheckResult) error {
+	if p.Spool.Len() == 0 {
+		err := publishBatch(ctx, p.Publisher, results)
+		if err == nil {
+			return nil
+		}
+		warnLog.Printf("spool: spooling %d results, publish failed with: %s", len(results), err)
+	}
+	for _, res := range results {
+		if err := p.Spool.Append(res); err != nil {
+			return fmt.Errorf("spool failed with: %s", err)
+		}
+	}
+	return nil
+}
+
+// Start replays the spooled results in the background, until the Context is cancelled
+func (p *SpoolingPublisher) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(p.RetryInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.replay(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// replay publishes spooled results in batches of 100, until the spool is empty or publishing fails
+func (p *SpoolingPublisher) replay(ctx context.Context) {
+	for {
+		results, next, err := p.Spool.Peek(100)
+		if err != nil {
+			warnLog.Printf("spool: unable to read spooled results: %s", err)
+			return
+		}
+		if len(results) == 0 {
+			return
+		}
+		if err := publishBatch(ctx, p.Publisher, results); err != nil {
+			warnLog.Printf("spool: unable to replay %d spooled results: %s", p.Spool.Len(), err)
+			return
+		}
+		if err := p.Spool.Advance(next); err != nil {
+			warnLog.Printf("spool: unable to advance spool: %s", err)
+			return
+		}
+		infoLog.Printf("spool: replayed %d spooled results", len(results))
+	}
+}
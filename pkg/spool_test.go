@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"github.com/dnataraj/healthbee/pkg/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSpool(t *testing.T, maxBytes, segmentBytes int64) (*Spool, string) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	s, err := OpenSpool(dir, maxBytes, segmentBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func appendResults(t *testing.T, s *Spool, from, to int) {
+	for i := from; i <= to; i++ {
+		if err := s.Append(&models.CheckResult{SiteID: i, At: time.Now().UTC(), ResponseCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func siteIDs(results []*models.CheckResult) []int {
+	ids := make([]int, 0, len(results))
+	for _, res := range results {
+		ids = append(ids, res.SiteID)
+	}
+	return ids
+}
+
+func TestSpool(t *testing.T) {
+	// small segments, so that the results span several of them
+	s, dir := newTestSpool(t, 1<<20, 256)
+	appendResults(t, s, 1, 10)
+	if s.Len() != 10 {
+		t.Fatalf("want 10 results, got %d", s.Len())
+	}
+	if len(s.segments) < 3 {
+		t.Errorf("want results spread over segments, got %d segments", len(s.segments))
+	}
+
+	results, next, err := s.Peek(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := siteIDs(results); len(got) != 4 || got[0] != 1 || got[3] != 4 {
+		t.Errorf("want results for sites 1 to 4, got %v", got)
+	}
+	if err := s.Advance(next); err != nil {
+		t.Fatal(err)
+	}
+	results, _, err = s.Peek(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := siteIDs(results); len(got) != 6 || got[0] != 5 || got[5] != 10 {
+		t.Errorf("want results for sites 5 to 10, got %v", got)
+	}
+
+	// results that were not advanced past are kept across restarts
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenSpool(dir, 1<<20, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	results, next, err = s.Peek(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := siteIDs(results); len(got) < 6 || got[len(got)-1] != 10 {
+		t.Errorf("want the remaining results up to site 10, got %v", got)
+	}
+	if err := s.Advance(next); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 0 || len(s.segments) != 1 {
+		t.Errorf("want an empty spool with one segment, got %d results in %d segments", s.Len(), len(s.segments))
+	}
+}
+
+func TestSpool_evict(t *testing.T) {
+	s, _ := newTestSpool(t, 512, 256)
+	defer s.Close()
+	appendResults(t, s, 1, 20)
+
+	if s.bytes() > 512+256 {
+		t.Errorf("want the spool within its size limit, got %d bytes", s.bytes())
+	}
+	results, _, err := s.Peek(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the oldest results are evicted first
+	got := siteIDs(results)
+	if len(got)+s.evicted != 20 || got[0] == 1 || got[len(got)-1] != 20 {
+		t.Errorf("want the latest results up to site 20, got %v with %d evicted", got, s.evicted)
+	}
+}
+
+func TestSpool_evictWhilePublishing(t *testing.T) {
+	s, _ := newTestSpool(t, 1024, 256)
+	defer s.Close()
+	appendResults(t, s, 1, 4)
+	results, next, err := s.Peek(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := siteIDs(results); len(got) != 2 || got[1] != 2 {
+		t.Fatalf("want results for sites 1 to 2, got %v", got)
+	}
+
+	// results appended while the peeked results are published evict the oldest segments
+	appendResults(t, s, 5, 20)
+	if s.evicted == 0 {
+		t.Fatal("want results evicted")
+	}
+	remaining, _, err := s.Peek(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Advance(next); err != nil {
+		t.Fatal(err)
+	}
+	// only results that were peeked are removed, any that were not are kept
+	results, _, err = s.Peek(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]int, 0)
+	for _, id := range siteIDs(remaining) {
+		if id > 2 {
+			want = append(want, id)
+		}
+	}
+	if got := siteIDs(results); !reflect.DeepEqual(got, want) || got[len(got)-1] != 20 {
+		t.Errorf("want results %v, got %v", want, got)
+	}
+}
+
+func TestOpenSpool_recover(t *testing.T) {
+	s, dir := newTestSpool(t, 1<<20, 1<<20)
+	appendResults(t, s, 1, 3)
+	s.Close()
+
+	// simulate a crash in the middle of writing a record
+	path := filepath.Join(dir, "00000000000000000001.seg")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 1, 2})
+	f.Close()
+
+	s, err = OpenSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	appendResults(t, s, 4, 4)
+	results, _, err := s.Peek(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := siteIDs(results); len(got) != 4 || got[3] != 4 {
+		t.Errorf("want results for sites 1 to 4, got %v", got)
+	}
+}
+
+// flakyPublisher is a Publisher that fails while down is set
+type flakyPublisher struct {
+	MemoryPublisher
+	down bool
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, res *models.CheckResult) error {
+	if p.down {
+		return errors.New("broker unavailable")
+	}
+	return p.MemoryPublisher.Publish(ctx, res)
+}
+
+func TestSpoolingPublisher(t *testing.T) {
+	s, _ := newTestSpool(t, 1<<20, 1<<20)
+	defer s.Close()
+	flaky := &flakyPublisher{MemoryPublisher: *NewMemoryPublisher(10), down: true}
+	p := &SpoolingPublisher{Publisher: flaky, Spool: s, RetryInterval: time.Hour}
+
+	ctx := context.Background()
+	for i := 1; i <= 2; i++ {
+		if err := p.Publish(ctx, &models.CheckResult{SiteID: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Len() != 2 {
+		t.Fatalf("want 2 spooled results, got %d", s.Len())
+	}
+
+	// once the broker is back, results are still spooled until the spool has been replayed
+	flaky.down = false
+	if err := p.Publish(ctx, &models.CheckResult{SiteID: 3}); err != nil {
+		t.Fatal(err)
+	}
+	p.replay(ctx)
+	if s.Len() != 0 {
+		t.Errorf("want an empty spool, got %d results", s.Len())
+	}
+	for want := 1; want <= 3; want++ {
+		if res := <-flaky.Results(); res.SiteID != want {
+			t.Errorf("want result for site %d, got %d", want, res.SiteID)
+		}
+	}
+}